@@ -6,11 +6,45 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"os"
 	"path"
+	"slices"
 )
 
 type Config struct {
 	ServerURL        string `toml:"server_url"`
 	ReplicationCount int    `toml:"replication_count" env-default:"5"`
+	// ServerURLs are additional trackers, server_url is put first
+	ServerURLs []string `toml:"server_urls"`
+	// TrackerMode is failover (ask trackers in order) or merge (ask all and merge node lists)
+	TrackerMode string `toml:"tracker_mode" env-default:"failover"`
+	// Placement is one of random, capacity, rendezvous or zone
+	Placement string `toml:"placement" env-default:"random"`
+	// SpreadFailureDomains puts replicas of a chunk into different zones, subnets and hosts when possible
+	SpreadFailureDomains bool `toml:"spread_failure_domains" env-default:"true"`
+	// HedgedReads sends a second request for a chunk if the first replica is slower than its p95 latency
+	HedgedReads bool `toml:"hedged_reads" env-default:"false"`
+	// BackupIndex stores an encrypted copy of the file index in the network after every command that
+	// changes it. backup_index_interval, if set, makes it wait that many seconds since the last backup
+	BackupIndex         bool `toml:"backup_index" env-default:"true"`
+	BackupIndexInterval int  `toml:"backup_index_interval" env-default:"0"`
+	// NodesTTL is how many seconds the node list received from trackers is reused by later commands
+	NodesTTL int `toml:"nodes_ttl" env-default:"30"`
+	// WatchNodes follows tracker join and leave events while a command runs
+	WatchNodes bool `toml:"watch_nodes" env-default:"false"`
+	// Discovery is tracker, dht or lan, with dht nodes are found through dht_bootstrap daemons,
+	// with lan by multicast query to lan_group
+	Discovery string `toml:"discovery" env-default:"tracker"`
+	// DHTBootstrap are daemons used to enter the DHT, as ws://host:port or wss://host:port
+	DHTBootstrap []string `toml:"dht_bootstrap"`
+	// LANDiscovery adds daemons found on the local network to those found by the main discovery
+	LANDiscovery bool   `toml:"lan_discovery" env-default:"false"`
+	LANGroup     string `toml:"lan_group" env-default:"239.255.77.77:53590"`
+	// MetadataStore is json (files.json, locked while a command changes it) or bolt (files.db).
+	// On the first run with bolt files.json is imported into files.db and renamed to files.json.imported
+	MetadataStore string `toml:"metadata_store" env-default:"json"`
+	// IndexBackups is how many previous versions of files.json are kept with the json store
+	IndexBackups int `toml:"index_backups" env-default:"5"`
+	// AgentTTL is how many seconds distorage agent keeps decrypted keys
+	AgentTTL int `toml:"agent_ttl" env-default:"900"`
 }
 
 func NewConfig(homeDir string) (*Config, error) {
@@ -24,6 +58,36 @@ func NewConfig(homeDir string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("config error: %w", err)
 	}
+	if cfg.ServerURL != "" && !slices.Contains(cfg.ServerURLs, cfg.ServerURL) {
+		cfg.ServerURLs = append([]string{cfg.ServerURL}, cfg.ServerURLs...)
+	}
+	switch cfg.Discovery {
+	case "tracker":
+		if len(cfg.ServerURLs) == 0 {
+			return nil, fmt.Errorf("config error: no tracker url provided")
+		}
+	case "dht":
+		if len(cfg.DHTBootstrap) == 0 {
+			return nil, fmt.Errorf("config error: no dht bootstrap nodes provided")
+		}
+	case "lan":
+	default:
+		return nil, fmt.Errorf("config error: unknown discovery %s", cfg.Discovery)
+	}
+	if cfg.TrackerMode != "failover" && cfg.TrackerMode != "merge" {
+		return nil, fmt.Errorf("config error: unknown tracker mode %s", cfg.TrackerMode)
+	}
+	if cfg.WatchNodes && (cfg.Discovery != "tracker" || cfg.LANDiscovery) {
+		return nil, fmt.Errorf("config error: watch_nodes works only with tracker discovery")
+	}
+	if cfg.MetadataStore != "bolt" && cfg.MetadataStore != "json" {
+		return nil, fmt.Errorf("config error: unknown metadata store %s", cfg.MetadataStore)
+	}
+	switch cfg.Placement {
+	case "random", "capacity", "rendezvous", "zone":
+	default:
+		return nil, fmt.Errorf("config error: unknown placement strategy %s", cfg.Placement)
+	}
 
 	return cfg, nil
 }
@@ -6,6 +6,7 @@ import (
 	"cli/internal/usecase"
 	"github.com/urfave/cli/v2"
 	"path"
+	"time"
 )
 
 func InitApp(cfg *config.Config, homeDir string) *cli.App {
@@ -25,14 +26,69 @@ func InitApp(cfg *config.Config, homeDir string) *cli.App {
 			},
 		},
 	}
-	cryptoUC := usecase.NewCryptoUC(path.Join(homeDir, ".distorage", "keys.json"))
-	storageUC := usecase.NewStorageUC(path.Join(homeDir, ".distorage", "files.json"))
+	agentClient := usecase.NewAgentClient(path.Join(homeDir, ".distorage", "agent", "agent.sock"))
+	keysUC := usecase.NewKeysUC(
+		path.Join(homeDir, ".distorage", "keys.json"),
+		agentClient,
+		func() ([]byte, error) { return c.ReadPassphrase("keystore passphrase", false) },
+	)
+	cryptoUC := usecase.NewCryptoUC(keysUC, agentClient)
+	var storageUC usecase.Storage = usecase.NewStorageUC(
+		path.Join(homeDir, ".distorage", "files.json"),
+		usecase.DEFAULT_INDEX_BACKUPS,
+	)
 	if cfg != nil {
-		serverUC := usecase.NewServerUC(cfg.ServerURL)
-		commands := c.NewCommands(cfg, cryptoUC, serverUC, storageUC)
+		switch cfg.MetadataStore {
+		case "bolt":
+			storageUC = usecase.NewBoltStorageUC(
+				path.Join(homeDir, ".distorage", "files.db"),
+				path.Join(homeDir, ".distorage", "files.json"),
+			)
+		case "json":
+			storageUC = usecase.NewStorageUC(path.Join(homeDir, ".distorage", "files.json"), cfg.IndexBackups)
+		}
+		var serverUC usecase.Server
+		var events usecase.NodeEvents
+		var locator usecase.ChunkLocator
+		switch cfg.Discovery {
+		case "dht":
+			dhtUC := usecase.NewDHTServerUC(cfg.DHTBootstrap, cryptoUC)
+			serverUC = dhtUC
+			locator = dhtUC
+		case "lan":
+			serverUC = usecase.NewLANServerUC(cfg.LANGroup, cryptoUC)
+		default:
+			trackerUC := usecase.NewServerUC(
+				cfg.ServerURLs,
+				cfg.TrackerMode,
+				path.Join(homeDir, ".distorage", "nodes_cache.json"),
+				time.Duration(cfg.NodesTTL)*time.Second,
+			)
+			serverUC = trackerUC
+			if cfg.WatchNodes {
+				events = trackerUC
+			}
+		}
+		if cfg.LANDiscovery && cfg.Discovery != "lan" {
+			serverUC = usecase.NewMergedServerUC(serverUC, usecase.NewLANServerUC(cfg.LANGroup, cryptoUC))
+		}
+		// one view of the cluster for the whole command
+		directoryUC := usecase.NewDirectoryUC(serverUC, events)
+		statsUC := usecase.NewStatsUC(path.Join(homeDir, ".distorage", "nodes.json"))
+		placement := usecase.NewPlacementStrategy(cfg.Placement)
+		if cfg.SpreadFailureDomains {
+			placement = &usecase.DomainSpreadPlacement{Inner: placement}
+		}
+		placement = &usecase.StatsPlacement{Inner: placement, Stats: statsUC}
+		commands := c.NewCommands(cfg, cryptoUC, directoryUC, storageUC, placement, statsUC, locator, keysUC, agentClient)
+		// stats are collected in memory during the command and written once
+		app.After = func(*cli.Context) error {
+			directoryUC.Close()
+			return statsUC.Save()
+		}
 		app.Commands = commands.GetCommands()
 	} else {
-		app.Commands = c.InitCommandOnly(cryptoUC, storageUC)
+		app.Commands = c.InitCommandOnly(cryptoUC, storageUC, keysUC)
 	}
 
 	return app
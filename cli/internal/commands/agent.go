@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"cli/internal/usecase"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func (c *Commands) GetAgentCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "agent",
+		Usage: "keep decrypted keys in memory so the passphrase isn't asked by every command",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "ttl",
+				Usage: "forget the keys and exit after this time (default: agent_ttl from the config)",
+			},
+		},
+		Action: c.runAgent,
+		Subcommands: []*cli.Command{
+			{
+				Name:   "stop",
+				Usage:  "make the running agent forget the keys",
+				Action: c.stopAgent,
+			},
+		},
+	}
+}
+
+func (c *Commands) runAgent(cCtx *cli.Context) error {
+	if c.agent.Available() {
+		return fmt.Errorf("agent is already running on %s", c.agent.SocketPath())
+	}
+	ttl := time.Duration(c.cfg.AgentTTL) * time.Second
+	if cCtx.IsSet("ttl") {
+		ttl = cCtx.Duration("ttl")
+	}
+	keys, err := c.keys.Read()
+	if err != nil {
+		return err
+	}
+	server, err := usecase.NewAgentServer(c.agent.SocketPath(), keys, c.crypto)
+	if err != nil {
+		return err
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		server.Shutdown()
+	}()
+	if cCtx.Int("verbosity") > 0 {
+		fmt.Printf("agent is listening on %s, keys are kept for %s\n", c.agent.SocketPath(), ttl)
+	}
+	if err := server.Serve(ttl); err != nil {
+		return err
+	}
+	if cCtx.Int("verbosity") > 0 {
+		fmt.Printf("agent stopped, keys are forgotten\n")
+	}
+	return nil
+}
+
+func (c *Commands) stopAgent(cCtx *cli.Context) error {
+	if !c.agent.Available() {
+		return fmt.Errorf("agent is not running")
+	}
+	if err := c.agent.Stop(); err != nil {
+		return err
+	}
+	if cCtx.Int("verbosity") > 0 {
+		fmt.Printf("agent stopped\n")
+	}
+	return nil
+}
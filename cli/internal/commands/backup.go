@@ -0,0 +1,322 @@
+package commands
+
+import (
+	"cli/internal/entity"
+	"cmp"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+	"log"
+	"slices"
+	"time"
+)
+
+func (c *Commands) GetBackupIndexCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "backup-index",
+		Usage: "store an encrypted copy of the file index in the network",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "force",
+				Value: false,
+				Usage: "store the index even if it didn't change since the last backup",
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			return c.backupIndex(cCtx.Int("verbosity"), cCtx.Bool("force"))
+		},
+	}
+}
+
+func (c *Commands) GetRestoreIndexCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "restore-index",
+		Usage:  "rebuild the file index from its backup in the network, existing entries are kept",
+		Action: c.restoreIndex,
+	}
+}
+
+// indexPointerHash returns the chunk id of our index backup pointer
+func (c *Commands) indexPointerHash() (string, error) {
+	signer, err := c.crypto.Signer()
+	if err != nil {
+		return "", err
+	}
+	pointerId, err := c.crypto.IndexPointerID(signer)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(pointerId), nil
+}
+
+// backupIndex encrypts the file index with the master key and stores it as ordinary chunks.
+// Their list is encrypted as well and stored under the pointer id derived from our address,
+// so restore-index needs nothing but the keys. Chunks of the previous backup are deleted afterwards
+func (c *Commands) backupIndex(verbosity int, force bool) error {
+	fileInfos, err := c.storage.GetFileInfos()
+	if err != nil {
+		return err
+	}
+	index, err := json.Marshal(fileInfos)
+	if err != nil {
+		return err
+	}
+	indexHash := hex.EncodeToString(c.crypto.Hash(index))
+	previous, err := c.storage.GetIndexBackup()
+	if err != nil {
+		return err
+	}
+	if !force && previous != nil && previous.Hash == indexHash && previous.Pointer != nil {
+		if verbosity > 1 {
+			log.Printf("index backup is up to date\n")
+		}
+		return nil
+	}
+
+	encryptedIndex, err := c.crypto.EncryptWithMasterKey(index)
+	if err != nil {
+		return err
+	}
+	nodes, err := c.getAvailableNodes(verbosity)
+	if err != nil {
+		return err
+	}
+
+	backup := entity.IndexBackup{
+		Seq:    time.Now().UnixNano(),
+		Hash:   indexHash,
+		Size:   len(index),
+		Chunks: make([]entity.ChunkInfo, 0),
+	}
+	for i := 0; i*CHUNK_SIZE < len(encryptedIndex); i++ {
+		chunk := encryptedIndex[i*CHUNK_SIZE : min((i+1)*CHUNK_SIZE, len(encryptedIndex))]
+		chunkHash := hex.EncodeToString(c.crypto.Hash(chunk))
+		storageNodes := c.storeChunk(i, chunkHash, chunk, nodes, verbosity)
+		if len(storageNodes) == 0 {
+			return fmt.Errorf("failed to store index chunk #%d on any nodes", i)
+		}
+		backup.Chunks = append(backup.Chunks, entity.ChunkInfo{Number: i, Hash: chunkHash, Nodes: storageNodes})
+	}
+
+	pointerHash, err := c.indexPointerHash()
+	if err != nil {
+		return err
+	}
+	pointer, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	encryptedPointer, err := c.crypto.EncryptWithMasterKey(pointer)
+	if err != nil {
+		return err
+	}
+	pointerNodes := c.storeChunk(0, pointerHash, encryptedPointer, nodes, verbosity)
+	if len(pointerNodes) == 0 {
+		return errors.New("failed to store index backup pointer on any nodes")
+	}
+	backup.Pointer = &entity.ChunkInfo{Hash: pointerHash, Nodes: pointerNodes}
+	if err := c.storage.SetIndexBackup(backup); err != nil {
+		return err
+	}
+	if verbosity > 0 {
+		fmt.Printf("index backed up in %d chunks, pointer stored on %d nodes\n", len(backup.Chunks), len(pointerNodes))
+	}
+
+	// chunks of the previous backup are not needed anymore, leftovers are collected by gc
+	if previous != nil {
+		for _, chunk := range previous.Chunks {
+			if !slices.ContainsFunc(backup.Chunks, func(ci entity.ChunkInfo) bool { return ci.Hash == chunk.Hash }) {
+				c.deleteChunk(chunk, nodes, verbosity)
+			}
+		}
+	}
+	return nil
+}
+
+// autoBackupIndex backs the index up after commands that change it, if enabled in config.
+// Unchanged index isn't uploaded again. With backup_index_interval set, the backup is skipped
+// if the last one is younger than the interval, the next command catches up
+func (c *Commands) autoBackupIndex(verbosity int) {
+	if !c.cfg.BackupIndex {
+		return
+	}
+	previous, err := c.storage.GetIndexBackup()
+	if err != nil {
+		if verbosity > 0 {
+			fmt.Printf("warning: failed to back up file index: %e\n", err)
+		}
+		return
+	}
+	interval := time.Duration(c.cfg.BackupIndexInterval) * time.Second
+	if interval > 0 && previous != nil && previous.Pointer != nil && time.Since(time.Unix(0, previous.Seq)) < interval {
+		if verbosity > 1 {
+			log.Printf("index was backed up less than %s ago, run distorage backup-index to back it up now\n", interval)
+		}
+		return
+	}
+	if err := c.backupIndex(verbosity, false); err != nil && verbosity > 0 {
+		fmt.Printf("warning: failed to back up file index: %e\n", err)
+	}
+}
+
+// deleteChunk deletes the chunk from all available nodes that store it, errors are only logged
+func (c *Commands) deleteChunk(chunk entity.ChunkInfo, nodes map[string]entity.Node, verbosity int) {
+	for _, nodeAddr := range chunk.Nodes {
+		node, exists := nodes[nodeAddr]
+		if !exists {
+			continue
+		}
+		nodeURL, err := buildNodeURL(node, fmt.Sprintf("/delete/%s", chunk.Hash))
+		if err != nil {
+			continue
+		}
+		conn, _, err := c.nodeDialer(nodeAddr, node).Dial(nodeURL, nil)
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("dial to %s error: %e\n", nodeAddr, err)
+			}
+			continue
+		}
+		err = c.deleteFile(conn)
+		_ = conn.Close()
+		if err != nil && verbosity > 1 {
+			log.Printf("failed to delete chunk %s from %s: %e\n", chunk.Hash, nodeAddr, err)
+		}
+	}
+}
+
+// fetchIndexPointers asks every available node for our index backup pointer.
+// Pointers that can't be decrypted with our key are ignored
+func (c *Commands) fetchIndexPointers(pointerHash string, nodes map[string]entity.Node, verbosity int) ([]entity.IndexBackup, error) {
+	signer, err := c.crypto.Signer()
+	if err != nil {
+		return nil, err
+	}
+	bySeq := make(map[int64]*entity.IndexBackup)
+	for nodeAddr, node := range nodes {
+		nodeURL, err := buildNodeURL(node, fmt.Sprintf("/get/%s", pointerHash))
+		if err != nil {
+			continue
+		}
+		conn, _, err := c.nodeDialer(nodeAddr, node).Dial(nodeURL, nil)
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("dial to %s error: %e\n", nodeAddr, err)
+			}
+			continue
+		}
+		body, err := c.downloadFile(signer, conn)
+		_ = conn.Close()
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("no index pointer on %s: %e\n", nodeAddr, err)
+			}
+			continue
+		}
+		decrypted, err := c.crypto.DecryptWithMasterKey(body)
+		if err != nil {
+			continue
+		}
+		backup := &entity.IndexBackup{}
+		if err := json.Unmarshal(decrypted, backup); err != nil {
+			continue
+		}
+		if known, exists := bySeq[backup.Seq]; exists {
+			backup = known
+		} else {
+			backup.Pointer = &entity.ChunkInfo{Hash: pointerHash}
+			bySeq[backup.Seq] = backup
+		}
+		backup.Pointer.Nodes = append(backup.Pointer.Nodes, nodeAddr)
+	}
+	backups := make([]entity.IndexBackup, 0, len(bySeq))
+	for _, backup := range bySeq {
+		backups = append(backups, *backup)
+	}
+	// the newest backup first
+	slices.SortFunc(backups, func(a, b entity.IndexBackup) int {
+		return cmp.Compare(b.Seq, a.Seq)
+	})
+	return backups, nil
+}
+
+// fetchIndex downloads and decrypts the index stored by the backup
+func (c *Commands) fetchIndex(backup entity.IndexBackup, nodes map[string]entity.Node, verbosity int) (map[uuid2.UUID]entity.FileInfo, error) {
+	encryptedIndex := make([]byte, 0, len(backup.Chunks)*CHUNK_SIZE)
+	for i, chunk := range backup.Chunks {
+		chunkBody, err := c.fetchChunk(i, chunk, nodes, false, verbosity)
+		if err != nil {
+			return nil, err
+		}
+		encryptedIndex = append(encryptedIndex, chunkBody...)
+	}
+	index, err := c.crypto.DecryptWithMasterKey(encryptedIndex)
+	if err != nil {
+		return nil, err
+	}
+	if hash := hex.EncodeToString(c.crypto.Hash(index)); hash != backup.Hash {
+		return nil, fmt.Errorf("hash mismatch: backup %s, got %s", backup.Hash, hash)
+	}
+	fileInfos := make(map[uuid2.UUID]entity.FileInfo)
+	if err := json.Unmarshal(index, &fileInfos); err != nil {
+		return nil, err
+	}
+	return fileInfos, nil
+}
+
+func (c *Commands) restoreIndex(cCtx *cli.Context) error {
+	verbosity := cCtx.Int("verbosity")
+	nodes, err := c.getAvailableNodes(verbosity)
+	if err != nil {
+		return err
+	}
+	pointerHash, err := c.indexPointerHash()
+	if err != nil {
+		return err
+	}
+	backups, err := c.fetchIndexPointers(pointerHash, nodes, verbosity)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return errors.New("no index backup found on available nodes")
+	}
+
+	// older backups are tried if chunks of the newest one are unavailable
+	for _, backup := range backups {
+		restored, err := c.fetchIndex(backup, nodes, verbosity)
+		if err != nil {
+			if verbosity > 0 {
+				fmt.Printf("failed to restore backup from %s: %e\n", time.Unix(0, backup.Seq).Format(time.DateTime), err)
+			}
+			continue
+		}
+		// entries are merged in one transaction, so files uploaded meanwhile are kept
+		added := 0
+		err = c.storage.Modify(func(fileInfos map[uuid2.UUID]entity.FileInfo) error {
+			added = 0
+			for uuid, fileInfo := range restored {
+				if _, exists := fileInfos[uuid]; !exists {
+					fileInfos[uuid] = fileInfo
+					added += 1
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := c.storage.SetIndexBackup(backup); err != nil {
+			return err
+		}
+		if verbosity > 0 {
+			fmt.Printf("restored %d files from backup made at %s, %d files were already known\n",
+				added, time.Unix(0, backup.Seq).Format(time.DateTime), len(restored)-added)
+		}
+		return nil
+	}
+	return errors.New("no index backup could be restored")
+}
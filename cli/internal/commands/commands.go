@@ -62,18 +62,33 @@ import (
 const CHUNK_SIZE = 1 << (10 * 2) // 1 MB
 
 type Commands struct {
-	cfg     *config.Config
-	crypto  usecase.Crypto
-	server  usecase.Server
-	storage usecase.Storage
+	cfg       *config.Config
+	crypto    usecase.Crypto
+	server    usecase.Server
+	storage   usecase.Storage
+	placement usecase.PlacementStrategy
+	stats     usecase.NodeStats
+	locator   usecase.ChunkLocator
+	keys      usecase.KeyStore
+	agent     usecase.KeyAgent
 }
 
-func NewCommands(cfg *config.Config, c usecase.Crypto, s usecase.Server, st usecase.Storage) *Commands {
-	return &Commands{cfg: cfg, crypto: c, server: s, storage: st}
+func NewCommands(
+	cfg *config.Config,
+	c usecase.Crypto,
+	s usecase.Server,
+	st usecase.Storage,
+	p usecase.PlacementStrategy,
+	ns usecase.NodeStats,
+	l usecase.ChunkLocator,
+	k usecase.KeyStore,
+	a usecase.KeyAgent,
+) *Commands {
+	return &Commands{cfg: cfg, crypto: c, server: s, storage: st, placement: p, stats: ns, locator: l, keys: k, agent: a}
 }
 
-func InitCommandOnly(c usecase.Crypto, s usecase.Storage) []*cli.Command {
-	commands := NewCommands(nil, c, nil, s)
+func InitCommandOnly(c usecase.Crypto, s usecase.Storage, k usecase.KeyStore) []*cli.Command {
+	commands := NewCommands(nil, c, nil, s, nil, nil, nil, k, nil)
 	return []*cli.Command{commands.GetInitCommand()}
 }
 
@@ -83,6 +98,16 @@ func (c *Commands) GetCommands() []*cli.Command {
 		c.GetDownloadCommand(),
 		c.GetListCommand(),
 		c.GetDeleteCommand(),
+		c.GetGCCommand(),
+		c.GetRepairCommand(),
+		c.GetRelocateCommand(),
+		c.GetNodesCommand(),
+		c.GetVerifyCommand(),
+		c.GetLocateCommand(),
+		c.GetBackupIndexCommand(),
+		c.GetRestoreIndexCommand(),
+		c.GetKeysCommand(),
+		c.GetAgentCommand(),
 		c.GetInitCommand(),
 	}
 }
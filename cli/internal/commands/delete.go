@@ -9,7 +9,6 @@ import (
 	"github.com/schollz/progressbar/v3"
 	"github.com/urfave/cli/v2"
 	"log"
-	"net/url"
 )
 
 func (c *Commands) GetDeleteCommand() *cli.Command {
@@ -22,15 +21,15 @@ func (c *Commands) GetDeleteCommand() *cli.Command {
 }
 
 func (c *Commands) deleteFile(conn *websocket.Conn) error {
-	ecdsaPrivKey, err := c.crypto.ReadECDSAPrivKey()
+	signer, err := c.crypto.Signer()
 	if err != nil {
 		return err
 	}
-	sharedKey, err := c.executePreamble(ecdsaPrivKey, conn)
+	sharedKey, err := c.executePreamble(signer, conn)
 	if err != nil {
 		return err
 	}
-	verification, err := c.crypto.PrepareVerification(sharedKey, ecdsaPrivKey)
+	verification, err := c.crypto.PrepareVerification(sharedKey, signer)
 
 	err = conn.WriteMessage(websocket.BinaryMessage, verification)
 	if err != nil {
@@ -52,6 +51,9 @@ func (c *Commands) deleteFile(conn *websocket.Conn) error {
 
 func (c *Commands) delete(cCtx *cli.Context) error {
 	verbosity := cCtx.Int("verbosity") //переменная отображает сколько текста вывести.
+	if err := c.unlockKeys(); err != nil {
+		return err
+	}
 	if !cCtx.Bool("no-cleanup") {
 		totalFiles, deletedFiles, err := c.Cleanup(cCtx)
 		if verbosity > 0 {
@@ -77,7 +79,7 @@ func (c *Commands) delete(cCtx *cli.Context) error {
 	}
 
 	// get info about all available nodes
-	nodes, err := c.server.GetAvailableNodes()
+	nodes, err := c.getAvailableNodes(verbosity)
 	if err != nil {
 		return err
 	}
@@ -91,7 +93,7 @@ func (c *Commands) delete(cCtx *cli.Context) error {
 	for i, chunk := range fileInfo.Chunks {
 		leftNodes := make([]string, 0)
 		for _, nodeAddr := range chunk.Nodes {
-			nodeIp, exists := nodes[nodeAddr]
+			node, exists := nodes[nodeAddr]
 			if !exists {
 				if verbosity > 1 {
 					log.Printf("node %s unavailable, continuing\n", nodeAddr)
@@ -99,9 +101,7 @@ func (c *Commands) delete(cCtx *cli.Context) error {
 				leftNodes = append(leftNodes, nodeAddr)
 				continue
 			}
-			nodeIp = fmt.Sprintf("%s:53591", nodeIp)
-			u := url.URL{Scheme: "ws", Host: nodeIp, Path: fmt.Sprintf("/delete/%s", chunk.Hash)}
-			nodeURL, err := url.PathUnescape(u.String())
+			nodeURL, err := buildNodeURL(node, fmt.Sprintf("/delete/%s", chunk.Hash))
 			if err != nil {
 				if verbosity > 1 {
 					log.Printf("error decoding node URL: %e\n", err)
@@ -111,7 +111,7 @@ func (c *Commands) delete(cCtx *cli.Context) error {
 			if verbosity > 1 {
 				log.Printf("connecting to %s\n", nodeURL)
 			}
-			conn, _, err := websocket.DefaultDialer.Dial(nodeURL, nil)
+			conn, _, err := c.nodeDialer(nodeAddr, node).Dial(nodeURL, nil)
 			if err != nil {
 				if verbosity > 1 {
 					log.Printf("dial error :%e\n", err)
@@ -163,6 +163,7 @@ func (c *Commands) delete(cCtx *cli.Context) error {
 			fmt.Printf("not all nodes were available, info will be cleaned later\n")
 		}
 	}
+	c.autoBackupIndex(verbosity)
 	return nil
 
 }
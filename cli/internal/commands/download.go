@@ -2,37 +2,53 @@ package commands
 
 import (
 	"bytes"
+	"cli/internal/entity"
+	"crypto"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	uuid2 "github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/schollz/progressbar/v3"
 	"github.com/urfave/cli/v2"
 	"log"
-	"net/url"
 	"os"
 	"path"
+	"sync"
+	"time"
 )
 
+const (
+	// HEDGE_PERCENTILE of the first replica's latency after which a hedged request is sent
+	HEDGE_PERCENTILE = 0.95
+	// HEDGE_DEFAULT_DELAY is used for replicas without latency measurements
+	HEDGE_DEFAULT_DELAY = time.Second
+)
+
+var errHedgeCancelled = errors.New("request cancelled, chunk received from another replica")
+
 func (c *Commands) GetDownloadCommand() *cli.Command {
 	return &cli.Command{
 		Name:    "download",
 		Aliases: []string{"d"},
 		Usage:   "download a file from the system",
-		Action:  c.download,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "hedge",
+				Value: false,
+				Usage: "request a chunk from a second replica if the first one is slower than usual",
+			},
+		},
+		Action: c.download,
 	}
 }
 
-func (c *Commands) downloadFile(conn *websocket.Conn) ([]byte, error) {
-	ecdsaPrivKey, err := c.crypto.ReadECDSAPrivKey()
+func (c *Commands) downloadFile(signer crypto.Signer, conn *websocket.Conn) ([]byte, error) {
+	sharedKey, err := c.executePreamble(signer, conn)
 	if err != nil {
 		return nil, err
 	}
-	sharedKey, err := c.executePreamble(ecdsaPrivKey, conn)
-	if err != nil {
-		return nil, err
-	}
-	verification, err := c.crypto.PrepareVerification(sharedKey, ecdsaPrivKey)
+	verification, err := c.crypto.PrepareVerification(sharedKey, signer)
 
 	err = conn.WriteMessage(websocket.BinaryMessage, verification)
 	if err != nil {
@@ -56,10 +72,187 @@ func (c *Commands) downloadFile(conn *websocket.Conn) ([]byte, error) {
 
 }
 
-// return fmt.Errorf("no nodes available for chunk #%d, sorry :(", i)
+// fetchReplica downloads the chunk from one node, checks its hash and records the outcome in node stats.
+// Requests aborted because another replica answered first are not counted as failures
+func (c *Commands) fetchReplica(nodeAddr string, node entity.Node, chunk entity.ChunkInfo, conns *hedgedConns) ([]byte, error) {
+	signer, err := c.crypto.Signer()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	chunkBody, err := c.requestChunk(nodeAddr, node, chunk, signer, conns)
+	if err != nil && !errors.Is(err, errHedgeCancelled) {
+		// while keys rotate hasn't finished, the node may still keep the chunk under the previous address
+		if previousSigner, keyErr := c.crypto.PreviousSigner(); keyErr == nil {
+			chunkBody, err = c.requestChunk(nodeAddr, node, chunk, previousSigner, conns)
+		}
+	}
+	if err != nil {
+		if !errors.Is(err, errHedgeCancelled) {
+			c.stats.RecordFailure(nodeAddr)
+		}
+		return nil, err
+	}
+	if bodyHash := hex.EncodeToString(c.crypto.Hash(chunkBody)); chunk.Hash != bodyHash {
+		c.stats.RecordIntegrityFailure(nodeAddr)
+		return nil, fmt.Errorf("integrity check failed: stored %s, received %s", chunk.Hash, bodyHash)
+	}
+	c.stats.RecordSuccess(nodeAddr, time.Since(start))
+	return chunkBody, nil
+}
+
+// requestChunk downloads the chunk from the node on behalf of the owner of signer
+func (c *Commands) requestChunk(nodeAddr string, node entity.Node, chunk entity.ChunkInfo, signer crypto.Signer, conns *hedgedConns) ([]byte, error) {
+	nodeURL, err := buildNodeURL(node, fmt.Sprintf("/get/%s", chunk.Hash))
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := c.nodeDialer(nodeAddr, node).Dial(nodeURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if !conns.add(conn) {
+		return nil, errHedgeCancelled
+	}
+	chunkBody, err := c.downloadFile(signer, conn)
+	if err != nil && conns.isCancelled() {
+		return nil, errHedgeCancelled
+	}
+	return chunkBody, err
+}
+
+type replicaResult struct {
+	nodeAddr string
+	body     []byte
+	err      error
+}
+
+// fetchChunk tries available replicas in order of health and latency. With hedging, if the first
+// replica hasn't answered within its p95 latency, the next one is requested in parallel
+// and whichever answers first wins
+func (c *Commands) fetchChunk(i int, chunk entity.ChunkInfo, nodes map[string]entity.Node, hedge bool, verbosity int) ([]byte, error) {
+	candidates := make([]string, 0, len(chunk.Nodes))
+	for _, nodeAddr := range c.stats.OrderReplicas(chunk.Nodes) {
+		if _, exists := nodes[nodeAddr]; exists {
+			candidates = append(candidates, nodeAddr)
+		} else if verbosity > 1 {
+			log.Printf("node %s unavailable, continuing\n", nodeAddr)
+		}
+	}
+	if len(candidates) == 0 && c.locator != nil {
+		// none of the recorded replicas is known, ask the DHT who stores the chunk now
+		if verbosity > 1 {
+			log.Printf("looking up providers of chunk #%d in DHT\n", i)
+		}
+		providers, err := c.locator.LocateChunk(chunk.Hash)
+		if err != nil && verbosity > 1 {
+			log.Printf("DHT lookup error: %e\n", err)
+		}
+		for nodeAddr, node := range providers {
+			nodes[nodeAddr] = node
+			candidates = append(candidates, nodeAddr)
+		}
+		candidates = c.stats.OrderReplicas(candidates)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no nodes available for chunk #%d, sorry :(", i)
+	}
+
+	conns := &hedgedConns{}
+	defer conns.cancel()
+	results := make(chan replicaResult, len(candidates))
+	next := 0
+	inflight := 0
+	request := func() {
+		nodeAddr := candidates[next]
+		next += 1
+		inflight += 1
+		if verbosity > 1 {
+			log.Printf("fetching chunk #%d from %s\n", i, nodeAddr)
+		}
+		go func() {
+			body, err := c.fetchReplica(nodeAddr, nodes[nodeAddr], chunk, conns)
+			results <- replicaResult{nodeAddr: nodeAddr, body: body, err: err}
+		}()
+	}
+
+	request()
+	var hedgeTimer <-chan time.Time
+	if hedge && len(candidates) > 1 {
+		delay, ok := c.stats.LatencyPercentile(candidates[0], HEDGE_PERCENTILE)
+		if !ok {
+			delay = HEDGE_DEFAULT_DELAY
+		}
+		hedgeTimer = time.After(delay)
+	}
+	for {
+		select {
+		case result := <-results:
+			inflight -= 1
+			if result.err == nil {
+				return result.body, nil
+			}
+			if verbosity > 1 {
+				log.Printf("failed to receive chunk #%d from %s: %e\n", i, result.nodeAddr, result.err)
+			}
+			if inflight == 0 {
+				if next >= len(candidates) {
+					return nil, fmt.Errorf("no nodes available for chunk #%d, sorry :(", i)
+				}
+				request()
+			}
+		case <-hedgeTimer:
+			hedgeTimer = nil
+			if next < len(candidates) {
+				if verbosity > 1 {
+					log.Printf("chunk #%d is slow, sending hedged request\n", i)
+				}
+				request()
+			}
+		}
+	}
+}
+
+// hedgedConns tracks connections of parallel requests for one chunk so the losers can be closed
+type hedgedConns struct {
+	mu        sync.Mutex
+	conns     []*websocket.Conn
+	cancelled bool
+}
+
+// add registers the connection, returns false if the chunk was already received
+func (h *hedgedConns) add(conn *websocket.Conn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cancelled {
+		return false
+	}
+	h.conns = append(h.conns, conn)
+	return true
+}
+
+func (h *hedgedConns) isCancelled() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cancelled
+}
+
+func (h *hedgedConns) cancel() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cancelled = true
+	for _, conn := range h.conns {
+		_ = conn.Close()
+	}
+}
 
 func (c *Commands) download(cCtx *cli.Context) error {
 	verbosity := cCtx.Int("verbosity") //переменная отображает сколько текста вывести.
+	if err := c.unlockKeys(); err != nil {
+		return err
+	}
+	hedge := c.cfg.HedgedReads || cCtx.Bool("hedge")
 	if !cCtx.Bool("no-cleanup") {
 		totalFiles, deletedFiles, err := c.Cleanup(cCtx)
 		if verbosity > 0 {
@@ -97,13 +290,16 @@ func (c *Commands) download(cCtx *cli.Context) error {
 	if verbosity > 1 {
 		log.Println("fetching available nodes")
 	}
-	nodes, err := c.server.GetAvailableNodes()
+	nodes, err := c.getAvailableNodes(verbosity)
 	if err != nil {
 		return err
 	}
 	if verbosity > 1 {
 		log.Printf("successfully fetched %d nodes\n", len(nodes))
 	}
+	if verbosity > 0 {
+		c.warnUnreachableChunks(fileInfo, nodes)
+	}
 
 	var bar *progressbar.ProgressBar
 	if verbosity == 1 {
@@ -112,67 +308,13 @@ func (c *Commands) download(cCtx *cli.Context) error {
 	body := make([]byte, fileInfo.Size)
 	ptr := 0
 	for i, chunk := range fileInfo.Chunks {
-		if verbosity > 1 {
-			log.Printf("fetching chunk #%d\n", i)
-		}
-		var chunkBody []byte
-		for _, nodeAddr := range chunk.Nodes {
-			nodeIp, exists := nodes[nodeAddr]
-			if !exists {
-				if verbosity > 1 {
-					log.Printf("node %s unavailable, continuing\n", nodeAddr)
-				}
-				continue
-			}
-			nodeIp = fmt.Sprintf("%s:53591", nodeIp)
-
-			u := url.URL{Scheme: "ws", Host: nodeIp, Path: fmt.Sprintf("/get/%s", chunk.Hash)}
-			nodeURL, err := url.PathUnescape(u.String())
-			if err != nil {
-				if verbosity > 1 {
-					log.Printf("error decoding node url: %e\n", err)
-				}
-				continue
-			}
-			if verbosity > 1 {
-				log.Printf("connecting to %s", nodeURL)
-			}
-			conn, _, err := websocket.DefaultDialer.Dial(nodeURL, nil)
-			if err != nil {
-				if verbosity > 1 {
-					log.Printf("dial to %s error: %e\n", nodeIp, err)
-				}
-				continue
-			}
-			defer conn.Close()
-			chunkBody, err = c.downloadFile(conn)
-			if err != nil {
-				if verbosity > 1 {
-					log.Printf("failed to receive chunk #%d from %s: %e\n", i, nodeIp, err)
-				}
-				chunkBody = []byte{}
-				continue
-			}
-			if bodyHash := hex.EncodeToString(c.crypto.Hash(chunkBody)); chunk.Hash != bodyHash {
-				if verbosity > 1 {
-					log.Printf(
-						"integrity check failed for chunk #%d from node %s: stored %s, received %s\n",
-						i,
-						nodeAddr,
-						chunk.Hash,
-						bodyHash,
-					)
-				}
-				chunkBody = []byte{}
-				continue
-			}
-			if verbosity == 1 {
-				_ = bar.Add(1)
-			}
-			break
+		nodes = c.currentNodes(nodes)
+		chunkBody, err := c.fetchChunk(i, chunk, nodes, hedge, verbosity)
+		if err != nil {
+			return err
 		}
-		if len(chunkBody) == 0 {
-			return fmt.Errorf("no nodes available for chunk #%d, sorry :(", i)
+		if verbosity == 1 {
+			_ = bar.Add(1)
 		}
 		if ptr+len(chunkBody) > cap(body) {
 			var tmp []byte
@@ -188,11 +330,7 @@ func (c *Commands) download(cCtx *cli.Context) error {
 	if verbosity > 0 {
 		fmt.Printf("successfully downloaded file, decrypting and verifying signature...\n")
 	}
-	aesKey, err := c.crypto.ReadAesKey()
-	if err != nil {
-		return err
-	}
-	decryptedFile, err := c.crypto.AESDecrypt(aesKey, body)
+	decryptedFile, err := c.decryptFile(*fileInfo, body)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,299 @@
+package commands
+
+import (
+	"bytes"
+	"cli/internal/entity"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/schollz/progressbar/v3"
+	"github.com/urfave/cli/v2"
+	"log"
+	"time"
+)
+
+const (
+	chunkIdSize        = 32
+	listEntrySize      = chunkIdSize + 8
+	listMtimeEntrySize = listEntrySize + 8
+
+	// LIST_FORMAT_MTIME asks the node to list chunks with their modification time
+	LIST_FORMAT_MTIME = 0x01
+
+	// GC_GRACE_PERIOD is the default minimum age of a chunk gc may delete, younger chunks
+	// may belong to an upload that hasn't added its file info yet
+	GC_GRACE_PERIOD = 24 * time.Hour
+)
+
+func (c *Commands) GetGCCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "delete chunks stored on nodes that are not referenced by any uploaded file",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Value:   false,
+				Usage:   "delete orphaned chunks without confirmation",
+			},
+			&cli.DurationFlag{
+				Name:  "grace",
+				Value: GC_GRACE_PERIOD,
+				Usage: "keep orphaned chunks younger than this, they may belong to an upload in progress",
+			},
+			&cli.BoolFlag{
+				Name:  "allow-empty-index",
+				Value: false,
+				Usage: "run even if the local index is empty, e.g. on a new machine before restore-index",
+			},
+		},
+		Action: c.gc,
+	}
+}
+
+// listChunks requests the list of chunks owned by us from the node.
+// Nodes that don't report modification times list chunks with zero ModTime
+func (c *Commands) listChunks(conn *websocket.Conn, signer crypto.Signer) ([]entity.StoredChunk, error) {
+	sharedKey, err := c.executePreamble(signer, conn)
+	if err != nil {
+		return nil, err
+	}
+	verification, err := c.crypto.PrepareVerification(sharedKey, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.WriteMessage(websocket.BinaryMessage, append(verification, LIST_FORMAT_MTIME))
+	if err != nil {
+		return nil, err
+	}
+
+	// old nodes ignore the format byte, the end of the list tells which format was sent
+	batches := make([][]byte, 0)
+	for {
+		mt, message, err := conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if mt != websocket.BinaryMessage {
+			return nil, fmt.Errorf("wrong message type received: %d", mt)
+		}
+		if bytes.Equal(message, []byte{0xc8}) {
+			return parseChunkList(batches, false)
+		}
+		if bytes.Equal(message, []byte{0xc8, LIST_FORMAT_MTIME}) {
+			return parseChunkList(batches, true)
+		}
+		batches = append(batches, message)
+	}
+}
+
+func parseChunkList(batches [][]byte, withMtime bool) ([]entity.StoredChunk, error) {
+	entrySize := listEntrySize
+	if withMtime {
+		entrySize = listMtimeEntrySize
+	}
+	chunks := make([]entity.StoredChunk, 0)
+	for _, message := range batches {
+		if len(message)%entrySize != 0 {
+			return nil, fmt.Errorf("wrong message received: %x", message)
+		}
+		for i := 0; i < len(message); i += entrySize {
+			chunk := entity.StoredChunk{
+				Hash: hex.EncodeToString(message[i : i+chunkIdSize]),
+				Size: int64(binary.LittleEndian.Uint64(message[i+chunkIdSize : i+listEntrySize])),
+			}
+			if withMtime {
+				chunk.ModTime = time.Unix(int64(binary.LittleEndian.Uint64(message[i+listEntrySize:i+entrySize])), 0)
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks, nil
+}
+
+// listOwnedChunks asks every reachable node for the chunks owned by signer, unreachable nodes are skipped
+func (c *Commands) listOwnedChunks(nodes map[string]entity.Node, signer crypto.Signer, verbosity int) map[string][]entity.StoredChunk {
+	owned := make(map[string][]entity.StoredChunk)
+	for nodeAddr, node := range nodes {
+		nodeURL, err := buildNodeURL(node, "/list")
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("error decoding node URL: %e\n", err)
+			}
+			continue
+		}
+		if verbosity > 1 {
+			log.Printf("connecting to %s\n", nodeURL)
+		}
+		conn, _, err := c.nodeDialer(nodeAddr, node).Dial(nodeURL, nil)
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("dial to %s error: %e\n", nodeAddr, err)
+			}
+			continue
+		}
+		chunks, err := c.listChunks(conn, signer)
+		_ = conn.Close()
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("failed to list chunks on %s: %e\n", nodeAddr, err)
+			}
+			continue
+		}
+		owned[nodeAddr] = chunks
+	}
+	return owned
+}
+
+func (c *Commands) gc(cCtx *cli.Context) error {
+	verbosity := cCtx.Int("verbosity")
+	if err := c.unlockKeys(); err != nil {
+		return err
+	}
+	if !cCtx.Bool("no-cleanup") {
+		totalFiles, deletedFiles, err := c.Cleanup(cCtx)
+		if verbosity > 0 {
+			if err != nil {
+				fmt.Printf("error during cleanup: %e\n", err)
+			} else if totalFiles > 0 {
+				fmt.Printf("successfully cleaned up %d/%d files\n", deletedFiles, totalFiles)
+			}
+		}
+	}
+
+	// collect every chunk referenced by local info, including files waiting for cleanup
+	fileInfos, err := c.storage.GetFileInfos()
+	if err != nil {
+		return err
+	}
+	if len(fileInfos) == 0 && !cCtx.Bool("allow-empty-index") {
+		return fmt.Errorf("the local index is empty, every chunk would be deleted. " +
+			"run distorage restore-index first or use --allow-empty-index")
+	}
+	referenced := make(map[string]bool)
+	for _, fileInfo := range fileInfos {
+		for _, chunk := range fileInfo.Chunks {
+			referenced[chunk.Hash] = true
+		}
+	}
+	// the index backup and its pointer are ours too
+	pointerHash, err := c.indexPointerHash()
+	if err != nil {
+		return err
+	}
+	referenced[pointerHash] = true
+	backup, err := c.storage.GetIndexBackup()
+	if err != nil {
+		return err
+	}
+	if backup != nil {
+		for _, chunk := range backup.Chunks {
+			referenced[chunk.Hash] = true
+		}
+	}
+
+	nodes, err := c.getAvailableNodes(verbosity)
+	if err != nil {
+		return err
+	}
+
+	// ask every reachable node for the chunks we own
+	signer, err := c.crypto.Signer()
+	if err != nil {
+		return err
+	}
+	orphans := make(map[string][]entity.StoredChunk)
+	orphanCount := 0
+	var orphanSize int64
+	grace := cCtx.Duration("grace")
+	skippedYoung := 0
+	skippedUnknown := 0
+	for nodeAddr, chunks := range c.listOwnedChunks(nodes, signer, verbosity) {
+		for _, chunk := range chunks {
+			if referenced[chunk.Hash] {
+				continue
+			}
+			// the age of a chunk on an old node is unknown, it's kept unless there's no grace period
+			if chunk.ModTime.IsZero() && grace > 0 {
+				skippedUnknown += 1
+				continue
+			}
+			if time.Since(chunk.ModTime) < grace {
+				skippedYoung += 1
+				continue
+			}
+			orphans[nodeAddr] = append(orphans[nodeAddr], chunk)
+			orphanCount += 1
+			orphanSize += chunk.Size
+		}
+	}
+
+	if verbosity > 0 && skippedYoung > 0 {
+		fmt.Printf("kept %d unreferenced chunks younger than %s\n", skippedYoung, grace)
+	}
+	if verbosity > 0 && skippedUnknown > 0 {
+		fmt.Printf("kept %d unreferenced chunks on nodes that don't report chunk age\n", skippedUnknown)
+	}
+	if orphanCount == 0 {
+		if verbosity > 0 {
+			fmt.Printf("no orphaned chunks found\n")
+		}
+		return nil
+	}
+	if verbosity > 0 {
+		fmt.Printf("found %d orphaned chunks (%d bytes) on %d nodes\n", orphanCount, orphanSize, len(orphans))
+	}
+	if verbosity > 1 {
+		for nodeAddr, chunks := range orphans {
+			for _, chunk := range chunks {
+				log.Printf("orphaned chunk %s (%d bytes) on %s\n", chunk.Hash, chunk.Size, nodeAddr)
+			}
+		}
+	}
+	if !cCtx.Bool("yes") && !confirm(fmt.Sprintf("delete %d orphaned chunks?", orphanCount)) {
+		return nil
+	}
+
+	var bar *progressbar.ProgressBar
+	if verbosity == 1 {
+		bar = progressbar.Default(int64(orphanCount))
+	}
+	deleted := 0
+	for nodeAddr, chunks := range orphans {
+		for _, chunk := range chunks {
+			if verbosity == 1 {
+				_ = bar.Add(1)
+			}
+			nodeURL, err := buildNodeURL(nodes[nodeAddr], fmt.Sprintf("/delete/%s", chunk.Hash))
+			if err != nil {
+				continue
+			}
+			conn, _, err := c.nodeDialer(nodeAddr, nodes[nodeAddr]).Dial(nodeURL, nil)
+			if err != nil {
+				if verbosity > 1 {
+					log.Printf("dial to %s error: %e\n", nodeAddr, err)
+				}
+				continue
+			}
+			err = c.deleteFile(conn)
+			_ = conn.Close()
+			if err != nil {
+				if verbosity > 1 {
+					log.Printf("failed to delete chunk %s from %s: %e\n", chunk.Hash, nodeAddr, err)
+				}
+				continue
+			}
+			deleted += 1
+		}
+	}
+	if verbosity == 1 {
+		_ = bar.Finish()
+	}
+	if verbosity > 0 {
+		fmt.Printf("successfully deleted %d/%d orphaned chunks\n", deleted, orphanCount)
+	}
+	return nil
+}
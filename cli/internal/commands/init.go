@@ -2,30 +2,53 @@ package commands
 
 import (
 	"cli/internal/entity"
+	"cli/internal/usecase"
+	"cli/pkg/mnemonic"
 	"crypto/aes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/x509"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/urfave/cli/v2"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 func (c *Commands) GetInitCommand() *cli.Command {
 	return &cli.Command{
-		Name:    "init",
-		Aliases: []string{"i"},
-		Usage:   "initialize the cli (generate necessary keys)",
-		Action:  c.init,
+		Name:      "init",
+		Aliases:   []string{"i"},
+		Usage:     "initialize the cli (generate necessary keys)",
+		ArgsUsage: "[seed words]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "from-seed",
+				Value: false,
+				Usage: "derive all keys from one seed, printed as 24 words, or restore them from the given words",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Value: false,
+				Usage: "replace existing keys, the old keys and the file index are moved to a separate folder",
+			},
+		},
+		Action: c.init,
 	}
 }
 
 func (c *Commands) init(cCtx *cli.Context) error {
+	if c.keys.Exists() && !cCtx.Bool("force") {
+		return fmt.Errorf(
+			"already initialized, keys are in %s. back them up with distorage keys export or use --force to overwrite them",
+			c.keys.Path(),
+		)
+	}
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("error finding home directory")
@@ -42,83 +65,177 @@ func (c *Commands) init(cCtx *cli.Context) error {
 		return err
 	}
 
-	aesKey := make([]byte, aes.BlockSize)
-	_, err = rand.Read(aesKey)
-	if err != nil {
-		return err
+	var keys *entity.Keys
+	if cCtx.Bool("from-seed") {
+		keys, err = keysFromSeed(cCtx.Args().Slice())
+	} else {
+		keys, err = generateKeys()
 	}
-	aesKeyStr := hex.EncodeToString(aesKey)
-
-	curve := elliptic.P256()
-	ecdsaKey, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
 		return err
 	}
-	ecdsaKeyBytes, err := x509.MarshalECPrivateKey(ecdsaKey)
-	if err != nil {
-		return err
-	}
-	ecdsaKeyStr := hex.EncodeToString(ecdsaKeyBytes)
-
-	ecdsaPubKey := ecdsaKey.PublicKey
-	ecdsaPubKeyBytes, err := x509.MarshalPKIXPublicKey(&ecdsaPubKey)
+	addr, err := c.keysAddress(keys)
 	if err != nil {
 		return err
 	}
-	addr := hex.EncodeToString(c.crypto.GetAddress(ecdsaPubKeyBytes))
 
+	// with --force existing configs are kept, the file index is moved aside together with the old keys
 	daemonConfig := map[string]any{
 		"port":       "53591",
 		"server_url": "127.0.0.1:8000/connect",
 		"base_path":  folderPath,
-		"addr":       addr, //адрес вершины в графе системы
 	}
-	f, err := os.Create(path.Join(folderPath, "daemon.toml"))
-	if err != nil {
+	if err := writeConfigIfMissing(path.Join(folderPath, "daemon.toml"), daemonConfig); err != nil {
 		return err
 	}
-	if err := toml.NewEncoder(f).Encode(daemonConfig); err != nil {
+
+	cliConfig := map[string]any{
+		"server_url":             "127.0.0.1:8000/nodes",
+		"replication_count":      5,
+		"placement":              "random",
+		"spread_failure_domains": true,
+		"hedged_reads":           false,
+		"metadata_store":         "json",
+	}
+	if err := writeConfigIfMissing(path.Join(folderPath, "cli.toml"), cliConfig); err != nil {
 		return err
 	}
-	if err := f.Close(); err != nil {
+
+	if c.keys.Exists() {
+		if err := c.moveOldIndex(folderPath, keys, len(cCtx.Args().Slice()) > 0); err != nil {
+			return err
+		}
+	}
+	if err := c.keys.Write(*keys); err != nil {
 		return err
 	}
 
-	cliConfig := map[string]any{
-		"server_url":        "127.0.0.1:8000/nodes",
-		"replication_count": 5,
+	indexExists := false
+	for _, name := range []string{"files.json", "files.db"} {
+		if _, err := os.Stat(path.Join(folderPath, name)); err == nil {
+			indexExists = true
+		}
+	}
+	if indexExists {
+		fmt.Printf("existing file index is kept\n")
+	} else {
+		f, err := os.Create(path.Join(folderPath, "files.json"))
+		if err != nil {
+			return err
+		}
+		if err := json.NewEncoder(f).Encode(map[string]string{}); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
 	}
-	f, err = os.Create(path.Join(folderPath, "cli.toml"))
+
+	fmt.Printf("Successfuly initialized the app! Your public addr is: %s\n", addr)
+	return nil
+}
+
+// moveOldIndex moves the file index and the old keys to a separate folder before the keys are replaced,
+// files in the index were encrypted and signed with the old keys. Keys restored from seed words
+// may be the same as the old ones, then the index is kept
+func (c *Commands) moveOldIndex(folderPath string, keys *entity.Keys, restored bool) error {
+	if restored {
+		if oldKeys, err := c.keys.Read(); err == nil && oldKeys.AesKey == keys.AesKey && oldKeys.EcdsaKey == keys.EcdsaKey {
+			return nil
+		}
+	}
+	names := []string{"keys.json", "files.json", "files.json.imported", "files.db", "index_backup.json"}
+	// previous versions kept by the json store
+	backups, err := filepath.Glob(path.Join(folderPath, "files.json.[0-9]*"))
 	if err != nil {
 		return err
 	}
-	if err := toml.NewEncoder(f).Encode(cliConfig); err != nil {
-		return err
+	for _, backup := range backups {
+		names = append(names, filepath.Base(backup))
+	}
+	// init runs without the config, the store is told by the index file
+	var storage usecase.Storage = usecase.NewStorageUC(path.Join(folderPath, "files.json"), 0)
+	if _, err := os.Stat(path.Join(folderPath, "files.db")); err == nil {
+		storage = usecase.NewBoltStorageUC(path.Join(folderPath, "files.db"), path.Join(folderPath, "files.json"))
 	}
-	if err := f.Close(); err != nil {
+	fileCount := -1
+	if fileInfos, err := storage.GetFileInfos(); err == nil {
+		fileCount = len(fileInfos)
+	}
+
+	oldPath, err := os.MkdirTemp(folderPath, time.Now().Format("old-keys-20060102-150405-"))
+	if err != nil {
 		return err
 	}
+	for _, name := range names {
+		err := os.Rename(path.Join(folderPath, name), path.Join(oldPath, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if fileCount > 0 {
+		fmt.Printf("%d files in the index were uploaded with the old keys and can't be downloaded with the new ones.\n", fileCount)
+		fmt.Printf("use distorage keys rotate to replace the keys without losing files\n")
+	}
+	fmt.Printf("the old keys and the file index were moved to %s\n", oldPath)
+	return nil
+}
 
-	keys := entity.Keys{
-		AesKey:   aesKeyStr,
-		EcdsaKey: ecdsaKeyStr,
+// writeConfigIfMissing writes the default config unless the file exists
+func writeConfigIfMissing(configPath string, config map[string]any) error {
+	f, err := os.OpenFile(configPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil
 	}
-	f, err = os.Create(path.Join(folderPath, "keys.json"))
-	if err := json.NewEncoder(f).Encode(keys); err != nil {
+	if err != nil {
 		return err
 	}
-	if err := f.Close(); err != nil {
+	if err := toml.NewEncoder(f).Encode(config); err != nil {
+		_ = f.Close()
 		return err
 	}
+	return f.Close()
+}
 
-	f, err = os.Create(path.Join(folderPath, "files.json"))
-	if err := json.NewEncoder(f).Encode(map[string]string{}); err != nil {
-		return err
+func generateKeys() (*entity.Keys, error) {
+	aesKey := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(aesKey); err != nil {
+		return nil, err
 	}
-	if err := f.Close(); err != nil {
-		return err
+	curve := elliptic.P256()
+	ecdsaKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
 	}
+	return usecase.NewKeys(aesKey, ecdsaKey)
+}
 
-	fmt.Printf("Successfuly initialized the app! Your public addr is: %s\n", addr)
-	return nil
+// keysFromSeed derives keys from the seed in words, or from a new seed whose words are printed
+func keysFromSeed(words []string) (*entity.Keys, error) {
+	var seed []byte
+	if len(words) > 0 {
+		decoded, err := mnemonic.Decode(strings.Fields(strings.Join(words, " ")))
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) != usecase.SEED_SIZE {
+			return nil, fmt.Errorf("seed must be %d words", usecase.SEED_SIZE*8*33/32/11)
+		}
+		seed = decoded
+	} else {
+		seed = make([]byte, usecase.SEED_SIZE)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		seedWords, err := mnemonic.Encode(seed)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Write down these words, they restore your keys with distorage init --from-seed <words>:\n\n")
+		for i := 0; i < len(seedWords); i += MNEMONIC_LINE_WORDS {
+			fmt.Printf("  %s\n", strings.Join(seedWords[i:min(i+MNEMONIC_LINE_WORDS, len(seedWords))], " "))
+		}
+		fmt.Println()
+	}
+	return usecase.KeysFromSeed(seed)
 }
@@ -0,0 +1,234 @@
+package commands
+
+import (
+	"cli/internal/entity"
+	"cli/pkg/mnemonic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+)
+
+// MNEMONIC_LINE_WORDS is how many words are printed on one line of a paper backup
+const MNEMONIC_LINE_WORDS = 6
+
+func (c *Commands) GetKeysCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "keys",
+		Usage: "back up and restore the keys",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "export",
+				Usage: "print the keys encrypted with a passphrase",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "write the bundle to a file instead of stdout",
+					},
+					&cli.BoolFlag{
+						Name:  "mnemonic",
+						Value: false,
+						Usage: "print the bundle as words for a paper backup",
+					},
+				},
+				Action: c.exportKeys,
+			},
+			{
+				Name:      "import",
+				Usage:     "restore the keys from a bundle made by keys export, on a new machine run init first",
+				ArgsUsage: "[file or words]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "mnemonic",
+						Value: false,
+						Usage: "read words instead of a bundle, from arguments or stdin",
+					},
+					&cli.BoolFlag{
+						Name:  "force",
+						Value: false,
+						Usage: "replace existing keys",
+					},
+				},
+				Action: c.importKeys,
+			},
+			{
+				Name:   "encrypt",
+				Usage:  "protect keys.json with a passphrase",
+				Action: c.encryptKeys,
+			},
+			{
+				Name:   "decrypt",
+				Usage:  "store keys.json without a passphrase",
+				Action: c.decryptKeys,
+			},
+			c.getRotateKeysCommand(),
+		},
+	}
+}
+
+// keysAddress returns the address derived from the keys
+func (c *Commands) keysAddress(keys *entity.Keys) (string, error) {
+	ecdsaKeyBytes, err := hex.DecodeString(keys.EcdsaKey)
+	if err != nil {
+		return "", err
+	}
+	ecdsaKey, err := x509.ParseECPrivateKey(ecdsaKeyBytes)
+	if err != nil {
+		return "", err
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&ecdsaKey.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(c.crypto.GetAddress(pubKeyBytes)), nil
+}
+
+func (c *Commands) exportKeys(cCtx *cli.Context) error {
+	keys, err := c.keys.Read()
+	if err != nil {
+		return err
+	}
+	passphrase, err := ReadPassphrase("passphrase for the backup", true)
+	if err != nil {
+		return err
+	}
+	bundle, err := c.keys.Export(*keys, passphrase)
+	if err != nil {
+		return err
+	}
+
+	var output string
+	if cCtx.Bool("mnemonic") {
+		words, err := mnemonic.Encode(bundle)
+		if err != nil {
+			return err
+		}
+		lines := make([]string, 0, len(words)/MNEMONIC_LINE_WORDS+1)
+		for i := 0; i < len(words); i += MNEMONIC_LINE_WORDS {
+			lines = append(lines, strings.Join(words[i:min(i+MNEMONIC_LINE_WORDS, len(words))], " "))
+		}
+		output = strings.Join(lines, "\n") + "\n"
+	} else {
+		output = base64.StdEncoding.EncodeToString(bundle) + "\n"
+	}
+
+	outputPath := cCtx.String("output")
+	if outputPath == "" {
+		fmt.Print(output)
+		return nil
+	}
+	if err := os.WriteFile(outputPath, []byte(output), 0600); err != nil {
+		return err
+	}
+	if cCtx.Int("verbosity") > 0 {
+		fmt.Printf("keys exported to %s, keep the passphrase, without it the backup is useless\n", outputPath)
+	}
+	return nil
+}
+
+// readBundleInput returns the arguments or, if there are none, everything from stdin
+func readBundleInput(args []string, isMnemonic bool) (string, error) {
+	if isMnemonic && len(args) > 0 {
+		return strings.Join(args, " "), nil
+	}
+	if !isMnemonic && len(args) > 0 && args[0] != "-" {
+		contents, err := os.ReadFile(args[0])
+		return string(contents), err
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "paste the backup, then press Ctrl-D")
+	}
+	contents, err := io.ReadAll(os.Stdin)
+	return string(contents), err
+}
+
+func (c *Commands) importKeys(cCtx *cli.Context) error {
+	if c.keys.Exists() && !cCtx.Bool("force") {
+		return fmt.Errorf("keys already exist in %s, use --force to replace them", c.keys.Path())
+	}
+	input, err := readBundleInput(cCtx.Args().Slice(), cCtx.Bool("mnemonic"))
+	if err != nil {
+		return err
+	}
+	var bundle []byte
+	if cCtx.Bool("mnemonic") {
+		bundle, err = mnemonic.Decode(strings.Fields(input))
+	} else {
+		bundle, err = base64.StdEncoding.DecodeString(strings.TrimSpace(input))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the backup: %w", err)
+	}
+	passphrase, err := ReadPassphrase("passphrase of the backup", false)
+	if err != nil {
+		return err
+	}
+	keys, err := c.keys.Import(bundle, passphrase)
+	if err != nil {
+		return err
+	}
+	addr, err := c.keysAddress(keys)
+	if err != nil {
+		return err
+	}
+	if err := c.keys.Write(*keys); err != nil {
+		return err
+	}
+	if cCtx.Int("verbosity") > 0 {
+		fmt.Printf("keys imported, your public addr is: %s\n", addr)
+	}
+	return nil
+}
+
+func (c *Commands) encryptKeys(cCtx *cli.Context) error {
+	encrypted, err := c.keys.IsEncrypted()
+	if err != nil {
+		return err
+	}
+	keys, err := c.keys.Read()
+	if err != nil {
+		return err
+	}
+	prompt := "new keystore passphrase"
+	if !encrypted {
+		prompt = "keystore passphrase"
+	}
+	passphrase, err := ReadPassphrase(prompt, true)
+	if err != nil {
+		return err
+	}
+	if err := c.keys.WriteEncrypted(*keys, passphrase); err != nil {
+		return err
+	}
+	if cCtx.Int("verbosity") > 0 {
+		fmt.Printf("keys are encrypted, run distorage agent to enter the passphrase once per session\n")
+	}
+	return nil
+}
+
+func (c *Commands) decryptKeys(cCtx *cli.Context) error {
+	encrypted, err := c.keys.IsEncrypted()
+	if err != nil {
+		return err
+	}
+	if !encrypted {
+		return fmt.Errorf("keys are not encrypted")
+	}
+	keys, err := c.keys.Read()
+	if err != nil {
+		return err
+	}
+	if err := c.keys.Write(*keys); err != nil {
+		return err
+	}
+	if cCtx.Int("verbosity") > 0 {
+		fmt.Printf("keys are stored without a passphrase in %s\n", c.keys.Path())
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"sort"
+)
+
+func (c *Commands) GetLocateCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "locate",
+		Usage:     "find nodes that store the chunk using DHT",
+		ArgsUsage: "<chunk hash>",
+		Action:    c.locate,
+	}
+}
+
+func (c *Commands) locate(cCtx *cli.Context) error {
+	if c.locator == nil {
+		return errors.New("chunk lookup requires discovery = \"dht\" in config")
+	}
+	if !cCtx.Args().Present() {
+		return errors.New("chunk hash is required")
+	}
+	providers, err := c.locator.LocateChunk(cCtx.Args().First())
+	if err != nil {
+		return err
+	}
+	if len(providers) == 0 {
+		return fmt.Errorf("no nodes store chunk %s", cCtx.Args().First())
+	}
+	addrs := make([]string, 0, len(providers))
+	for addr := range providers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		fmt.Printf("%s %s\n", addr, nodeBaseURL(providers[addr]))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"cli/internal/usecase"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"time"
+)
+
+func (c *Commands) GetNodesCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "nodes",
+		Usage:  "list storage nodes currently available on the tracker",
+		Action: c.nodes,
+	}
+}
+
+func (c *Commands) nodes(cCtx *cli.Context) error {
+	nodes, err := c.server.GetAvailableNodes()
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		fmt.Printf("no nodes available\n")
+		return nil
+	}
+
+	fmt.Printf("Available nodes: %d\n", len(nodes))
+	fmt.Printf("Node list age: %s\n", c.server.NodesAge().Round(time.Second))
+	for addr, node := range nodes {
+		fmt.Println()
+		fmt.Printf("Address: %s\n", addr)
+		fmt.Printf("URL: %s\n", nodeBaseURL(node))
+		fmt.Printf("Version: %s (protocol %d)\n", node.Version, node.ProtocolVersion)
+		domains := usecase.NodeFailureDomains(node)
+		fmt.Printf("Failure domains: zone %q, subnet %q, host %q\n", domains.Zone, domains.Subnet, domains.Host)
+		fmt.Printf("Free: %d/%d\n", node.FreeCapacity, node.TotalCapacity)
+		fmt.Printf("Uptime: %s\n", time.Duration(node.Uptime)*time.Second)
+		stats := c.stats.GetNodeStats(addr)
+		fmt.Printf("Requests: %d ok, %d failed, %d integrity failures\n",
+			stats.Successes, stats.Failures, stats.IntegrityFailures)
+		if c.stats.IsQuarantined(addr) {
+			fmt.Printf("Quarantined until: %s\n", stats.QuarantinedUntil.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"cli/internal/entity"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+	"slices"
+)
+
+func (c *Commands) GetRelocateCommand() *cli.Command {
+	return &cli.Command{
+		Name: "relocate",
+		Usage: "ask nodes which of our chunks they store and record them in the index. " +
+			"Needed for files uploaded before nodes got their own addresses",
+		Action: c.relocate,
+	}
+}
+
+// ownAddress returns our address. Before nodes got their own keys, the local daemon
+// was announced under it, so chunks stored there are recorded with this address
+func (c *Commands) ownAddress() (string, error) {
+	signer, err := c.crypto.Signer()
+	if err != nil {
+		return "", err
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(c.crypto.GetAddress(pubKeyBytes)), nil
+}
+
+// relocateChunk adds the nodes that store the chunk now. ownAddr is dropped only once the chunk
+// is found on another node, otherwise it stays the only record of where the chunk lives.
+// Returns whether the chunk info was changed and whether the chunk is recorded under ownAddr
+// but wasn't found on any node
+func relocateChunk(chunk *entity.ChunkInfo, locations map[string][]string, ownAddr string) (bool, bool) {
+	changed, found := false, false
+	for _, nodeAddr := range locations[chunk.Hash] {
+		if nodeAddr == ownAddr {
+			continue
+		}
+		found = true
+		if !slices.Contains(chunk.Nodes, nodeAddr) {
+			chunk.Nodes = append(chunk.Nodes, nodeAddr)
+			changed = true
+		}
+	}
+	i := slices.Index(chunk.Nodes, ownAddr)
+	if i < 0 {
+		return changed, false
+	}
+	if !found {
+		return changed, true
+	}
+	chunk.Nodes = slices.Delete(chunk.Nodes, i, i+1)
+	return true, false
+}
+
+func (c *Commands) relocate(cCtx *cli.Context) error {
+	verbosity := cCtx.Int("verbosity")
+	ownAddr, err := c.ownAddress()
+	if err != nil {
+		return err
+	}
+	nodes, err := c.getAvailableNodes(verbosity)
+	if err != nil {
+		return err
+	}
+
+	signer, err := c.crypto.Signer()
+	if err != nil {
+		return err
+	}
+	locations := make(map[string][]string)
+	for nodeAddr, chunks := range c.listOwnedChunks(nodes, signer, verbosity) {
+		for _, chunk := range chunks {
+			locations[chunk.Hash] = append(locations[chunk.Hash], nodeAddr)
+		}
+	}
+
+	relocated := 0
+	notFound := make(map[string][]int)
+	err = c.storage.Modify(func(fileInfos map[uuid2.UUID]entity.FileInfo) error {
+		relocated = 0
+		clear(notFound)
+		for uuid, fileInfo := range fileInfos {
+			changed := false
+			for i := range fileInfo.Chunks {
+				chunkChanged, chunkNotFound := relocateChunk(&fileInfo.Chunks[i], locations, ownAddr)
+				if chunkChanged {
+					changed = true
+					relocated += 1
+				}
+				if chunkNotFound {
+					notFound[fileInfo.Name] = append(notFound[fileInfo.Name], i)
+				}
+			}
+			if changed {
+				fileInfos[uuid] = fileInfo
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	backup, err := c.storage.GetIndexBackup()
+	if err != nil {
+		return err
+	}
+	if backup != nil {
+		changed := false
+		for i := range backup.Chunks {
+			chunkChanged, _ := relocateChunk(&backup.Chunks[i], locations, ownAddr)
+			changed = chunkChanged || changed
+		}
+		if backup.Pointer != nil {
+			chunkChanged, _ := relocateChunk(backup.Pointer, locations, ownAddr)
+			changed = chunkChanged || changed
+		}
+		if changed {
+			if err := c.storage.SetIndexBackup(*backup); err != nil {
+				return err
+			}
+		}
+	}
+
+	if verbosity > 0 {
+		fmt.Printf("found %d of our chunks on %d nodes, updated locations of %d chunks\n",
+			len(locations), len(nodes), relocated)
+	}
+	if len(notFound) > 0 {
+		fmt.Printf("warning: chunks of %d files weren't found on any available node, their records were kept:\n",
+			len(notFound))
+		for name, chunks := range notFound {
+			fmt.Printf("  %s: chunks %v\n", name, chunks)
+		}
+		fmt.Printf("run distorage relocate again when the nodes storing them are online\n")
+	}
+	if relocated > 0 {
+		c.autoBackupIndex(verbosity)
+	}
+	return nil
+}
+
+// warnUnreachableChunks tells the user to run relocate if chunks of the file are recorded only
+// under addresses no node announces
+func (c *Commands) warnUnreachableChunks(fileInfo *entity.FileInfo, nodes map[string]entity.Node) {
+	ownAddr, err := c.ownAddress()
+	if err != nil {
+		return
+	}
+	legacy := 0
+	for _, chunk := range fileInfo.Chunks {
+		if slices.Contains(chunk.Nodes, ownAddr) &&
+			!slices.ContainsFunc(chunk.Nodes, func(nodeAddr string) bool { _, exists := nodes[nodeAddr]; return exists }) {
+			legacy += 1
+		}
+	}
+	if legacy > 0 {
+		fmt.Printf("warning: %d chunks of %s are recorded only on your own address, which nodes don't use anymore.\n",
+			legacy, fileInfo.Name)
+		fmt.Printf("run distorage relocate to find where they are stored now\n")
+	}
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"cli/internal/entity"
+	"slices"
+	"testing"
+)
+
+func TestRelocateChunk(t *testing.T) {
+	const ownAddr = "own"
+	tests := []struct {
+		name      string
+		nodes     []string
+		locations map[string][]string
+		expected  []string
+		changed   bool
+		notFound  bool
+	}{
+		{
+			name:      "found on another node",
+			nodes:     []string{ownAddr},
+			locations: map[string][]string{"hash": {"a"}},
+			expected:  []string{"a"},
+			changed:   true,
+		},
+		{
+			name:      "no locations",
+			nodes:     []string{ownAddr},
+			locations: map[string][]string{},
+			expected:  []string{ownAddr},
+			notFound:  true,
+		},
+		{
+			name:      "other replica recorded but not found",
+			nodes:     []string{ownAddr, "b"},
+			locations: map[string][]string{},
+			expected:  []string{ownAddr, "b"},
+			notFound:  true,
+		},
+		{
+			name:      "found on an already recorded node",
+			nodes:     []string{ownAddr, "b"},
+			locations: map[string][]string{"hash": {"b"}},
+			expected:  []string{"b"},
+			changed:   true,
+		},
+		{
+			name:      "new replica of a relocated chunk",
+			nodes:     []string{"a"},
+			locations: map[string][]string{"hash": {"a", "b"}},
+			expected:  []string{"a", "b"},
+			changed:   true,
+		},
+		{
+			name:      "nothing to do",
+			nodes:     []string{"a"},
+			locations: map[string][]string{"hash": {"a"}},
+			expected:  []string{"a"},
+		},
+	}
+	for _, test := range tests {
+		chunk := entity.ChunkInfo{Hash: "hash", Nodes: slices.Clone(test.nodes)}
+		changed, notFound := relocateChunk(&chunk, test.locations, ownAddr)
+		if changed != test.changed || notFound != test.notFound {
+			t.Errorf("%s: changed %v, not found %v, want %v, %v", test.name, changed, notFound, test.changed, test.notFound)
+		}
+		if !slices.Equal(chunk.Nodes, test.expected) {
+			t.Errorf("%s: nodes %v, want %v", test.name, chunk.Nodes, test.expected)
+		}
+	}
+}
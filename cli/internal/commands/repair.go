@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"bytes"
+	"cli/internal/entity"
+	"cli/internal/usecase"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/schollz/progressbar/v3"
+	"github.com/urfave/cli/v2"
+	"log"
+	"slices"
+	"time"
+)
+
+const REPLICATION_TTL = 5 * time.Minute
+
+func (c *Commands) GetRepairCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "repair",
+		Aliases: []string{"r"},
+		Usage:   "restore missing replicas of an uploaded file by copying chunks between nodes",
+		Action:  c.repair,
+	}
+}
+
+// replicateChunk asks the node behind conn to push the chunk to the target node,
+// targetURL is the target's scheme://host:port
+func (c *Commands) replicateChunk(conn *websocket.Conn, chunkHash string, targetAddr string, targetURL string) error {
+	signer, err := c.crypto.Signer()
+	if err != nil {
+		return err
+	}
+	chunkId, err := hex.DecodeString(chunkHash)
+	if err != nil {
+		return err
+	}
+	targetAddrBytes, err := hex.DecodeString(targetAddr)
+	if err != nil {
+		return err
+	}
+	expiry := time.Now().Add(REPLICATION_TTL).Unix()
+	authSig, err := c.crypto.SignReplication(signer, chunkId, targetAddrBytes, expiry)
+	if err != nil {
+		return err
+	}
+
+	sharedKey, err := c.executePreamble(signer, conn)
+	if err != nil {
+		return err
+	}
+	verification, err := c.crypto.PrepareVerification(sharedKey, signer)
+	if err != nil {
+		return err
+	}
+	msg := make([]byte, 0, len(verification)+len(targetAddrBytes)+8+1+len(authSig)+len(targetURL))
+	msg = append(msg, verification...)
+	msg = append(msg, targetAddrBytes...)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(expiry))
+	msg = append(msg, byte(len(authSig)))
+	msg = append(msg, authSig...)
+	msg = append(msg, targetURL...)
+
+	err = conn.WriteMessage(websocket.BinaryMessage, msg)
+	if err != nil {
+		return err
+	}
+	mt, message, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if mt != websocket.BinaryMessage {
+		return fmt.Errorf("wrong message type received: %d", mt)
+	}
+	if bytes.Equal(message, []byte{0x01, 0x91}) {
+		return fmt.Errorf("target node rejected the authorisation")
+	}
+	if bytes.Equal(message, []byte{0x01, 0x94}) {
+		return fmt.Errorf("file not found on node")
+	}
+	if bytes.Equal(message, []byte{0x01, 0xf6}) {
+		return fmt.Errorf("node failed to reach the target node")
+	}
+	if !bytes.Equal(message, []byte{0xc8}) {
+		return fmt.Errorf("wrong message received: %x", message)
+	}
+	return nil
+}
+
+func (c *Commands) repair(cCtx *cli.Context) error {
+	verbosity := cCtx.Int("verbosity")
+	if err := c.unlockKeys(); err != nil {
+		return err
+	}
+	if !cCtx.Bool("no-cleanup") {
+		totalFiles, deletedFiles, err := c.Cleanup(cCtx)
+		if verbosity > 0 {
+			if err != nil {
+				fmt.Printf("error during cleanup: %e\n", err)
+			} else if totalFiles > 0 {
+				fmt.Printf("successfully cleaned up %d/%d files\n", deletedFiles, totalFiles)
+			}
+		}
+	}
+
+	uuid, err := uuid2.Parse(cCtx.Args().First())
+	if err != nil {
+		return err
+	}
+	fileInfo, err := c.storage.GetFileInfo(uuid)
+	if err != nil {
+		return err
+	}
+	if !fileInfo.Available {
+		return fmt.Errorf("file %s is already deleted", uuid)
+	}
+
+	nodes, err := c.getAvailableNodes(verbosity)
+	if err != nil {
+		return err
+	}
+
+	var bar *progressbar.ProgressBar
+	if verbosity == 1 {
+		bar = progressbar.Default(int64(len(fileInfo.Chunks)))
+	}
+	created := 0
+	unrecoverable := 0
+	for i, chunk := range fileInfo.Chunks {
+		// replicas that can serve as a source right now
+		sources := make([]string, 0)
+		for _, nodeAddr := range chunk.Nodes {
+			if _, exists := nodes[nodeAddr]; exists {
+				sources = append(sources, nodeAddr)
+			}
+		}
+		missing := c.cfg.ReplicationCount - len(sources)
+		if len(sources) == 0 {
+			if verbosity > 0 {
+				fmt.Printf("no replicas of chunk #%d are available, it can't be repaired now\n", i)
+			}
+			unrecoverable += 1
+		}
+		// new replicas go where the placement strategy puts them, away from the domains of the surviving ones
+		candidates := make(map[string]entity.Node)
+		for nodeAddr, node := range nodes {
+			if !slices.Contains(chunk.Nodes, nodeAddr) {
+				candidates[nodeAddr] = node
+			}
+		}
+		replicas := make([]entity.Node, 0, len(sources))
+		for _, sourceAddr := range sources {
+			replicas = append(replicas, nodes[sourceAddr])
+		}
+		targets := usecase.PlaceAround(c.placement, chunk.Hash, replicas, candidates)
+		for _, target := range targets {
+			if missing <= 0 || len(sources) == 0 {
+				break
+			}
+			targetAddr := target.Addr
+			for _, sourceAddr := range sources {
+				nodeURL, err := buildNodeURL(nodes[sourceAddr], fmt.Sprintf("/replicate/%s", chunk.Hash))
+				if err != nil {
+					continue
+				}
+				if verbosity > 1 {
+					log.Printf("asking %s to push chunk #%d to %s\n", sourceAddr, i, targetAddr)
+				}
+				conn, _, err := c.nodeDialer(sourceAddr, nodes[sourceAddr]).Dial(nodeURL, nil)
+				if err != nil {
+					if verbosity > 1 {
+						log.Printf("dial to %s error: %e\n", sourceAddr, err)
+					}
+					continue
+				}
+				err = c.replicateChunk(conn, chunk.Hash, targetAddr, nodeBaseURL(target))
+				_ = conn.Close()
+				if err != nil {
+					if verbosity > 1 {
+						log.Printf("failed to replicate chunk #%d from %s to %s: %e\n", i, sourceAddr, targetAddr, err)
+					}
+					continue
+				}
+				fileInfo.Chunks[i].Nodes = append(fileInfo.Chunks[i].Nodes, targetAddr)
+				missing -= 1
+				created += 1
+				break
+			}
+		}
+		if verbosity == 1 {
+			_ = bar.Add(1)
+		}
+	}
+	if verbosity == 1 {
+		_ = bar.Finish()
+	}
+
+	if created > 0 {
+		if err := c.storage.UpdateFileInfo(uuid, *fileInfo); err != nil {
+			return err
+		}
+		c.autoBackupIndex(verbosity)
+	}
+	if verbosity > 0 {
+		fmt.Printf("created %d new replicas\n", created)
+	}
+	if unrecoverable > 0 {
+		return fmt.Errorf("%d chunks have no available replicas", unrecoverable)
+	}
+	return nil
+}
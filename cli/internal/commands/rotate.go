@@ -0,0 +1,364 @@
+package commands
+
+import (
+	"bytes"
+	"cli/internal/entity"
+	"crypto"
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/urfave/cli/v2"
+	"log"
+	"time"
+)
+
+// HANDOVER_TTL is how long nodes accept the signed handover
+const HANDOVER_TTL = 5 * time.Minute
+
+// newDataKey returns a random key for one file and the same key encrypted with the master key
+func (c *Commands) newDataKey() ([]byte, string, error) {
+	dataKey := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", err
+	}
+	wrapped, err := c.crypto.EncryptWithMasterKey(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, hex.EncodeToString(wrapped), nil
+}
+
+// unwrapDataKey decrypts the data key with the master key, during keys rotate with the previous one as well
+func (c *Commands) unwrapDataKey(fileInfo entity.FileInfo) ([]byte, error) {
+	sealed, err := hex.DecodeString(fileInfo.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := c.crypto.DecryptWithMasterKey(sealed)
+	if err != nil {
+		if previous, previousErr := c.crypto.DecryptWithPreviousMasterKey(sealed); previousErr == nil {
+			return previous, nil
+		}
+		return nil, fmt.Errorf("failed to decrypt the data key of %s: %w", fileInfo.Name, err)
+	}
+	return dataKey, nil
+}
+
+// decryptFile decrypts the downloaded file. Files uploaded before data keys were introduced
+// are encrypted with the master key, during keys rotate it's the previous one
+func (c *Commands) decryptFile(fileInfo entity.FileInfo, body []byte) ([]byte, error) {
+	if fileInfo.DataKey == "" {
+		contents, err := c.crypto.DecryptWithMasterKey(body)
+		if err != nil {
+			if previous, previousErr := c.crypto.DecryptWithPreviousMasterKey(body); previousErr == nil {
+				return previous, nil
+			}
+		}
+		return contents, err
+	}
+	dataKey, err := c.unwrapDataKey(fileInfo)
+	if err != nil {
+		return nil, err
+	}
+	return c.crypto.AESDecrypt(dataKey, body)
+}
+
+func (c *Commands) getRotateKeysCommand() *cli.Command {
+	return &cli.Command{
+		Name: "rotate",
+		Usage: "replace the keys with new ones, files stay available. " +
+			"If some nodes are unreachable the rotation stays pending, run it again to finish",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "force",
+				Value: false,
+				Usage: "finish the rotation even if nodes with our chunks are unreachable, their chunks are lost",
+			},
+		},
+		Action: c.rotateKeys,
+	}
+}
+
+func (c *Commands) rotateKeys(cCtx *cli.Context) error {
+	verbosity := cCtx.Int("verbosity")
+	keys, err := c.keys.Read()
+	if err != nil {
+		return err
+	}
+	writeKeys, err := c.rotatedKeysWriter()
+	if err != nil {
+		return err
+	}
+
+	// 1) new keys are stored together with the old ones, so an interrupted rotation can be resumed
+	if keys.Previous == nil {
+		newKeys, err := generateKeys()
+		if err != nil {
+			return err
+		}
+		newKeys.Previous = &entity.Keys{AesKey: keys.AesKey, EcdsaKey: keys.EcdsaKey}
+		if err := writeKeys(*newKeys); err != nil {
+			return err
+		}
+		keys = newKeys
+	} else if verbosity > 0 {
+		fmt.Printf("resuming unfinished rotation\n")
+	}
+	newAddr, err := c.keysAddress(keys)
+	if err != nil {
+		return err
+	}
+	oldAddr, err := c.keysAddress(keys.Previous)
+	if err != nil {
+		return err
+	}
+	if verbosity > 0 {
+		fmt.Printf("rotating keys from %s to %s\n", oldAddr, newAddr)
+	}
+
+	// 2) data keys are encrypted with the new master key, file contents stay as they are
+	previousAesKey, err := hex.DecodeString(keys.Previous.AesKey)
+	if err != nil {
+		return err
+	}
+	rewrapped, err := c.rewrapDataKeys(previousAesKey)
+	if err != nil {
+		return err
+	}
+	if verbosity > 0 {
+		fmt.Printf("re-encrypted data keys of %d files\n", rewrapped)
+	}
+
+	// 3) nodes move our chunks to the new address
+	nodes, err := c.getAvailableNodes(verbosity)
+	if err != nil {
+		return err
+	}
+	newAddrBytes, err := hex.DecodeString(newAddr)
+	if err != nil {
+		return err
+	}
+	previousSigner, err := c.crypto.PreviousSigner()
+	if err != nil {
+		return err
+	}
+	// only nodes that list chunks under the old address have to move them, the rest of the nodes
+	// recorded in the index aren't announced anymore and can't be waited for
+	owners := make(map[string]bool)
+	for nodeAddr, chunks := range c.listOwnedChunks(nodes, previousSigner, verbosity) {
+		if len(chunks) > 0 {
+			owners[nodeAddr] = true
+		}
+	}
+	departed, err := c.departedOwnerNodes(nodes)
+	if err != nil {
+		return err
+	}
+	if len(departed) > 0 && verbosity > 0 {
+		fmt.Printf("%d nodes recorded in the index aren't announced anymore, their chunks can't be moved: %v\n",
+			len(departed), departed)
+	}
+	moved := 0
+	reached := make(map[string]bool)
+	for nodeAddr := range owners {
+		node := nodes[nodeAddr]
+		count, err := c.handoverNode(nodeAddr, node, previousSigner, newAddrBytes)
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("failed to hand over chunks on %s: %e\n", nodeAddr, err)
+			}
+			continue
+		}
+		reached[nodeAddr] = true
+		moved += count
+	}
+	if verbosity > 0 {
+		fmt.Printf("%d chunks moved to the new address on %d nodes\n", moved, len(reached))
+	}
+
+	// 4) the old keys are dropped once every node holding our chunks has moved them
+	missing := make([]string, 0)
+	for nodeAddr := range owners {
+		if !reached[nodeAddr] {
+			missing = append(missing, nodeAddr)
+		}
+	}
+	if len(missing) > 0 && !cCtx.Bool("force") {
+		if verbosity > 0 {
+			fmt.Printf("%d nodes with our chunks are unreachable, the rotation is pending.\n", len(missing))
+			fmt.Printf("run distorage keys rotate again later, or with --force to give up on them\n")
+		}
+		return nil
+	}
+	previousBackup, err := c.storage.GetIndexBackup()
+	if err != nil {
+		return err
+	}
+	keys.Previous = nil
+	if err := writeKeys(*keys); err != nil {
+		return err
+	}
+
+	// the index backup pointer id depends on the address
+	if err := c.backupIndex(verbosity, true); err != nil && verbosity > 0 {
+		fmt.Printf("failed to back up the index: %e\n", err)
+	} else if err == nil && previousBackup != nil && previousBackup.Pointer != nil {
+		c.deleteChunk(*previousBackup.Pointer, nodes, verbosity)
+	}
+	fmt.Printf("keys rotated, your public addr is: %s\n", newAddr)
+	fmt.Printf("old key backups and seed words don't restore the new keys, run distorage keys export\n")
+	return nil
+}
+
+// rotatedKeysWriter returns a function writing the rotated keys. keys.json stays encrypted if it was,
+// the new passphrase is asked once on the first write
+func (c *Commands) rotatedKeysWriter() (func(entity.Keys) error, error) {
+	encrypted, err := c.keys.IsEncrypted()
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return c.keys.Write, nil
+	}
+	var passphrase []byte
+	return func(keys entity.Keys) error {
+		if passphrase == nil {
+			newPassphrase, err := ReadPassphrase("keystore passphrase", true)
+			if err != nil {
+				return err
+			}
+			passphrase = newPassphrase
+		}
+		return c.keys.WriteEncrypted(keys, passphrase)
+	}, nil
+}
+
+// rewrapDataKeys encrypts data keys of all files with the current master key, files that already
+// use it are skipped. The data key of files uploaded before data keys were introduced is previousAesKey.
+// All data keys are rewrapped in one index update, so the index never mixes the old and the new key
+func (c *Commands) rewrapDataKeys(previousAesKey []byte) (int, error) {
+	rewrapped := 0
+	err := c.storage.Modify(func(fileInfos map[uuid2.UUID]entity.FileInfo) error {
+		rewrapped = 0
+		for uuid, fileInfo := range fileInfos {
+			var dataKey []byte
+			if fileInfo.DataKey == "" {
+				dataKey = previousAesKey
+			} else {
+				sealed, err := hex.DecodeString(fileInfo.DataKey)
+				if err != nil {
+					return err
+				}
+				if _, err := c.crypto.DecryptWithMasterKey(sealed); err == nil {
+					continue
+				}
+				if dataKey, err = c.crypto.DecryptWithPreviousMasterKey(sealed); err != nil {
+					return fmt.Errorf("failed to decrypt the data key of %s: %w", fileInfo.Name, err)
+				}
+			}
+			wrapped, err := c.crypto.EncryptWithMasterKey(dataKey)
+			if err != nil {
+				return err
+			}
+			fileInfo.DataKey = hex.EncodeToString(wrapped)
+			fileInfos[uuid] = fileInfo
+			rewrapped += 1
+		}
+		return nil
+	})
+	return rewrapped, err
+}
+
+// departedOwnerNodes returns nodes that store our files or index backup according to the index
+// but aren't announced anymore
+func (c *Commands) departedOwnerNodes(nodes map[string]entity.Node) ([]string, error) {
+	fileInfos, err := c.storage.GetFileInfos()
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]entity.ChunkInfo, 0)
+	for _, fileInfo := range fileInfos {
+		chunks = append(chunks, fileInfo.Chunks...)
+	}
+	backup, err := c.storage.GetIndexBackup()
+	if err != nil {
+		return nil, err
+	}
+	if backup != nil {
+		chunks = append(chunks, backup.Chunks...)
+		if backup.Pointer != nil {
+			chunks = append(chunks, *backup.Pointer)
+		}
+	}
+	departed := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, chunk := range chunks {
+		for _, nodeAddr := range chunk.Nodes {
+			if _, exists := nodes[nodeAddr]; !exists && !seen[nodeAddr] {
+				seen[nodeAddr] = true
+				departed = append(departed, nodeAddr)
+			}
+		}
+	}
+	return departed, nil
+}
+
+// handoverNode asks the node to move all chunks of the owner of previousSigner to newAddr
+// and returns how many were moved
+func (c *Commands) handoverNode(nodeAddr string, node entity.Node, previousSigner crypto.Signer, newAddr []byte) (int, error) {
+	nodeURL, err := buildNodeURL(node, "/handover")
+	if err != nil {
+		return 0, err
+	}
+	conn, _, err := c.nodeDialer(nodeAddr, node).Dial(nodeURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	expiry := time.Now().Add(HANDOVER_TTL).Unix()
+	handoverSig, err := c.crypto.SignHandover(previousSigner, newAddr, expiry)
+	if err != nil {
+		return 0, err
+	}
+	sharedKey, err := c.executePreamble(previousSigner, conn)
+	if err != nil {
+		return 0, err
+	}
+	verification, err := c.crypto.PrepareVerification(sharedKey, previousSigner)
+	if err != nil {
+		return 0, err
+	}
+	msg := make([]byte, 0, len(verification)+len(newAddr)+8+1+len(handoverSig))
+	msg = append(msg, verification...)
+	msg = append(msg, newAddr...)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(expiry))
+	msg = append(msg, byte(len(handoverSig)))
+	msg = append(msg, handoverSig...)
+
+	err = conn.WriteMessage(websocket.BinaryMessage, msg)
+	if err != nil {
+		return 0, err
+	}
+	mt, message, err := conn.ReadMessage()
+	if err != nil {
+		return 0, err
+	}
+	if mt != websocket.BinaryMessage {
+		return 0, fmt.Errorf("wrong message type received: %d", mt)
+	}
+	if bytes.Equal(message, []byte{0x01, 0x90}) {
+		return 0, fmt.Errorf("node rejected the handover")
+	}
+	if bytes.Equal(message, []byte{0x01, 0x91}) {
+		return 0, fmt.Errorf("node rejected the handover signature")
+	}
+	if len(message) != 5 || message[0] != 0xc8 {
+		return 0, fmt.Errorf("wrong message received: %x", message)
+	}
+	return int(binary.LittleEndian.Uint32(message[1:])), nil
+}
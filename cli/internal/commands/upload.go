@@ -9,9 +9,9 @@ import (
 	"github.com/schollz/progressbar/v3"
 	"github.com/urfave/cli/v2"
 	"log"
-	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 func (c *Commands) GetUploadCommand() *cli.Command {
@@ -24,15 +24,15 @@ func (c *Commands) GetUploadCommand() *cli.Command {
 }
 
 func (c *Commands) uploadFile(body []byte, conn *websocket.Conn) error {
-	ecdsaPrivKey, err := c.crypto.ReadECDSAPrivKey()
+	signer, err := c.crypto.Signer()
 	if err != nil {
 		return err
 	}
-	sharedKey, err := c.executePreamble(ecdsaPrivKey, conn)
+	sharedKey, err := c.executePreamble(signer, conn)
 	if err != nil {
 		return err
 	}
-	verification, err := c.crypto.PrepareVerification(sharedKey, ecdsaPrivKey)
+	verification, err := c.crypto.PrepareVerification(sharedKey, signer)
 	msg := make([]byte, len(verification)+len(body))
 	copy(msg[:len(verification)], verification)
 	copy(msg[len(verification):], body)
@@ -55,8 +55,54 @@ func (c *Commands) uploadFile(body []byte, conn *websocket.Conn) error {
 	return nil
 }
 
+// storeChunk stores the chunk under chunkHash on up to ReplicationCount nodes in placement order
+// and returns the addresses of the nodes that accepted it
+func (c *Commands) storeChunk(i int, chunkHash string, chunk []byte, nodes map[string]entity.Node, verbosity int) []string {
+	storageNodes := make([]string, 0, c.cfg.ReplicationCount)
+	for _, node := range c.placement.Place(chunkHash, nodes) {
+		if len(storageNodes) >= c.cfg.ReplicationCount {
+			break
+		}
+		addr := node.Addr
+		nodeURL, err := buildNodeURL(node, fmt.Sprintf("/store/%s", chunkHash))
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("error decoding node URL: %e\n", err)
+			}
+			continue
+		}
+		if verbosity > 1 {
+			log.Printf("connecting to %s\n", nodeURL)
+		}
+		start := time.Now()
+		conn, _, err := c.nodeDialer(addr, node).Dial(nodeURL, nil)
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("dial to %s error: %e\n", addr, err)
+			}
+			c.stats.RecordFailure(addr)
+			continue
+		}
+		err = c.uploadFile(chunk, conn)
+		_ = conn.Close()
+		if err != nil {
+			if verbosity > 1 {
+				log.Printf("failed to upload chunk #%d to %s: %e\n", i, addr, err)
+			}
+			c.stats.RecordFailure(addr)
+			continue
+		}
+		c.stats.RecordSuccess(addr, time.Since(start))
+		storageNodes = append(storageNodes, addr)
+	}
+	return storageNodes
+}
+
 func (c *Commands) upload(cCtx *cli.Context) error {
 	verbosity := cCtx.Int("verbosity")
+	if err := c.unlockKeys(); err != nil {
+		return err
+	}
 	if !cCtx.Bool("no-cleanup") {
 		totalFiles, deletedFiles, err := c.Cleanup(cCtx)
 		if verbosity > 0 {
@@ -76,12 +122,12 @@ func (c *Commands) upload(cCtx *cli.Context) error {
 
 	contentsHash := hex.EncodeToString(c.crypto.Hash(contents))
 
-	// encrypt file
-	aesKey, err := c.crypto.ReadAesKey()
+	// encrypt file with its own data key, the data key is stored encrypted with the master key
+	dataKey, wrappedDataKey, err := c.newDataKey()
 	if err != nil {
 		return err
 	}
-	encryptedContents, err := c.crypto.AESEncrypt(aesKey, contents)
+	encryptedContents, err := c.crypto.AESEncrypt(dataKey, contents)
 	if err != nil {
 		return err
 	}
@@ -96,7 +142,7 @@ func (c *Commands) upload(cCtx *cli.Context) error {
 		chunks = append(chunks, encryptedContents[i:end])
 	}
 
-	nodes, err := c.server.GetAvailableNodes()
+	nodes, err := c.getAvailableNodes(verbosity)
 	if err != nil {
 		return err
 	}
@@ -111,44 +157,10 @@ func (c *Commands) upload(cCtx *cli.Context) error {
 	}
 	chunkInfos := make([]entity.ChunkInfo, 0)
 	for i, chunk := range chunks {
+		nodes = c.currentNodes(nodes)
 		chunkHash := hex.EncodeToString(c.crypto.Hash(chunk))
-		it := 0
-		storageNodes := make([]string, 0)
-		for addr, ip := range nodes {
-			ip = fmt.Sprintf("%s:53591", ip)
-			if it >= c.cfg.ReplicationCount {
-				break
-			}
-			u := url.URL{Scheme: "ws", Host: ip, Path: fmt.Sprintf("/store/%s", chunkHash)}
-			nodeURL, err := url.PathUnescape(u.String())
-			if err != nil {
-				if verbosity > 1 {
-					log.Printf("error decoding node URL: %e\n", err)
-				}
-				continue
-			}
-			if verbosity > 1 {
-				log.Printf("connecting to %s\n", nodeURL)
-			}
-			conn, _, err := websocket.DefaultDialer.Dial(nodeURL, nil)
-			if err != nil {
-				if verbosity > 1 {
-					log.Printf("dial to %s error: %e\n", ip, err)
-				}
-				continue
-			}
-			defer conn.Close()
-			err = c.uploadFile(chunk, conn)
-			if err != nil {
-				if verbosity > 1 {
-					log.Printf("failed to upload chunk #%d to %s: %e\n", i, ip, err)
-				}
-				continue
-			}
-			it += 1
-			storageNodes = append(storageNodes, addr)
-		}
-		if it == 0 {
+		storageNodes := c.storeChunk(i, chunkHash, chunk, nodes, verbosity)
+		if len(storageNodes) == 0 {
 			return fmt.Errorf("failed to upload chunk %d to any nodes, sorry :(", i)
 		}
 		chunkInfos = append(chunkInfos, entity.ChunkInfo{
@@ -174,6 +186,7 @@ func (c *Commands) upload(cCtx *cli.Context) error {
 		Hash:      contentsHash,
 		Size:      len(contents),
 		Chunks:    chunkInfos,
+		DataKey:   wrappedDataKey,
 	}
 
 	fileUUID, err := c.storage.AppendFileInfo(fileInfo)
@@ -185,5 +198,6 @@ func (c *Commands) upload(cCtx *cli.Context) error {
 		fmt.Printf("you can download it later with\n")
 		fmt.Printf("distorage download %s\n", fileUUID)
 	}
+	c.autoBackupIndex(verbosity)
 	return nil
 }
@@ -1,18 +1,27 @@
 package commands
 
 import (
+	"bufio"
+	"bytes"
 	"cli/internal/entity"
-	"crypto/ecdsa"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+	"net"
 	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
-func (c *Commands) executePreamble(ecdsaPrivKey *ecdsa.PrivateKey, conn *websocket.Conn) ([]byte, error) {
-	ecdsaPubKey := ecdsaPrivKey.PublicKey
-	marshalledEcdsaPubKey, err := x509.MarshalPKIXPublicKey(&ecdsaPubKey)
+func (c *Commands) executePreamble(signer crypto.Signer, conn *websocket.Conn) ([]byte, error) {
+	marshalledEcdsaPubKey, err := x509.MarshalPKIXPublicKey(signer.Public())
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +54,154 @@ func (c *Commands) executePreamble(ecdsaPrivKey *ecdsa.PrivateKey, conn *websock
 	return c.crypto.ExecuteECDH(ecdhPrivKey, message)
 }
 
+// nodeBaseURL returns scheme://host:port the node announced
+func nodeBaseURL(node entity.Node) string {
+	scheme := node.Scheme
+	if scheme == "" {
+		scheme = "ws"
+	}
+	u := url.URL{Scheme: scheme, Host: net.JoinHostPort(node.Host, node.Port)}
+	return u.String()
+}
+
+// nodeDialer returns websocket dialer for the node. For wss the node certificate is accepted
+// if it matches the announced fingerprint or is issued for the node's identity key.
+// Without an announced fingerprint a certificate chaining to the system roots is accepted as well
+func (c *Commands) nodeDialer(nodeAddr string, node entity.Node) *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	if node.Scheme != "wss" {
+		return &dialer
+	}
+	dialer.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// default verification is replaced with VerifyPeerCertificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("node %s presented no certificate", nodeAddr)
+			}
+			if node.CertFingerprint != "" {
+				fingerprint := sha256.Sum256(rawCerts[0])
+				if hex.EncodeToString(fingerprint[:]) == strings.ToLower(node.CertFingerprint) {
+					return nil
+				}
+			}
+			addr, err := hex.DecodeString(nodeAddr)
+			if err != nil {
+				return err
+			}
+			identityErr := c.crypto.VerifyNodeCertificate(rawCerts, addr)
+			if identityErr == nil || node.CertFingerprint != "" {
+				return identityErr
+			}
+			return verifyCertificateChain(rawCerts, node.Host)
+		},
+	}
+	return &dialer
+}
+
+// verifyCertificateChain verifies the certificate chain against the system roots
+func verifyCertificateChain(rawCerts [][]byte, host string) error {
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates})
+	return err
+}
+
+// buildNodeURL builds websocket url of the given route on the node
+func buildNodeURL(node entity.Node, route string) (string, error) {
+	return url.PathUnescape(nodeBaseURL(node) + route)
+}
+
+// staleNodesAge is the age of the node list after which commands warn that trackers are unreachable
+const staleNodesAge = time.Minute
+
+// getAvailableNodes fetches nodes from trackers and warns if only an old cached list is available
+func (c *Commands) getAvailableNodes(verbosity int) (map[string]entity.Node, error) {
+	nodes, err := c.server.GetAvailableNodes()
+	if err != nil {
+		return nil, err
+	}
+	if age := c.server.NodesAge(); age > staleNodesAge && verbosity > 0 {
+		fmt.Printf("warning: trackers are unreachable, using node list from %s ago\n", age.Round(time.Second))
+	}
+	return nodes, nil
+}
+
+// currentNodes returns the latest view of the cluster, so long transfers stop using nodes
+// that left while they run. The view is kept in memory, the call doesn't ask trackers again
+func (c *Commands) currentNodes(nodes map[string]entity.Node) map[string]entity.Node {
+	current, err := c.server.GetAvailableNodes()
+	if err != nil {
+		return nodes
+	}
+	return current
+}
+
+// confirm asks user a yes/no question, anything except "y" or "yes" means no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// unlockKeys reads the keys before any requests to nodes, so a missing passphrase
+// isn't reported as unavailable nodes
+func (c *Commands) unlockKeys() error {
+	_, err := c.crypto.Signer()
+	return err
+}
+
+// PASSPHRASE_ENV is read instead of asking for a passphrase, for scripts
+const PASSPHRASE_ENV = "DISTORAGE_PASSPHRASE"
+
+// ReadPassphrase asks for a passphrase without echo, twice if it's a new one.
+// Prompts go to stderr so they don't mix with exported data
+func ReadPassphrase(prompt string, isNew bool) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(PASSPHRASE_ENV); ok {
+		return []byte(passphrase), nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("no terminal to ask for a passphrase, set %s", PASSPHRASE_ENV)
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !isNew {
+		return passphrase, nil
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	fmt.Fprintf(os.Stderr, "repeat passphrase: ")
+	repeated, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		return nil, fmt.Errorf("passphrases don't match")
+	}
+	return passphrase, nil
+}
+
 func (c *Commands) Cleanup(cCtx *cli.Context) (int, int, error) {
 	fileInfos, err := c.storage.GetFileInfos()
 	if err != nil {
@@ -67,21 +224,19 @@ func (c *Commands) Cleanup(cCtx *cli.Context) (int, int, error) {
 		for _, chunk := range fileInfo.Chunks {
 			leftNodes := make([]string, 0)
 			for _, nodeAddr := range chunk.Nodes {
-				nodeIp, exists := nodes[nodeAddr]
+				node, exists := nodes[nodeAddr]
 				if !exists {
 					leftNodes = append(leftNodes, nodeAddr)
 					continue
 				}
-				nodeIp = fmt.Sprintf("%s:53591", nodeIp)
-				u := url.URL{Scheme: "ws", Host: nodeIp, Path: fmt.Sprintf("/delete/%s", chunk.Hash)}
-				nodeURL, err := url.PathUnescape(u.String())
+				nodeURL, err := buildNodeURL(node, fmt.Sprintf("/delete/%s", chunk.Hash))
 				if err != nil {
 					//if verbosity > 1 {
 					//	log.Fatalf("error decoding node url: %e", err)
 					//}
 					continue
 				}
-				conn, _, err := websocket.DefaultDialer.Dial(nodeURL, nil)
+				conn, _, err := c.nodeDialer(nodeAddr, node).Dial(nodeURL, nil)
 				if err != nil {
 					leftNodes = append(leftNodes, nodeAddr)
 					continue
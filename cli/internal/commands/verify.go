@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"cli/internal/entity"
+	"cli/internal/usecase"
+	"fmt"
+	uuid2 "github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+	"strings"
+)
+
+func (c *Commands) GetVerifyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "verify",
+		Aliases:   []string{"v"},
+		Usage:     "check that replicas of uploaded files are available and spread over failure domains",
+		ArgsUsage: "[uuid]",
+		Action:    c.verify,
+	}
+}
+
+func (c *Commands) verify(cCtx *cli.Context) error {
+	fileInfos, err := c.storage.GetFileInfos()
+	if err != nil {
+		return err
+	}
+	if cCtx.Args().Present() {
+		uuid, err := uuid2.Parse(cCtx.Args().First())
+		if err != nil {
+			return err
+		}
+		fileInfo, err := c.storage.GetFileInfo(uuid)
+		if err != nil {
+			return err
+		}
+		fileInfos = map[uuid2.UUID]entity.FileInfo{uuid: *fileInfo}
+	}
+
+	nodes, err := c.getAvailableNodes(cCtx.Int("verbosity"))
+	if err != nil {
+		return err
+	}
+
+	degraded := 0
+	concentrated := 0
+	unavailable := 0
+	for uuid, fileInfo := range fileInfos {
+		if !fileInfo.Available {
+			continue
+		}
+		for i, chunk := range fileInfo.Chunks {
+			replicas := make([]entity.Node, 0, len(chunk.Nodes))
+			for _, nodeAddr := range chunk.Nodes {
+				if node, exists := nodes[nodeAddr]; exists {
+					replicas = append(replicas, node)
+				}
+			}
+			if len(replicas) == 0 {
+				fmt.Printf("%s (%s): chunk #%d has no available replicas\n", fileInfo.Name, uuid, i)
+				unavailable += 1
+				continue
+			}
+			if len(replicas) < c.cfg.ReplicationCount {
+				fmt.Printf("%s (%s): chunk #%d has %d/%d available replicas\n",
+					fileInfo.Name, uuid, i, len(replicas), c.cfg.ReplicationCount)
+				degraded += 1
+			}
+			concentrations := usecase.ConcentratedDomains(replicas, nodes)
+			if len(concentrations) == 0 {
+				continue
+			}
+			details := make([]string, 0, len(concentrations))
+			for _, concentration := range concentrations {
+				details = append(details, fmt.Sprintf("%d/%d %ss", concentration.Used, concentration.Available, concentration.Level))
+			}
+			fmt.Printf("warning: %s (%s): %d replicas of chunk #%d are concentrated in %s\n",
+				fileInfo.Name, uuid, len(replicas), i, strings.Join(details, ", "))
+			concentrated += 1
+		}
+	}
+
+	if degraded == 0 && concentrated == 0 && unavailable == 0 {
+		fmt.Printf("all replicas are available and spread over failure domains\n")
+		return nil
+	}
+	fmt.Printf("%d chunks without replicas, %d under-replicated, %d concentrated\n", unavailable, degraded, concentrated)
+	if degraded > 0 || unavailable > 0 {
+		fmt.Printf("you can restore missing replicas with distorage repair <uuid>\n")
+	}
+	if unavailable > 0 {
+		return fmt.Errorf("%d chunks have no available replicas", unavailable)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package entity
+
+import "encoding/json"
+
+// Contact is a DHT node as daemons exchange it: its address, url and signed announcement
+type Contact struct {
+	ID   string          `json:"id"`
+	URL  string          `json:"url"`
+	Info json.RawMessage `json:"info,omitempty"`
+}
+
+// DHTRequest is sent by the cli without a sender, so daemons don't add it to their routing tables
+type DHTRequest struct {
+	Target string `json:"target"`
+}
+
+type DHTResponse struct {
+	Sender    Contact   `json:"sender"`
+	Contacts  []Contact `json:"contacts,omitempty"`
+	Providers []Contact `json:"providers,omitempty"`
+}
@@ -1,15 +1,38 @@
 package entity
 
+import "time"
+
 type ChunkInfo struct {
 	Number int
 	Hash   string
 	Nodes  []string
 }
 
+// FileInfo describes an uploaded file. DataKey is the key the file is encrypted with, itself encrypted
+// with the master key, files uploaded before data keys were introduced are encrypted with the master key
 type FileInfo struct {
 	Name      string
 	Hash      string
 	Available bool
 	Size      int
 	Chunks    []ChunkInfo
+	DataKey   string `json:",omitempty"`
+}
+
+// StoredChunk is a chunk as listed by a node. ModTime is zero if the node doesn't report it
+type StoredChunk struct {
+	Hash    string
+	Size    int64
+	ModTime time.Time
+}
+
+// IndexBackup describes an encrypted copy of the file index stored in the network.
+// Seq orders backups, Hash and Size are of the plaintext index, Chunks hold the encrypted index.
+// Pointer is where the backup description itself is stored, it's kept only locally
+type IndexBackup struct {
+	Seq     int64       `json:"seq"`
+	Hash    string      `json:"hash"`
+	Size    int         `json:"size"`
+	Chunks  []ChunkInfo `json:"chunks"`
+	Pointer *ChunkInfo  `json:"pointer,omitempty"`
 }
@@ -1,6 +1,23 @@
 package entity
 
+// Keys is the content of keys.json. A passphrase-protected keystore has only Encrypted,
+// a key bundle in base64. Previous are the keys being replaced while keys rotate hasn't finished
 type Keys struct {
-	AesKey   string `json:"aesKey"`
-	EcdsaKey string `json:"ecdsaKey"`
+	AesKey    string `json:"aesKey,omitempty"`
+	EcdsaKey  string `json:"ecdsaKey,omitempty"`
+	Encrypted string `json:"encrypted,omitempty"`
+	Previous  *Keys  `json:"previous,omitempty"`
+}
+
+// AgentRequest is one request to the key agent, Op is public, sign, encrypt, decrypt or stop.
+// Previous selects the keys being replaced by keys rotate
+type AgentRequest struct {
+	Op       string `json:"op"`
+	Data     []byte `json:"data,omitempty"`
+	Previous bool   `json:"previous,omitempty"`
+}
+
+type AgentResponse struct {
+	Data  []byte `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
 }
@@ -0,0 +1,50 @@
+package entity
+
+// Endpoint describes how to reach a node, as announced by the node itself.
+// CertFingerprint is sha256 of the node's TLS certificate, used to pin wss connections
+type Endpoint struct {
+	Host            string `json:"host"`
+	Port            string `json:"port"`
+	Scheme          string `json:"scheme"`
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+}
+
+// Node is a storage node as announced to the tracker.
+// Capacities are in bytes, Uptime is in seconds and Timestamp is when the node signed the announcement
+type Node struct {
+	Addr string `json:"addr"`
+	Endpoint
+	Version         string `json:"version"`
+	ProtocolVersion int    `json:"protocol_version"`
+	Region          string `json:"region,omitempty"`
+	Zone            string `json:"zone,omitempty"`
+	HostID          string `json:"host_id,omitempty"`
+	TotalCapacity   uint64 `json:"total_capacity"`
+	FreeCapacity    uint64 `json:"free_capacity"`
+	Uptime          int64  `json:"uptime"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+// FailureDomains are the labels of the groups of nodes that are likely to fail together,
+// from the widest to the narrowest. Empty label means the domain is unknown
+type FailureDomains struct {
+	Zone   string
+	Subnet string
+	Host   string
+}
+
+// SignedAnnouncement is the node announcement JSON together with the node's signature over it
+type SignedAnnouncement struct {
+	Announcement []byte `json:"announcement"`
+	PubKey       string `json:"pub_key"`
+	Signature    string `json:"signature"`
+}
+
+// NodeEvent is a change of the tracker's node list. The first event of a subscription is
+// a snapshot with all nodes, then join, update and leave events follow
+type NodeEvent struct {
+	Type  string          `json:"type"`
+	Addr  string          `json:"addr,omitempty"`
+	Node  *Node           `json:"node,omitempty"`
+	Nodes map[string]Node `json:"nodes,omitempty"`
+}
@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// NodeStats is what the CLI remembers about a node between runs.
+// Latencies are the most recent successful request durations in milliseconds
+type NodeStats struct {
+	Successes           int64     `json:"successes"`
+	Failures            int64     `json:"failures"`
+	IntegrityFailures   int64     `json:"integrity_failures"`
+	ConsecutiveFailures int64     `json:"consecutive_failures"`
+	Latencies           []float64 `json:"latencies"`
+	LastSeen            time.Time `json:"last_seen"`
+	QuarantinedUntil    time.Time `json:"quarantined_until"`
+}
@@ -0,0 +1,327 @@
+package usecase
+
+import (
+	"cli/internal/entity"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	AGENT_OP_PUBLIC  = "public"
+	AGENT_OP_SIGN    = "sign"
+	AGENT_OP_ENCRYPT = "encrypt"
+	AGENT_OP_DECRYPT = "decrypt"
+	AGENT_OP_STOP    = "stop"
+
+	// AGENT_TIMEOUT limits one request to the agent
+	AGENT_TIMEOUT = 5 * time.Second
+
+	// AES_NONCE_SIZE is the GCM nonce size AESEncrypt puts before the ciphertext
+	AES_NONCE_SIZE = 12
+)
+
+// ErrNoPreviousKeys is returned for the previous keys when no rotation is in progress
+var ErrNoPreviousKeys = errors.New("no previous keys, keys rotate is not in progress")
+
+// AgentClient talks to the key agent over its unix socket
+type AgentClient struct {
+	socketPath string
+}
+
+func NewAgentClient(socketPath string) *AgentClient {
+	return &AgentClient{socketPath: socketPath}
+}
+
+func (a *AgentClient) SocketPath() string {
+	return a.socketPath
+}
+
+func (a *AgentClient) call(request entity.AgentRequest) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", a.socketPath, AGENT_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(AGENT_TIMEOUT))
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, err
+	}
+	response := entity.AgentResponse{}
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Error == ErrNoPreviousKeys.Error() {
+		return nil, ErrNoPreviousKeys
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("agent: %s", response.Error)
+	}
+	return response.Data, nil
+}
+
+// Available reports whether an agent is listening on the socket
+func (a *AgentClient) Available() bool {
+	conn, err := net.DialTimeout("unix", a.socketPath, AGENT_TIMEOUT)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// Public returns the PKIX encoded public key of the ecdsa key held by the agent
+func (a *AgentClient) Public(previous bool) ([]byte, error) {
+	return a.call(entity.AgentRequest{Op: AGENT_OP_PUBLIC, Previous: previous})
+}
+
+// Sign signs the digest with the ecdsa key, the signature is ASN.1 encoded
+func (a *AgentClient) Sign(digest []byte, previous bool) ([]byte, error) {
+	return a.call(entity.AgentRequest{Op: AGENT_OP_SIGN, Data: digest, Previous: previous})
+}
+
+// Encrypt seals data like AESEncrypt with the aes key
+func (a *AgentClient) Encrypt(plaintext []byte) ([]byte, error) {
+	return a.call(entity.AgentRequest{Op: AGENT_OP_ENCRYPT, Data: plaintext})
+}
+
+// Decrypt opens data sealed by AESEncrypt with the aes key
+func (a *AgentClient) Decrypt(sealed []byte, previous bool) ([]byte, error) {
+	return a.call(entity.AgentRequest{Op: AGENT_OP_DECRYPT, Data: sealed, Previous: previous})
+}
+
+// Stop makes the agent forget the keys and exit
+func (a *AgentClient) Stop() error {
+	_, err := a.call(entity.AgentRequest{Op: AGENT_OP_STOP})
+	return err
+}
+
+// agentSigner signs with a key that never leaves the agent
+type agentSigner struct {
+	agent    KeyAgent
+	public   *ecdsa.PublicKey
+	previous bool
+}
+
+func newAgentSigner(agent KeyAgent, previous bool) (*agentSigner, error) {
+	pubKeyBytes, err := agent.Public(previous)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("wrong key type: %T", pubKey)
+	}
+	return &agentSigner{agent: agent, public: ecdsaPubKey, previous: previous}, nil
+}
+
+func (s *agentSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *agentSigner) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return s.agent.Sign(digest, s.previous)
+}
+
+// agentKeys are the parsed keys of one generation
+type agentKeys struct {
+	ecdsaKey *ecdsa.PrivateKey
+	aesKey   []byte
+}
+
+func parseAgentKeys(keys *entity.Keys) (*agentKeys, error) {
+	ecdsaKey, err := parseECDSAKey(keys.EcdsaKey)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, err := hex.DecodeString(keys.AesKey)
+	if err != nil {
+		return nil, err
+	}
+	return &agentKeys{ecdsaKey: ecdsaKey, aesKey: aesKey}, nil
+}
+
+// AgentServer holds decrypted keys in memory and serves signing and encryption with them
+// on a unix socket that only the owner can open. The keys themselves are never sent
+type AgentServer struct {
+	socketPath string
+	current    *agentKeys
+	previous   *agentKeys
+	crypto     Crypto
+	// mu keeps keys from being wiped while a request uses them
+	mu       sync.RWMutex
+	stop     chan struct{}
+	stopOnce sync.Once
+	// listener is closed and the socket removed once, on stop or when ttl passes
+	listener  net.Listener
+	closeOnce sync.Once
+	// handlers are waited for before exit, so the reply to stop reaches the client
+	handlers sync.WaitGroup
+}
+
+func NewAgentServer(socketPath string, keys *entity.Keys, crypto Crypto) (*AgentServer, error) {
+	current, err := parseAgentKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	server := &AgentServer{
+		socketPath: socketPath,
+		current:    current,
+		crypto:     crypto,
+		stop:       make(chan struct{}),
+	}
+	if keys.Previous != nil {
+		if server.previous, err = parseAgentKeys(keys.Previous); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// Serve answers requests until ttl passes, Shutdown is called or a client asks to stop
+func (s *AgentServer) Serve(ttl time.Duration) error {
+	if NewAgentClient(s.socketPath).Available() {
+		return errors.New("agent is already running")
+	}
+	// the socket is created in a directory only the owner can enter,
+	// so it's never reachable by others, even before its own mode is set
+	socketDir := filepath.Dir(s.socketPath)
+	if err := os.MkdirAll(socketDir, 0700); err != nil {
+		return err
+	}
+	if err := os.Chmod(socketDir, 0700); err != nil {
+		return err
+	}
+	// the socket is left by an agent that was killed
+	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	defer s.closeListener()
+	if err := os.Chmod(s.socketPath, 0600); err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-s.stop:
+		case <-time.After(ttl):
+		}
+		s.closeListener()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.Shutdown()
+			s.handlers.Wait()
+			s.forget()
+			return nil
+		}
+		s.handlers.Add(1)
+		go func() {
+			defer s.handlers.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *AgentServer) Shutdown() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// closeListener stops accepting connections and removes the socket, so clients see right away
+// that the agent is gone and use the keystore
+func (s *AgentServer) closeListener() {
+	s.closeOnce.Do(func() {
+		_ = s.listener.Close()
+		_ = os.Remove(s.socketPath)
+	})
+}
+
+// forget overwrites key material before exit
+func (s *AgentServer) forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, keys := range []*agentKeys{s.current, s.previous} {
+		if keys == nil {
+			continue
+		}
+		clear(keys.aesKey)
+		keys.ecdsaKey.D.SetInt64(0)
+	}
+	s.current = nil
+	s.previous = nil
+}
+
+func (s *AgentServer) handle(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(AGENT_TIMEOUT))
+	request := entity.AgentRequest{}
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		return
+	}
+	data, err := s.process(request)
+	response := entity.AgentResponse{Data: data}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	_ = json.NewEncoder(conn).Encode(response)
+}
+
+func (s *AgentServer) process(request entity.AgentRequest) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	select {
+	case <-s.stop:
+		return nil, errors.New("agent is stopping")
+	default:
+	}
+	keys := s.current
+	if request.Previous {
+		keys = s.previous
+	}
+	if keys == nil && request.Op != AGENT_OP_STOP {
+		return nil, ErrNoPreviousKeys
+	}
+	switch request.Op {
+	case AGENT_OP_PUBLIC:
+		return x509.MarshalPKIXPublicKey(&keys.ecdsaKey.PublicKey)
+	case AGENT_OP_SIGN:
+		return ecdsa.SignASN1(rand.Reader, keys.ecdsaKey, request.Data)
+	case AGENT_OP_ENCRYPT:
+		if request.Previous {
+			return nil, errors.New("previous keys are only used for decryption")
+		}
+		return s.crypto.AESEncrypt(keys.aesKey, request.Data)
+	case AGENT_OP_DECRYPT:
+		if len(request.Data) < AES_NONCE_SIZE {
+			return nil, errors.New("ciphertext too short")
+		}
+		return s.crypto.AESDecrypt(keys.aesKey, request.Data)
+	case AGENT_OP_STOP:
+		// the stop is acknowledged only after the socket is gone
+		s.closeListener()
+		s.Shutdown()
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown op %s", request.Op)
+	}
+}
@@ -0,0 +1,282 @@
+package usecase
+
+import (
+	"cli/internal/entity"
+	"encoding/json"
+	"errors"
+	"fmt"
+	u "github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"time"
+)
+
+const (
+	// BOLT_LOCK_TIMEOUT is how long a command waits for another command to release the database
+	BOLT_LOCK_TIMEOUT = 30 * time.Second
+
+	boltFilesBucket = "files"
+	boltMetaBucket  = "meta"
+	boltImportedKey = "imported"
+	boltBackupKey   = "index_backup"
+)
+
+// BoltStorageUC keeps the file index in a bbolt database. Every call is one transaction,
+// the database file is locked only while it runs, so several commands can work at once
+// without losing each other's changes. Reads take a shared lock, writes an exclusive one.
+// On first use an existing files.json and index_backup.json are imported, files.json is then
+// renamed to files.json.imported
+type BoltStorageUC struct {
+	dbPath       string
+	jsonStorage  *StorageUC
+	fileInfoPath string
+}
+
+func NewBoltStorageUC(dbPath string, fileInfoPath string) *BoltStorageUC {
+	return &BoltStorageUC{
+		dbPath:       dbPath,
+		jsonStorage:  NewStorageUC(fileInfoPath, 0),
+		fileInfoPath: fileInfoPath,
+	}
+}
+
+// update opens the database, runs fn in a read-write transaction and closes the database
+func (s *BoltStorageUC) update(fn func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(s.dbPath, 0600, &bolt.Options{Timeout: BOLT_LOCK_TIMEOUT})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.dbPath, err)
+	}
+	defer db.Close()
+	imported := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		if imported, err = s.prepare(tx); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+	if err != nil {
+		return err
+	}
+	if imported {
+		// the old file must not be edited by mistake, it's kept only as a backup
+		return os.Rename(s.fileInfoPath, s.fileInfoPath+".imported")
+	}
+	return nil
+}
+
+// errNotPrepared means the database has to be created or imported before it can be read
+var errNotPrepared = errors.New("database is not prepared")
+
+// view runs fn with both buckets in a read-only transaction. Only on first use, when the database
+// is created and imported, it's a read-write transaction
+func (s *BoltStorageUC) view(fn func(files *bolt.Bucket, meta *bolt.Bucket) error) error {
+	err := s.viewPrepared(fn)
+	if !errors.Is(err, errNotPrepared) {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket([]byte(boltFilesBucket)), tx.Bucket([]byte(boltMetaBucket)))
+	})
+}
+
+func (s *BoltStorageUC) viewPrepared(fn func(files *bolt.Bucket, meta *bolt.Bucket) error) error {
+	// a database that was never written is created by update
+	if stat, err := os.Stat(s.dbPath); errors.Is(err, os.ErrNotExist) || (err == nil && stat.Size() == 0) {
+		return errNotPrepared
+	}
+	db, err := bolt.Open(s.dbPath, 0600, &bolt.Options{Timeout: BOLT_LOCK_TIMEOUT, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.dbPath, err)
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		files := tx.Bucket([]byte(boltFilesBucket))
+		meta := tx.Bucket([]byte(boltMetaBucket))
+		if files == nil || meta == nil || meta.Get([]byte(boltImportedKey)) == nil {
+			return errNotPrepared
+		}
+		return fn(files, meta)
+	})
+}
+
+// prepare creates buckets and imports the json index once, returns true if files.json was imported
+func (s *BoltStorageUC) prepare(tx *bolt.Tx) (bool, error) {
+	files, err := tx.CreateBucketIfNotExists([]byte(boltFilesBucket))
+	if err != nil {
+		return false, err
+	}
+	meta, err := tx.CreateBucketIfNotExists([]byte(boltMetaBucket))
+	if err != nil {
+		return false, err
+	}
+	if meta.Get([]byte(boltImportedKey)) != nil {
+		return false, nil
+	}
+	fileInfos, err := s.jsonStorage.GetFileInfos()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to import %s: %w", s.fileInfoPath, err)
+	}
+	for uuid, fileInfo := range fileInfos {
+		if err := putFileInfo(files, uuid, fileInfo); err != nil {
+			return false, err
+		}
+	}
+	backup, err := s.jsonStorage.GetIndexBackup()
+	if err != nil {
+		return false, err
+	}
+	if backup != nil {
+		if err := putJSON(meta, boltBackupKey, backup); err != nil {
+			return false, err
+		}
+	}
+	if err := meta.Put([]byte(boltImportedKey), []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return false, err
+	}
+	return fileInfos != nil, nil
+}
+
+func putJSON(bucket *bolt.Bucket, key string, value any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(key), encoded)
+}
+
+func putFileInfo(files *bolt.Bucket, uuid u.UUID, fileInfo entity.FileInfo) error {
+	return putJSON(files, uuid.String(), fileInfo)
+}
+
+func readFileInfos(files *bolt.Bucket) (map[u.UUID]entity.FileInfo, error) {
+	fileInfos := make(map[u.UUID]entity.FileInfo)
+	err := files.ForEach(func(k, v []byte) error {
+		uuid, err := u.ParseBytes(k)
+		if err != nil {
+			return err
+		}
+		fileInfo := entity.FileInfo{}
+		if err := json.Unmarshal(v, &fileInfo); err != nil {
+			return err
+		}
+		fileInfos[uuid] = fileInfo
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileInfos, nil
+}
+
+func (s *BoltStorageUC) GetFileInfos() (map[u.UUID]entity.FileInfo, error) {
+	var fileInfos map[u.UUID]entity.FileInfo
+	err := s.view(func(files *bolt.Bucket, _ *bolt.Bucket) error {
+		var err error
+		fileInfos, err = readFileInfos(files)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileInfos, nil
+}
+
+// Modify runs read-modify-write of the whole index in one transaction,
+// entries fn removes from the map are deleted
+func (s *BoltStorageUC) Modify(fn func(fileInfos map[u.UUID]entity.FileInfo) error) error {
+	return s.update(func(tx *bolt.Tx) error {
+		files := tx.Bucket([]byte(boltFilesBucket))
+		fileInfos, err := readFileInfos(files)
+		if err != nil {
+			return err
+		}
+		existing := make([]u.UUID, 0, len(fileInfos))
+		for uuid := range fileInfos {
+			existing = append(existing, uuid)
+		}
+		if err := fn(fileInfos); err != nil {
+			return err
+		}
+		for _, uuid := range existing {
+			if _, kept := fileInfos[uuid]; !kept {
+				if err := files.Delete([]byte(uuid.String())); err != nil {
+					return err
+				}
+			}
+		}
+		for uuid, fileInfo := range fileInfos {
+			if err := putFileInfo(files, uuid, fileInfo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorageUC) GetFileInfo(uuid u.UUID) (*entity.FileInfo, error) {
+	fileInfo := &entity.FileInfo{}
+	err := s.view(func(files *bolt.Bucket, _ *bolt.Bucket) error {
+		v := files.Get([]byte(uuid.String()))
+		if v == nil {
+			return fmt.Errorf("file %s not found", uuid)
+		}
+		return json.Unmarshal(v, fileInfo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo, nil
+}
+
+func (s *BoltStorageUC) AppendFileInfo(fileInfo entity.FileInfo) (*u.UUID, error) {
+	uuid := u.New()
+	err := s.update(func(tx *bolt.Tx) error {
+		return putFileInfo(tx.Bucket([]byte(boltFilesBucket)), uuid, fileInfo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &uuid, nil
+}
+
+func (s *BoltStorageUC) UpdateFileInfo(uuid u.UUID, fileInfo entity.FileInfo) error {
+	return s.update(func(tx *bolt.Tx) error {
+		files := tx.Bucket([]byte(boltFilesBucket))
+		if files.Get([]byte(uuid.String())) == nil {
+			return fmt.Errorf("file %s not found", uuid)
+		}
+		return putFileInfo(files, uuid, fileInfo)
+	})
+}
+
+func (s *BoltStorageUC) DeleteFileInfo(uuid u.UUID) error {
+	return s.update(func(tx *bolt.Tx) error {
+		files := tx.Bucket([]byte(boltFilesBucket))
+		if files.Get([]byte(uuid.String())) == nil {
+			return fmt.Errorf("file %s not found", uuid)
+		}
+		return files.Delete([]byte(uuid.String()))
+	})
+}
+
+func (s *BoltStorageUC) GetIndexBackup() (*entity.IndexBackup, error) {
+	var backup *entity.IndexBackup
+	err := s.view(func(_ *bolt.Bucket, meta *bolt.Bucket) error {
+		v := meta.Get([]byte(boltBackupKey))
+		if v == nil {
+			return nil
+		}
+		backup = &entity.IndexBackup{}
+		return json.Unmarshal(v, backup)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+func (s *BoltStorageUC) SetIndexBackup(backup entity.IndexBackup) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket([]byte(boltMetaBucket)), boltBackupKey, backup)
+	})
+}
@@ -1,27 +1,50 @@
 package usecase
 
 import (
+	"bytes"
 	"cli/internal/entity"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/wealdtech/go-merkletree/keccak256"
 	"io"
-	"os"
 )
 
+// REPLICATION_PREFIX is prepended to signed replication authorisations
+// so they can't be confused with other owner signatures
+const REPLICATION_PREFIX = "distorage-replicate"
+
+// ANNOUNCEMENT_PREFIX is prepended to node announcements before the node signs them
+const ANNOUNCEMENT_PREFIX = "distorage-announcement"
+
+// HANDOVER_PREFIX is prepended to the owner's permission to move all chunks to the new address
+const HANDOVER_PREFIX = "distorage-handover"
+
+// INDEX_POINTER_PREFIX is hashed with the owner's address to get the chunk id of the index backup pointer
+const INDEX_POINTER_PREFIX = "distorage-index-pointer"
+
+// CryptoUC signs and encrypts with the user's keys. If the agent is running the keys stay in it,
+// otherwise they are read from the keystore
 type CryptoUC struct {
-	keysFilePath string
+	keys  KeyStore
+	agent KeyAgent
 }
 
-func NewCryptoUC(keysFilePath string) *CryptoUC {
-	return &CryptoUC{keysFilePath: keysFilePath}
+func NewCryptoUC(keys KeyStore, agent KeyAgent) *CryptoUC {
+	return &CryptoUC{keys: keys, agent: agent}
+}
+
+func (c *CryptoUC) useAgent() bool {
+	return c.agent != nil && c.agent.Available()
 }
 
 func (c *CryptoUC) GenerateECDHKey() (*ecdh.PrivateKey, error) {
@@ -46,38 +69,82 @@ func (c *CryptoUC) ExecuteECDH(own *ecdh.PrivateKey, remoteBytes []byte) ([]byte
 	}
 }
 
-func (c *CryptoUC) ReadECDSAPrivKey() (*ecdsa.PrivateKey, error) {
-	f, err := os.Open(c.keysFilePath)
+// Signer returns the user's signing key
+func (c *CryptoUC) Signer() (crypto.Signer, error) {
+	if c.useAgent() {
+		return newAgentSigner(c.agent, false)
+	}
+	keys, err := c.keys.Read()
 	if err != nil {
 		return nil, err
 	}
-	keys := &entity.Keys{}
-	if err := json.NewDecoder(f).Decode(&keys); err != nil {
+	return parseECDSAKey(keys.EcdsaKey)
+}
+
+// PreviousSigner returns the signing key being replaced by keys rotate, ErrNoPreviousKeys if there is none
+func (c *CryptoUC) PreviousSigner() (crypto.Signer, error) {
+	if c.useAgent() {
+		return newAgentSigner(c.agent, true)
+	}
+	keys, err := c.keys.Read()
+	if err != nil {
 		return nil, err
 	}
-	if err := f.Close(); err != nil {
+	if keys.Previous == nil {
+		return nil, ErrNoPreviousKeys
+	}
+	return parseECDSAKey(keys.Previous.EcdsaKey)
+}
+
+// EncryptWithMasterKey seals the data like AESEncrypt with the user's aes key
+func (c *CryptoUC) EncryptWithMasterKey(plaintext []byte) ([]byte, error) {
+	if c.useAgent() {
+		return c.agent.Encrypt(plaintext)
+	}
+	keys, err := c.keys.Read()
+	if err != nil {
 		return nil, err
 	}
-	ecdsaKeyBytes, err := hex.DecodeString(keys.EcdsaKey)
+	aesKey, err := hex.DecodeString(keys.AesKey)
 	if err != nil {
 		return nil, err
 	}
-	return x509.ParseECPrivateKey(ecdsaKeyBytes)
+	return c.AESEncrypt(aesKey, plaintext)
+}
+
+// DecryptWithMasterKey opens data sealed with the user's aes key
+func (c *CryptoUC) DecryptWithMasterKey(sealed []byte) ([]byte, error) {
+	return c.decryptWithMasterKey(sealed, false)
+}
+
+// DecryptWithPreviousMasterKey opens data sealed with the aes key being replaced by keys rotate,
+// ErrNoPreviousKeys if there is none
+func (c *CryptoUC) DecryptWithPreviousMasterKey(sealed []byte) ([]byte, error) {
+	return c.decryptWithMasterKey(sealed, true)
 }
 
-func (c *CryptoUC) ReadAesKey() ([]byte, error) {
-	f, err := os.Open(c.keysFilePath)
+func (c *CryptoUC) decryptWithMasterKey(sealed []byte, previous bool) ([]byte, error) {
+	if len(sealed) < AES_NONCE_SIZE {
+		return nil, errors.New("ciphertext too short")
+	}
+	if c.useAgent() {
+		return c.agent.Decrypt(sealed, previous)
+	}
+	keys, err := c.keys.Read()
 	if err != nil {
 		return nil, err
 	}
-	keys := &entity.Keys{}
-	if err := json.NewDecoder(f).Decode(&keys); err != nil {
-		return nil, err
+	if previous {
+		if keys.Previous == nil {
+			return nil, ErrNoPreviousKeys
+		}
+		keys = keys.Previous
 	}
-	if err := f.Close(); err != nil {
+	aesKey, err := hex.DecodeString(keys.AesKey)
+	if err != nil {
 		return nil, err
 	}
-	return hex.DecodeString(keys.AesKey)
+	return c.AESDecrypt(aesKey, sealed)
 }
 
 func (c *CryptoUC) GetAddress(pubKeyBytes []byte) []byte {
@@ -118,7 +185,7 @@ func (c *CryptoUC) AESDecrypt(key []byte, sealed []byte) ([]byte, error) {
 	return gcm.Open(nil, nonce, ciphertext, nil)
 }
 
-func (c *CryptoUC) PrepareVerification(aesKey []byte, ecdsaKey *ecdsa.PrivateKey) ([]byte, error) {
+func (c *CryptoUC) PrepareVerification(aesKey []byte, signer crypto.Signer) ([]byte, error) {
 	nonce := make([]byte, aes.BlockSize)
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
@@ -131,7 +198,7 @@ func (c *CryptoUC) PrepareVerification(aesKey []byte, ecdsaKey *ecdsa.PrivateKey
 	block.Encrypt(encNonce, nonce)
 	keccak := keccak256.New()
 	encNonceHash := keccak.Hash(encNonce)
-	sig, err := ecdsa.SignASN1(rand.Reader, ecdsaKey, encNonceHash)
+	sig, err := signer.Sign(rand.Reader, encNonceHash, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +209,98 @@ func (c *CryptoUC) PrepareVerification(aesKey []byte, ecdsaKey *ecdsa.PrivateKey
 	return res, nil
 }
 
+// SignReplication signs owner's permission for a node to push the chunk to the node with targetAddr
+func (c *CryptoUC) SignReplication(signer crypto.Signer, chunkId []byte, targetAddr []byte, expiry int64) ([]byte, error) {
+	msg := make([]byte, 0, len(REPLICATION_PREFIX)+len(chunkId)+len(targetAddr)+8)
+	msg = append(msg, REPLICATION_PREFIX...)
+	msg = append(msg, chunkId...)
+	msg = append(msg, targetAddr...)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(expiry))
+	return signer.Sign(rand.Reader, c.Hash(msg), nil)
+}
+
+// SignHandover signs with the old key the permission for nodes to give all chunks of the old address to newAddr
+func (c *CryptoUC) SignHandover(signer crypto.Signer, newAddr []byte, expiry int64) ([]byte, error) {
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	oldAddr := c.GetAddress(pubKeyBytes)
+	msg := make([]byte, 0, len(HANDOVER_PREFIX)+len(oldAddr)+len(newAddr)+8)
+	msg = append(msg, HANDOVER_PREFIX...)
+	msg = append(msg, oldAddr...)
+	msg = append(msg, newAddr...)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(expiry))
+	return signer.Sign(rand.Reader, c.Hash(msg), nil)
+}
+
+// VerifyNodeCertificate checks that the TLS certificate is issued for the key of the node with addr
+func (c *CryptoUC) VerifyNodeCertificate(rawCerts [][]byte, addr []byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no certificate presented")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(c.GetAddress(pubKeyBytes), addr) {
+		return errors.New("certificate is not bound to the node address")
+	}
+	return nil
+}
+
+// VerifyAnnouncement checks the node's signature over its announcement
+// and that the announced address belongs to the signing key
+func (c *CryptoUC) VerifyAnnouncement(signed entity.SignedAnnouncement) (*entity.Node, error) {
+	pubKeyBytes, err := hex.DecodeString(signed.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaPubKey, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("wrong key type: %T", pubKey)
+	}
+	msg := make([]byte, 0, len(ANNOUNCEMENT_PREFIX)+len(signed.Announcement))
+	msg = append(msg, ANNOUNCEMENT_PREFIX...)
+	msg = append(msg, signed.Announcement...)
+	if !ecdsa.VerifyASN1(ecdsaPubKey, c.Hash(msg), sig) {
+		return nil, errors.New("announcement signature check failed")
+	}
+	node := &entity.Node{}
+	if err := json.Unmarshal(signed.Announcement, node); err != nil {
+		return nil, err
+	}
+	if node.Addr != hex.EncodeToString(c.GetAddress(pubKeyBytes)) {
+		return nil, errors.New("announcement address mismatch")
+	}
+	return node, nil
+}
+
+// IndexPointerID returns the chunk id under which the owner's index backup pointer is stored.
+// It depends only on the key, so the index can be found again on a new machine
+func (c *CryptoUC) IndexPointerID(signer crypto.Signer) ([]byte, error) {
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(INDEX_POINTER_PREFIX)+20)
+	msg = append(msg, INDEX_POINTER_PREFIX...)
+	msg = append(msg, c.GetAddress(pubKeyBytes)...)
+	return c.Hash(msg), nil
+}
+
 func (c *CryptoUC) Hash(contents []byte) []byte {
 	keccak := keccak256.New()
 	return keccak.Hash(contents)
@@ -0,0 +1,308 @@
+package usecase
+
+import (
+	"bytes"
+	"cli/internal/entity"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DHT_ID_SIZE is the size of DHT keys, equal to node address size
+	DHT_ID_SIZE = 20
+	// DHT_ALPHA is how many daemons are queried in parallel
+	DHT_ALPHA = 8
+	// DHT_QUERY_LIMIT caps the number of daemons queried by one crawl or lookup
+	DHT_QUERY_LIMIT = 256
+	dhtTimeout      = 5 * time.Second
+	dhtMaxResponse  = 1 << 20
+)
+
+// DHTServerUC finds nodes through the daemons' Kademlia DHT instead of a tracker.
+// Any daemon can serve as an entry point, every daemon is trusted only after
+// its signed announcement is verified
+type DHTServerUC struct {
+	bootstrap []string
+	crypto    Crypto
+	client    *http.Client
+
+	fetchedAt time.Time
+}
+
+// NewDHTServerUC accepts entry daemons as scheme://host:port
+func NewDHTServerUC(bootstrap []string, crypto Crypto) *DHTServerUC {
+	return &DHTServerUC{
+		bootstrap: bootstrap,
+		crypto:    crypto,
+		client: &http.Client{
+			Timeout: dhtTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					MinVersion: tls.VersionTLS12,
+					// the certificate is checked against the node's signed announcement in verifyContact
+					InsecureSkipVerify: true,
+				},
+			},
+		},
+	}
+}
+
+// query sends rpc to the daemon at nodeURL and verifies that the answering daemon is the one
+// its announcement says. expectedID is empty for entry daemons
+func (s *DHTServerUC) query(nodeURL string, expectedID string, rpc string, target string) (*entity.DHTResponse, *entity.Node, error) {
+	u, err := url.Parse(nodeURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	default:
+		return nil, nil, fmt.Errorf("unknown scheme: %s", u.Scheme)
+	}
+	u.Path = "/dht/" + rpc
+	body, err := json.Marshal(entity.DHTRequest{Target: target})
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.client.Post(u.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+	response := &entity.DHTResponse{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, dhtMaxResponse)).Decode(response); err != nil {
+		return nil, nil, err
+	}
+	if expectedID != "" && response.Sender.ID != expectedID {
+		return nil, nil, fmt.Errorf("node %s answered as %s", expectedID, response.Sender.ID)
+	}
+	var rawCerts [][]byte
+	if resp.TLS != nil {
+		for _, cert := range resp.TLS.PeerCertificates {
+			rawCerts = append(rawCerts, cert.Raw)
+		}
+	}
+	// the url we reached the daemon at is known to work
+	response.Sender.URL = nodeURL
+	node, err := s.verifyContact(response.Sender, rawCerts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, node, nil
+}
+
+// verifyContact checks the contact's signed announcement and, for wss, the presented certificate.
+// The node's host and port are taken from the contact url if the node didn't announce them
+func (s *DHTServerUC) verifyContact(contact entity.Contact, rawCerts [][]byte) (*entity.Node, error) {
+	signed := entity.SignedAnnouncement{}
+	if err := json.Unmarshal(contact.Info, &signed); err != nil {
+		return nil, err
+	}
+	node, err := s.crypto.VerifyAnnouncement(signed)
+	if err != nil {
+		return nil, err
+	}
+	if node.Addr != contact.ID {
+		return nil, errors.New("contact id doesn't match announcement")
+	}
+	if len(rawCerts) > 0 {
+		fingerprint := sha256.Sum256(rawCerts[0])
+		if node.CertFingerprint == "" || hex.EncodeToString(fingerprint[:]) != strings.ToLower(node.CertFingerprint) {
+			addr, err := hex.DecodeString(node.Addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := s.crypto.VerifyNodeCertificate(rawCerts, addr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	contactURL, err := url.Parse(contact.URL)
+	if err != nil {
+		return nil, err
+	}
+	if node.Host == "" {
+		node.Host = contactURL.Hostname()
+	}
+	if node.Port == "" {
+		node.Port = contactURL.Port()
+	}
+	return node, nil
+}
+
+type dhtCandidate struct {
+	url string
+	id  string
+}
+
+type dhtResult struct {
+	candidate dhtCandidate
+	resp      *entity.DHTResponse
+	node      *entity.Node
+	err       error
+}
+
+// walk queries daemons in batches of DHT_ALPHA starting from the entry daemons.
+// next picks the candidates to query from the not yet queried ones, visit handles every answer
+// and returns true to stop the walk
+func (s *DHTServerUC) walk(
+	rpc string,
+	target string,
+	next func(candidates []dhtCandidate) []dhtCandidate,
+	visit func(result dhtResult) bool,
+) error {
+	candidates := make([]dhtCandidate, 0, len(s.bootstrap))
+	known := make(map[string]bool)
+	for _, u := range s.bootstrap {
+		candidates = append(candidates, dhtCandidate{url: u})
+	}
+	queried := 0
+	answered := 0
+	errs := make([]error, 0)
+	for len(candidates) > 0 && queried < DHT_QUERY_LIMIT {
+		batch := slices.Clone(next(candidates))
+		if len(batch) > DHT_ALPHA {
+			batch = batch[:DHT_ALPHA]
+		}
+		candidates = slices.DeleteFunc(candidates, func(c dhtCandidate) bool {
+			return slices.Contains(batch, c)
+		})
+		queried += len(batch)
+
+		results := make(chan dhtResult, len(batch))
+		var wg sync.WaitGroup
+		for _, candidate := range batch {
+			wg.Add(1)
+			go func(candidate dhtCandidate) {
+				defer wg.Done()
+				resp, node, err := s.query(candidate.url, candidate.id, rpc, target)
+				results <- dhtResult{candidate: candidate, resp: resp, node: node, err: err}
+			}(candidate)
+		}
+		wg.Wait()
+		close(results)
+
+		stop := false
+		for result := range results {
+			if result.err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", result.candidate.url, result.err))
+				continue
+			}
+			answered += 1
+			known[result.node.Addr] = true
+			for _, contact := range result.resp.Contacts {
+				if known[contact.ID] {
+					continue
+				}
+				known[contact.ID] = true
+				candidates = append(candidates, dhtCandidate{url: contact.URL, id: contact.ID})
+			}
+			stop = visit(result) || stop
+		}
+		if stop {
+			break
+		}
+	}
+	if answered == 0 {
+		return errors.Join(append(errs, errors.New("no dht nodes answered"))...)
+	}
+	return nil
+}
+
+// GetAvailableNodes crawls the DHT and returns every daemon that answered, keyed by address
+func (s *DHTServerUC) GetAvailableNodes() (map[string]entity.Node, error) {
+	nodes := make(map[string]entity.Node)
+	target := make([]byte, DHT_ID_SIZE)
+	_, _ = rand.Read(target)
+	err := s.walk(
+		"find_node",
+		hex.EncodeToString(target),
+		func(candidates []dhtCandidate) []dhtCandidate {
+			return candidates
+		},
+		func(result dhtResult) bool {
+			nodes[result.node.Addr] = *result.node
+			return false
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.fetchedAt = time.Now()
+	return nodes, nil
+}
+
+func (s *DHTServerUC) NodesAge() time.Duration {
+	if s.fetchedAt.IsZero() {
+		return 0
+	}
+	return time.Since(s.fetchedAt)
+}
+
+// LocateChunk looks up daemons that announced they store the chunk.
+// The lookup walks towards the chunk's DHT key and stops at the first daemons that know providers
+func (s *DHTServerUC) LocateChunk(chunkHash string) (map[string]entity.Node, error) {
+	chunkId, err := hex.DecodeString(chunkHash)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(chunkId)
+	key := sum[:DHT_ID_SIZE]
+	providers := make(map[string]entity.Node)
+	err = s.walk(
+		"find_providers",
+		hex.EncodeToString(key),
+		func(candidates []dhtCandidate) []dhtCandidate {
+			// entry daemons without known id go first, then the closest to the key
+			slices.SortStableFunc(candidates, func(a, b dhtCandidate) int {
+				return bytes.Compare(xorDistance(a.id, key), xorDistance(b.id, key))
+			})
+			return candidates
+		},
+		func(result dhtResult) bool {
+			for _, contact := range result.resp.Providers {
+				node, err := s.verifyContact(contact, nil)
+				if err != nil {
+					continue
+				}
+				providers[node.Addr] = *node
+			}
+			return len(providers) > 0
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return providers, nil
+}
+
+// xorDistance returns Kademlia distance between the hex id and the key, unknown ids are the closest
+func xorDistance(id string, key []byte) []byte {
+	idBytes, err := hex.DecodeString(id)
+	if err != nil || len(idBytes) != len(key) {
+		return nil
+	}
+	d := make([]byte, len(key))
+	for i := range key {
+		d[i] = idBytes[i] ^ key[i]
+	}
+	return d
+}
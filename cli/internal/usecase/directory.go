@@ -0,0 +1,122 @@
+package usecase
+
+import (
+	"cli/internal/entity"
+	"maps"
+	"sync"
+	"time"
+)
+
+// DirectoryUC is the node list shared by everything a command does. Nodes are fetched once,
+// later calls return the same view, so cleanup and the command itself agree on the cluster.
+// With events set, the view follows the tracker's join and leave events while the command runs
+type DirectoryUC struct {
+	server Server
+	events NodeEvents
+
+	mu      sync.RWMutex
+	nodes   map[string]entity.Node
+	age     time.Duration
+	loaded  time.Time
+	err     error
+	stop    chan struct{}
+	watched bool
+	live    bool
+}
+
+// NewDirectoryUC wraps server, events may be nil
+func NewDirectoryUC(server Server, events NodeEvents) *DirectoryUC {
+	return &DirectoryUC{server: server, events: events, stop: make(chan struct{})}
+}
+
+func (d *DirectoryUC) load() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.nodes != nil || d.err != nil {
+		return
+	}
+	nodes, err := d.server.GetAvailableNodes()
+	if err != nil {
+		d.err = err
+		return
+	}
+	d.nodes = maps.Clone(nodes)
+	if d.nodes == nil {
+		d.nodes = make(map[string]entity.Node)
+	}
+	d.age = d.server.NodesAge()
+	d.loaded = time.Now()
+	if d.events != nil && !d.watched {
+		d.watched = true
+		go d.watch()
+	}
+}
+
+// watch applies tracker events to the view until Close. If the subscription fails
+// the view just stays as it was fetched
+func (d *DirectoryUC) watch() {
+	events, err := d.events.Subscribe(d.stop)
+	if err != nil {
+		return
+	}
+	defer func() {
+		d.mu.Lock()
+		if d.live {
+			d.live = false
+			d.age = 0
+			d.loaded = time.Now()
+		}
+		d.mu.Unlock()
+	}()
+	for event := range events {
+		d.mu.Lock()
+		switch event.Type {
+		case "snapshot":
+			d.nodes = make(map[string]entity.Node, len(event.Nodes))
+			for addr, node := range event.Nodes {
+				node.Addr = addr
+				d.nodes[addr] = node
+			}
+			d.live = true
+		case "join", "update":
+			if event.Node != nil {
+				node := *event.Node
+				node.Addr = event.Addr
+				d.nodes[event.Addr] = node
+			}
+		case "leave":
+			delete(d.nodes, event.Addr)
+		}
+		d.mu.Unlock()
+	}
+}
+
+// GetAvailableNodes returns a copy of the current view, fetching it on the first call
+func (d *DirectoryUC) GetAvailableNodes() (map[string]entity.Node, error) {
+	d.load()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return maps.Clone(d.nodes), nil
+}
+
+func (d *DirectoryUC) NodesAge() time.Duration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	// a followed view is always current
+	if d.loaded.IsZero() || d.live {
+		return 0
+	}
+	return d.age + time.Since(d.loaded)
+}
+
+// Close stops following tracker events
+func (d *DirectoryUC) Close() {
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+}
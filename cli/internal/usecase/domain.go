@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"cli/internal/entity"
+	"net"
+)
+
+const (
+	DOMAIN_ZONE   = "zone"
+	DOMAIN_SUBNET = "subnet"
+	DOMAIN_HOST   = "host"
+)
+
+// DomainLevels lists failure domain levels from the widest to the narrowest
+var DomainLevels = []string{DOMAIN_ZONE, DOMAIN_SUBNET, DOMAIN_HOST}
+
+// DomainConcentration tells that replicas use fewer domains of the level than they could
+type DomainConcentration struct {
+	Level     string
+	Used      int
+	Available int
+}
+
+// NodeFailureDomains derives failure domains of the node: zone from the announced region and zone,
+// subnet from the announced host address (/24 for IPv4, /48 for IPv6)
+// and host from the announced host id or, without it, from the host address
+func NodeFailureDomains(node entity.Node) entity.FailureDomains {
+	domains := entity.FailureDomains{Host: node.HostID}
+	if node.Region != "" || node.Zone != "" {
+		domains.Zone = node.Region + "/" + node.Zone
+	}
+	if domains.Host == "" {
+		domains.Host = node.Host
+	}
+	ip := net.ParseIP(node.Host)
+	switch {
+	case ip == nil:
+		domains.Subnet = node.Host
+	case ip.To4() != nil:
+		domains.Subnet = ip.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	default:
+		domains.Subnet = ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	}
+	return domains
+}
+
+func domainLabel(domains entity.FailureDomains, level string) string {
+	switch level {
+	case DOMAIN_ZONE:
+		return domains.Zone
+	case DOMAIN_SUBNET:
+		return domains.Subnet
+	default:
+		return domains.Host
+	}
+}
+
+// DomainSpreadPlacement reorders nodes of the inner strategy so that the first nodes
+// cover as many distinct failure domains as possible
+type DomainSpreadPlacement struct {
+	Inner PlacementStrategy
+}
+
+func (p *DomainSpreadPlacement) Place(chunkHash string, nodes map[string]entity.Node) []entity.Node {
+	return SpreadFailureDomains(p.Inner.Place(chunkHash, nodes))
+}
+
+func (p *DomainSpreadPlacement) PlaceAround(chunkHash string, existing []entity.Node, nodes map[string]entity.Node) []entity.Node {
+	return SpreadFailureDomainsAround(existing, PlaceAround(p.Inner, chunkHash, existing, nodes))
+}
+
+// SpreadFailureDomains greedily picks the next node that shares the fewest wide domains with
+// already picked ones (zone first, then subnet, then host), keeping the given order among equals
+func SpreadFailureDomains(ordered []entity.Node) []entity.Node {
+	return SpreadFailureDomainsAround(nil, ordered)
+}
+
+// SpreadFailureDomainsAround works like SpreadFailureDomains, but counts the domains of existing
+// replicas as already used, so new replicas avoid them
+func SpreadFailureDomainsAround(existing []entity.Node, ordered []entity.Node) []entity.Node {
+	remaining := make([]entity.Node, len(ordered))
+	copy(remaining, ordered)
+	used := make(map[string]map[string]int, len(DomainLevels))
+	for _, level := range DomainLevels {
+		used[level] = make(map[string]int)
+	}
+	for _, node := range existing {
+		domains := NodeFailureDomains(node)
+		for _, level := range DomainLevels {
+			if label := domainLabel(domains, level); label != "" {
+				used[level][label] += 1
+			}
+		}
+	}
+	placed := make([]entity.Node, 0, len(ordered))
+	for len(remaining) > 0 {
+		best := 0
+		var bestShares []int
+		for i, node := range remaining {
+			domains := NodeFailureDomains(node)
+			shares := make([]int, len(DomainLevels))
+			for j, level := range DomainLevels {
+				if label := domainLabel(domains, level); label != "" {
+					shares[j] = used[level][label]
+				}
+			}
+			if bestShares == nil || lessShares(shares, bestShares) {
+				best, bestShares = i, shares
+			}
+		}
+		node := remaining[best]
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		domains := NodeFailureDomains(node)
+		for _, level := range DomainLevels {
+			if label := domainLabel(domains, level); label != "" {
+				used[level][label] += 1
+			}
+		}
+		placed = append(placed, node)
+	}
+	return placed
+}
+
+func lessShares(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// ConcentratedDomains returns the levels on which replicas occupy fewer distinct domains
+// than the available nodes would allow. Levels where no node announced a label are skipped
+func ConcentratedDomains(replicas []entity.Node, available map[string]entity.Node) []DomainConcentration {
+	concentrations := make([]DomainConcentration, 0)
+	for _, level := range DomainLevels {
+		availableLabels := make(map[string]bool)
+		for _, node := range available {
+			if label := domainLabel(NodeFailureDomains(node), level); label != "" {
+				availableLabels[label] = true
+			}
+		}
+		if len(availableLabels) == 0 {
+			continue
+		}
+		usedLabels := make(map[string]bool)
+		for _, node := range replicas {
+			if label := domainLabel(NodeFailureDomains(node), level); label != "" {
+				usedLabels[label] = true
+			}
+		}
+		if len(usedLabels) < min(len(replicas), len(availableLabels)) {
+			concentrations = append(concentrations, DomainConcentration{
+				Level:     level,
+				Used:      len(usedLabels),
+				Available: len(availableLabels),
+			})
+		}
+	}
+	return concentrations
+}
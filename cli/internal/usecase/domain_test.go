@@ -0,0 +1,31 @@
+package usecase
+
+import (
+	"cli/internal/entity"
+	"testing"
+)
+
+func TestPlaceAroundAvoidsExistingDomains(t *testing.T) {
+	nodes := map[string]entity.Node{
+		"a": {Addr: "a", Endpoint: entity.Endpoint{Host: "10.0.1.1"}, Zone: "z1", HostID: "a"},
+		"b": {Addr: "b", Endpoint: entity.Endpoint{Host: "10.0.2.1"}, Zone: "z2", HostID: "b"},
+		"c": {Addr: "c", Endpoint: entity.Endpoint{Host: "10.0.3.1"}, Zone: "z3", HostID: "c"},
+	}
+	existing := []entity.Node{
+		{Addr: "x", Endpoint: entity.Endpoint{Host: "10.0.4.1"}, Zone: "z1", HostID: "x"},
+		{Addr: "y", Endpoint: entity.Endpoint{Host: "10.0.5.1"}, Zone: "z2", HostID: "y"},
+	}
+	placement := &StatsPlacement{
+		Inner: &DomainSpreadPlacement{Inner: &RendezvousPlacement{}},
+		Stats: NewStatsUC(""),
+	}
+	for _, hash := range []string{"chunk1", "chunk2", "chunk3"} {
+		placed := PlaceAround(placement, hash, existing, nodes)
+		if len(placed) != len(nodes) {
+			t.Fatalf("placed %d nodes, want %d", len(placed), len(nodes))
+		}
+		if placed[0].Addr != "c" {
+			t.Errorf("%s: first node %s, want c from the only zone without replicas", hash, placed[0].Addr)
+		}
+	}
+}
@@ -3,25 +3,55 @@ package usecase
 import (
 	"cli/internal/entity"
 	"encoding/json"
+	"errors"
 	"fmt"
 	u "github.com/google/uuid"
 	"os"
+	"path/filepath"
 )
 
+// DEFAULT_INDEX_BACKUPS is how many previous versions of files.json are kept when not configured
+const DEFAULT_INDEX_BACKUPS = 5
+
+// StorageUC keeps the file index in files.json. Changes are made under an advisory lock on
+// files.json.lock and written through a temp file, previous versions are kept as files.json.1 (newest)
+// to files.json.N
 type StorageUC struct {
-	fileInfoPath string
+	fileInfoPath    string
+	indexBackupPath string
+	lockPath        string
+	backups         int
 }
 
-func NewStorageUC(fileInfoPath string) *StorageUC {
-	return &StorageUC{fileInfoPath: fileInfoPath}
+// NewStorageUC keeps the description of the last index backup next to the index as index_backup.json
+func NewStorageUC(fileInfoPath string, backups int) *StorageUC {
+	return &StorageUC{
+		fileInfoPath:    fileInfoPath,
+		indexBackupPath: filepath.Join(filepath.Dir(fileInfoPath), "index_backup.json"),
+		lockPath:        fileInfoPath + ".lock",
+		backups:         backups,
+	}
 }
 
-func (s *StorageUC) GetFileInfos() (map[u.UUID]entity.FileInfo, error) {
+// lock waits for the lock on the index, the returned function releases it
+func (s *StorageUC) lock(exclusive bool) (func(), error) {
+	file, err := os.OpenFile(s.lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file, exclusive); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", s.lockPath, err)
+	}
+	return func() { _ = file.Close() }, nil
+}
+
+func (s *StorageUC) readFileInfos() (map[u.UUID]entity.FileInfo, error) {
 	file, err := os.Open(s.fileInfoPath)
-	defer file.Close()
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 	fileInfos := make(map[u.UUID]entity.FileInfo)
 	if err := json.NewDecoder(file).Decode(&fileInfos); err != nil {
 		return nil, err
@@ -29,13 +59,87 @@ func (s *StorageUC) GetFileInfos() (map[u.UUID]entity.FileInfo, error) {
 	return fileInfos, nil
 }
 
-func (s *StorageUC) WriteFileInfos(fileInfos map[u.UUID]entity.FileInfo) error {
-	file, err := os.Create(s.fileInfoPath)
-	defer file.Close()
+func (s *StorageUC) writeFileInfos(fileInfos map[u.UUID]entity.FileInfo) error {
+	if err := s.rotateBackups(); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.fileInfoPath, &fileInfos)
+}
+
+// rotateBackups shifts files.json.1..N-1 by one and copies the current index to files.json.1
+func (s *StorageUC) rotateBackups() error {
+	if s.backups <= 0 {
+		return nil
+	}
+	contents, err := os.ReadFile(s.fileInfoPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := s.backups - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.fileInfoPath, i), fmt.Sprintf("%s.%d", s.fileInfoPath, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.WriteFile(s.fileInfoPath+".1", contents, 0600)
+}
+
+// writeFileAtomic encodes value into a temp file in the same directory and renames it over filePath,
+// so readers see either the old or the new contents and never a truncated file
+func writeFileAtomic(filePath string, value any) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(value); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// modify runs read-modify-write of the index under the exclusive lock, a missing index is empty
+func (s *StorageUC) modify(fn func(fileInfos map[u.UUID]entity.FileInfo) error) error {
+	unlock, err := s.lock(true)
 	if err != nil {
 		return err
 	}
-	return json.NewEncoder(file).Encode(&fileInfos)
+	defer unlock()
+	fileInfos, err := s.readFileInfos()
+	if errors.Is(err, os.ErrNotExist) {
+		fileInfos = make(map[u.UUID]entity.FileInfo)
+	} else if err != nil {
+		return err
+	}
+	if err := fn(fileInfos); err != nil {
+		return err
+	}
+	return s.writeFileInfos(fileInfos)
+}
+
+func (s *StorageUC) GetFileInfos() (map[u.UUID]entity.FileInfo, error) {
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.readFileInfos()
+}
+
+// Modify runs read-modify-write of the whole index under the exclusive lock
+func (s *StorageUC) Modify(fn func(fileInfos map[u.UUID]entity.FileInfo) error) error {
+	return s.modify(fn)
 }
 
 func (s *StorageUC) GetFileInfo(uuid u.UUID) (*entity.FileInfo, error) {
@@ -51,42 +155,61 @@ func (s *StorageUC) GetFileInfo(uuid u.UUID) (*entity.FileInfo, error) {
 }
 
 func (s *StorageUC) AppendFileInfo(fileInfo entity.FileInfo) (*u.UUID, error) {
-	fileInfos, err := s.GetFileInfos()
+	uuid := u.New()
+	err := s.modify(func(fileInfos map[u.UUID]entity.FileInfo) error {
+		fileInfos[uuid] = fileInfo
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	uuid := u.New()
-	fileInfos[uuid] = fileInfo
-	return &uuid, s.WriteFileInfos(fileInfos)
+	return &uuid, nil
 }
 
 func (s *StorageUC) UpdateFileInfo(uuid u.UUID, fileInfo entity.FileInfo) error {
-	fileInfos, err := s.GetFileInfos()
-	if err != nil {
-		return err
-	}
-	_, exists := fileInfos[uuid]
-	if !exists {
-		return fmt.Errorf("file %s not found", uuid)
-	}
-	fileInfos[uuid] = fileInfo
-	return s.WriteFileInfos(fileInfos)
-
+	return s.modify(func(fileInfos map[u.UUID]entity.FileInfo) error {
+		_, exists := fileInfos[uuid]
+		if !exists {
+			return fmt.Errorf("file %s not found", uuid)
+		}
+		fileInfos[uuid] = fileInfo
+		return nil
+	})
 }
 
 func (s *StorageUC) DeleteFileInfo(uuid u.UUID) error {
-	fileInfos, err := s.GetFileInfos()
+	return s.modify(func(fileInfos map[u.UUID]entity.FileInfo) error {
+		_, exists := fileInfos[uuid]
+		if !exists {
+			return fmt.Errorf("file %s not found", uuid)
+		}
+		delete(fileInfos, uuid)
+		return nil
+	})
+}
+
+// GetIndexBackup returns the last index backup made from this machine, nil if there was none
+func (s *StorageUC) GetIndexBackup() (*entity.IndexBackup, error) {
+	file, err := os.Open(s.indexBackupPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, exists := fileInfos[uuid]
-	if !exists {
-		return fmt.Errorf("file %s not found", uuid)
+	defer file.Close()
+	backup := &entity.IndexBackup{}
+	if err := json.NewDecoder(file).Decode(backup); err != nil {
+		return nil, err
 	}
-	delete(fileInfos, uuid)
-	err = s.WriteFileInfos(fileInfos)
+	return backup, nil
+}
+
+func (s *StorageUC) SetIndexBackup(backup entity.IndexBackup) error {
+	unlock, err := s.lock(true)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer unlock()
+	return writeFileAtomic(s.indexBackupPath, &backup)
 }
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package usecase
+
+import (
+	"os"
+)
+
+// lockFile does nothing on this platform, parallel commands may overwrite each other's changes
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
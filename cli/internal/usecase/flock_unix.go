@@ -0,0 +1,23 @@
+//go:build unix
+
+package usecase
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory lock on the file, shared for readers and exclusive for writers.
+// The lock is released when the file is closed
+func lockFile(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package usecase
+
+import (
+	"bytes"
+	"cli/internal/entity"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// KEY_BUNDLE_VERSION is the first byte of an exported key bundle
+	KEY_BUNDLE_VERSION = 1
+
+	// scrypt parameters of bundle version 1
+	bundleScryptN   = 1 << 15
+	bundleScryptR   = 8
+	bundleScryptP   = 1
+	bundleSaltSize  = 15
+	bundleNonceSize = 12
+
+	ecdsaScalarSize = 32
+)
+
+// KeysUC reads and writes keys.json and converts keys to and from passphrase-encrypted bundles.
+// A bundle is [1 byte version][15 bytes salt][12 bytes nonce][AES-256-GCM of aes key and ecdsa scalar],
+// 92 bytes in total, so it fits into a mnemonic of 69 words. keys.json itself may hold such a bundle,
+// then the passphrase is asked once per command. The agent never gives out keys, KeysUC only stops it
+// when the keys change
+type KeysUC struct {
+	keysFilePath  string
+	agent         KeyAgent
+	askPassphrase func() ([]byte, error)
+
+	mu     sync.Mutex
+	cached *entity.Keys
+}
+
+func NewKeysUC(keysFilePath string, agent KeyAgent, askPassphrase func() ([]byte, error)) *KeysUC {
+	return &KeysUC{keysFilePath: keysFilePath, agent: agent, askPassphrase: askPassphrase}
+}
+
+func (k *KeysUC) Path() string {
+	return k.keysFilePath
+}
+
+func (k *KeysUC) Exists() bool {
+	_, err := os.Stat(k.keysFilePath)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+func (k *KeysUC) readFile() (*entity.Keys, error) {
+	f, err := os.Open(k.keysFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys := &entity.Keys{}
+	if err := json.NewDecoder(f).Decode(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// IsEncrypted reports whether keys.json is protected with a passphrase
+func (k *KeysUC) IsEncrypted() (bool, error) {
+	keys, err := k.readFile()
+	if err != nil {
+		return false, err
+	}
+	return keys.Encrypted != "", nil
+}
+
+// Read returns the decrypted keys from keys.json
+func (k *KeysUC) Read() (*entity.Keys, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.cached != nil {
+		keys := *k.cached
+		return &keys, nil
+	}
+	keys, err := k.read()
+	if err != nil {
+		return nil, err
+	}
+	k.cached = keys
+	cached := *keys
+	return &cached, nil
+}
+
+func (k *KeysUC) read() (*entity.Keys, error) {
+	keys, err := k.readFile()
+	if err != nil {
+		return nil, err
+	}
+	if keys.Encrypted == "" {
+		return keys, nil
+	}
+	if k.askPassphrase == nil {
+		return nil, errors.New("keys are encrypted and no passphrase can be asked")
+	}
+	passphrase, err := k.askPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := k.decrypt(keys.Encrypted, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if keys.Previous != nil {
+		if decrypted.Previous, err = k.decrypt(keys.Previous.Encrypted, passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return decrypted, nil
+}
+
+func (k *KeysUC) decrypt(encrypted string, passphrase []byte) (*entity.Keys, error) {
+	bundle, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return k.Import(bundle, passphrase)
+}
+
+func (k *KeysUC) encrypt(keys entity.Keys, passphrase []byte) (string, error) {
+	bundle, err := k.Export(keys, passphrase)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(bundle), nil
+}
+
+// Write replaces keys.json, the file is readable only by the owner.
+// An agent holding other keys is stopped so it doesn't serve them anymore
+func (k *KeysUC) Write(keys entity.Keys) error {
+	if err := k.write(keys, keys); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.cached = &keys
+	k.mu.Unlock()
+	return nil
+}
+
+// WriteEncrypted replaces keys.json with the keys encrypted with the passphrase
+func (k *KeysUC) WriteEncrypted(keys entity.Keys, passphrase []byte) error {
+	encrypted, err := k.encrypt(keys, passphrase)
+	if err != nil {
+		return err
+	}
+	contents := entity.Keys{Encrypted: encrypted}
+	if keys.Previous != nil {
+		previous, err := k.encrypt(*keys.Previous, passphrase)
+		if err != nil {
+			return err
+		}
+		contents.Previous = &entity.Keys{Encrypted: previous}
+	}
+	if err := k.write(contents, keys); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.cached = &keys
+	k.mu.Unlock()
+	return nil
+}
+
+// write stores contents to keys.json and stops the agent if it holds other keys than keys
+func (k *KeysUC) write(contents entity.Keys, keys entity.Keys) error {
+	if err := os.MkdirAll(filepath.Dir(k.keysFilePath), 0700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(k.keysFilePath, &contents); err != nil {
+		return err
+	}
+	if k.agent == nil || !k.agent.Available() {
+		return nil
+	}
+	ecdsaKey, err := parseECDSAKey(keys.EcdsaKey)
+	if err != nil {
+		return err
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&ecdsaKey.PublicKey)
+	if err != nil {
+		return err
+	}
+	if agentPubKey, err := k.agent.Public(false); err == nil && bytes.Equal(agentPubKey, pubKeyBytes) {
+		return nil
+	}
+	return k.agent.Stop()
+}
+
+// Export encrypts the keys with a key derived from the passphrase by scrypt
+func (k *KeysUC) Export(keys entity.Keys, passphrase []byte) ([]byte, error) {
+	plaintext, err := packKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	bundle := make([]byte, 1+bundleSaltSize+bundleNonceSize, 1+bundleSaltSize+bundleNonceSize+len(plaintext)+16)
+	bundle[0] = KEY_BUNDLE_VERSION
+	if _, err := rand.Read(bundle[1:]); err != nil {
+		return nil, err
+	}
+	salt := bundle[1 : 1+bundleSaltSize]
+	nonce := bundle[1+bundleSaltSize:]
+	gcm, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(bundle, nonce, plaintext, bundle[:1]), nil
+}
+
+// Import decrypts a bundle made by Export
+func (k *KeysUC) Import(bundle []byte, passphrase []byte) (*entity.Keys, error) {
+	if len(bundle) < 1+bundleSaltSize+bundleNonceSize {
+		return nil, errors.New("key bundle too short")
+	}
+	if bundle[0] != KEY_BUNDLE_VERSION {
+		return nil, fmt.Errorf("unsupported key bundle version %d", bundle[0])
+	}
+	salt := bundle[1 : 1+bundleSaltSize]
+	nonce := bundle[1+bundleSaltSize : 1+bundleSaltSize+bundleNonceSize]
+	gcm, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, bundle[1+bundleSaltSize+bundleNonceSize:], bundle[:1])
+	if err != nil {
+		return nil, errors.New("wrong passphrase or damaged key bundle")
+	}
+	return unpackKeys(plaintext)
+}
+
+func bundleCipher(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, bundleScryptN, bundleScryptR, bundleScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// packKeys returns the aes key followed by the 32-byte ecdsa private scalar
+func packKeys(keys entity.Keys) ([]byte, error) {
+	aesKey, err := hex.DecodeString(keys.AesKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(aesKey) != aes.BlockSize {
+		return nil, fmt.Errorf("unexpected aes key size %d", len(aesKey))
+	}
+	ecdsaKey, err := parseECDSAKey(keys.EcdsaKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(aesKey, ecdsaKey.D.FillBytes(make([]byte, ecdsaScalarSize))...), nil
+}
+
+func unpackKeys(packed []byte) (*entity.Keys, error) {
+	if len(packed) != aes.BlockSize+ecdsaScalarSize {
+		return nil, fmt.Errorf("unexpected key bundle size %d", len(packed))
+	}
+	ecdsaKey, err := ECDSAKeyFromScalar(packed[aes.BlockSize:])
+	if err != nil {
+		return nil, err
+	}
+	return NewKeys(packed[:aes.BlockSize], ecdsaKey)
+}
+
+// NewKeys encodes the keys the way they are stored in keys.json
+func NewKeys(aesKey []byte, ecdsaKey *ecdsa.PrivateKey) (*entity.Keys, error) {
+	ecdsaKeyBytes, err := x509.MarshalECPrivateKey(ecdsaKey)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.Keys{
+		AesKey:   hex.EncodeToString(aesKey),
+		EcdsaKey: hex.EncodeToString(ecdsaKeyBytes),
+	}, nil
+}
+
+// ECDSAKeyFromScalar restores a P-256 private key from its 32-byte scalar
+func ECDSAKeyFromScalar(d []byte) (*ecdsa.PrivateKey, error) {
+	ecdhKey, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, err
+	}
+	// uncompressed point: 0x04 || X || Y
+	point := ecdhKey.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(point[1 : 1+ecdsaScalarSize]),
+			Y:     new(big.Int).SetBytes(point[1+ecdsaScalarSize:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}, nil
+}
+
+// parseECDSAKey decodes the ecdsa key as it's stored in keys.json
+func parseECDSAKey(ecdsaKey string) (*ecdsa.PrivateKey, error) {
+	ecdsaKeyBytes, err := hex.DecodeString(ecdsaKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseECPrivateKey(ecdsaKeyBytes)
+}
@@ -0,0 +1,154 @@
+package usecase
+
+import (
+	"cli/internal/entity"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// LAN_QUERY asks every daemon in the multicast group to answer with its announcement
+	LAN_QUERY = "distorage-query"
+	// LAN_WAIT is how long answers to the query are collected
+	LAN_WAIT      = 2 * time.Second
+	lanMaxPacket  = 8192
+	lanQueryCount = 3
+)
+
+// LANServerUC finds daemons on the local network: it sends a query to the multicast group
+// and collects the signed announcements daemons send back
+type LANServerUC struct {
+	group  string
+	wait   time.Duration
+	crypto Crypto
+
+	fetchedAt time.Time
+}
+
+// NewLANServerUC accepts the multicast group as host:port, the same as lan_group of daemons
+func NewLANServerUC(group string, crypto Crypto) *LANServerUC {
+	return &LANServerUC{group: group, wait: LAN_WAIT, crypto: crypto}
+}
+
+// GetAvailableNodes returns verified daemons that answered within LAN_WAIT, keyed by address.
+// A daemon that doesn't announce its host is reachable at the address it answered from
+func (s *LANServerUC) GetAvailableNodes() (map[string]entity.Node, error) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", s.group)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(s.wait)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// udp may lose the query, so it is repeated during the wait
+	go func() {
+		for i := 0; i < lanQueryCount && time.Now().Before(deadline); i++ {
+			if _, err := conn.WriteToUDP([]byte(LAN_QUERY), groupAddr); err != nil {
+				return
+			}
+			time.Sleep(s.wait / (2 * lanQueryCount))
+		}
+	}()
+
+	nodes := make(map[string]entity.Node)
+	buf := make([]byte, lanMaxPacket)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || isTimeout(err) {
+				break
+			}
+			return nil, err
+		}
+		signed := entity.SignedAnnouncement{}
+		if err := json.Unmarshal(buf[:n], &signed); err != nil {
+			continue
+		}
+		node, err := s.crypto.VerifyAnnouncement(signed)
+		if err != nil {
+			continue
+		}
+		if node.Host == "" {
+			node.Host = from.IP.String()
+		}
+		if known, exists := nodes[node.Addr]; !exists || node.Timestamp > known.Timestamp {
+			nodes[node.Addr] = *node
+		}
+	}
+	s.fetchedAt = time.Now()
+	return nodes, nil
+}
+
+func (s *LANServerUC) NodesAge() time.Duration {
+	if s.fetchedAt.IsZero() {
+		return 0
+	}
+	return time.Since(s.fetchedAt)
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// MergedServerUC asks several discovery sources at once and merges their node lists,
+// a node known to several sources is taken from the most recent announcement
+type MergedServerUC struct {
+	servers []Server
+	age     time.Duration
+}
+
+func NewMergedServerUC(servers ...Server) *MergedServerUC {
+	return &MergedServerUC{servers: servers}
+}
+
+func (s *MergedServerUC) GetAvailableNodes() (map[string]entity.Node, error) {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	merged := make(map[string]entity.Node)
+	answered := 0
+	s.age = 0
+	for i, server := range s.servers {
+		wg.Add(1)
+		go func(i int, server Server) {
+			defer wg.Done()
+			nodes, err := server.GetAvailableNodes()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("discovery #%d: %w", i, err))
+				return
+			}
+			answered += 1
+			// the list is as old as its oldest part
+			s.age = max(s.age, server.NodesAge())
+			for addr, node := range nodes {
+				if known, exists := merged[addr]; !exists || node.Timestamp > known.Timestamp {
+					merged[addr] = node
+				}
+			}
+		}(i, server)
+	}
+	wg.Wait()
+	if answered == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return merged, nil
+}
+
+func (s *MergedServerUC) NodesAge() time.Duration {
+	return s.age
+}
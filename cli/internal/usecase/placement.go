@@ -0,0 +1,140 @@
+package usecase
+
+import (
+	"bytes"
+	"cli/internal/entity"
+	"crypto/sha256"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+const (
+	PLACEMENT_RANDOM     = "random"
+	PLACEMENT_CAPACITY   = "capacity"
+	PLACEMENT_RENDEZVOUS = "rendezvous"
+	PLACEMENT_ZONE       = "zone"
+)
+
+// NewPlacementStrategy returns the strategy with the given name, unknown names fall back to random
+func NewPlacementStrategy(name string) PlacementStrategy {
+	switch name {
+	case PLACEMENT_CAPACITY:
+		return &CapacityPlacement{}
+	case PLACEMENT_RENDEZVOUS:
+		return &RendezvousPlacement{}
+	case PLACEMENT_ZONE:
+		return &ZonePlacement{}
+	default:
+		return &RandomPlacement{}
+	}
+}
+
+// PlaceAround orders nodes for new replicas of a chunk that already has existing ones,
+// strategies that don't implement ReplicaPlacement ignore them
+func PlaceAround(p PlacementStrategy, chunkHash string, existing []entity.Node, nodes map[string]entity.Node) []entity.Node {
+	if around, ok := p.(ReplicaPlacement); ok {
+		return around.PlaceAround(chunkHash, existing, nodes)
+	}
+	return p.Place(chunkHash, nodes)
+}
+
+// RandomPlacement orders nodes uniformly at random
+type RandomPlacement struct{}
+
+func (p *RandomPlacement) Place(_ string, nodes map[string]entity.Node) []entity.Node {
+	placed := nodeList(nodes)
+	rand.Shuffle(len(placed), func(i, j int) {
+		placed[i], placed[j] = placed[j], placed[i]
+	})
+	return placed
+}
+
+// CapacityPlacement orders nodes randomly with probability proportional to their free capacity,
+// nodes without free space go last
+type CapacityPlacement struct{}
+
+func (p *CapacityPlacement) Place(_ string, nodes map[string]entity.Node) []entity.Node {
+	placed := nodeList(nodes)
+	// weighted sampling without replacement: sort by -ln(u)/weight
+	keys := make(map[string]float64, len(placed))
+	for _, node := range placed {
+		if node.FreeCapacity == 0 {
+			keys[node.Addr] = math.Inf(1)
+			continue
+		}
+		keys[node.Addr] = -math.Log(1-rand.Float64()) / float64(node.FreeCapacity)
+	}
+	slices.SortStableFunc(placed, func(a, b entity.Node) int {
+		switch {
+		case keys[a.Addr] < keys[b.Addr]:
+			return -1
+		case keys[a.Addr] > keys[b.Addr]:
+			return 1
+		}
+		return 0
+	})
+	return placed
+}
+
+// RendezvousPlacement orders nodes by highest random weight sha256(chunk hash + node address),
+// so the same chunk is always placed on the same nodes while they are available
+type RendezvousPlacement struct{}
+
+func (p *RendezvousPlacement) Place(chunkHash string, nodes map[string]entity.Node) []entity.Node {
+	placed := nodeList(nodes)
+	sortByRendezvous(chunkHash, placed)
+	return placed
+}
+
+// ZonePlacement spreads replicas over as many zones as possible: it takes one node from every zone
+// in turn, nodes inside a zone and zones themselves are ordered by rendezvous hashing
+type ZonePlacement struct{}
+
+func (p *ZonePlacement) Place(chunkHash string, nodes map[string]entity.Node) []entity.Node {
+	ordered := nodeList(nodes)
+	sortByRendezvous(chunkHash, ordered)
+	// zones keep the order of their best node
+	zones := make([]string, 0)
+	byZone := make(map[string][]entity.Node)
+	for _, node := range ordered {
+		zone := node.Region + "/" + node.Zone
+		if _, exists := byZone[zone]; !exists {
+			zones = append(zones, zone)
+		}
+		byZone[zone] = append(byZone[zone], node)
+	}
+	placed := make([]entity.Node, 0, len(ordered))
+	for round := 0; len(placed) < len(ordered); round++ {
+		for _, zone := range zones {
+			if round < len(byZone[zone]) {
+				placed = append(placed, byZone[zone][round])
+			}
+		}
+	}
+	return placed
+}
+
+// nodeList returns nodes sorted by address, so that strategies don't depend on map order
+func nodeList(nodes map[string]entity.Node) []entity.Node {
+	list := make([]entity.Node, 0, len(nodes))
+	for addr, node := range nodes {
+		node.Addr = addr
+		list = append(list, node)
+	}
+	slices.SortFunc(list, func(a, b entity.Node) int {
+		return bytes.Compare([]byte(a.Addr), []byte(b.Addr))
+	})
+	return list
+}
+
+func sortByRendezvous(chunkHash string, nodes []entity.Node) {
+	scores := make(map[string][]byte, len(nodes))
+	for _, node := range nodes {
+		score := sha256.Sum256([]byte(chunkHash + node.Addr))
+		scores[node.Addr] = score[:]
+	}
+	slices.SortStableFunc(nodes, func(a, b entity.Node) int {
+		return bytes.Compare(scores[b.Addr], scores[a.Addr])
+	})
+}
@@ -0,0 +1,69 @@
+package usecase
+
+import (
+	"cli/internal/entity"
+	"crypto/aes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"fmt"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"math/big"
+)
+
+const (
+	// SEED_SIZE is the size of the seed all keys are derived from, 256 bits
+	SEED_SIZE = 32
+
+	// SEED_SALT is the HKDF salt, the seed is the input key material
+	SEED_SALT = "distorage-seed-v1"
+
+	// key purposes, used as HKDF info after "distorage/"
+	PURPOSE_ENCRYPTION = "encryption"
+	PURPOSE_SIGNING    = "signing"
+)
+
+// DeriveKey returns size bytes of HKDF-SHA256 output for the purpose,
+// different purposes give independent keys
+func DeriveKey(seed []byte, purpose string, size int) ([]byte, error) {
+	if len(seed) != SEED_SIZE {
+		return nil, fmt.Errorf("seed must be %d bytes, got %d", SEED_SIZE, len(seed))
+	}
+	key := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, seed, []byte(SEED_SALT), []byte("distorage/"+purpose)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// deriveECDSAScalar maps 64 extra bits of HKDF output to [1, n-1] like FIPS 186-5 A.2.1,
+// so the result is deterministic and unbiased without retries
+func deriveECDSAScalar(seed []byte, purpose string) ([]byte, error) {
+	params := elliptic.P256().Params()
+	c, err := DeriveKey(seed, purpose, (params.BitSize+64)/8)
+	if err != nil {
+		return nil, err
+	}
+	nMinusOne := new(big.Int).Sub(params.N, big.NewInt(1))
+	d := new(big.Int).SetBytes(c)
+	d.Mod(d, nMinusOne)
+	d.Add(d, big.NewInt(1))
+	return d.FillBytes(make([]byte, ecdsaScalarSize)), nil
+}
+
+// KeysFromSeed derives the encryption and signing keys from the seed
+func KeysFromSeed(seed []byte) (*entity.Keys, error) {
+	aesKey, err := DeriveKey(seed, PURPOSE_ENCRYPTION, aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	scalar, err := deriveECDSAScalar(seed, PURPOSE_SIGNING)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, err := ECDSAKeyFromScalar(scalar)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeys(aesKey, ecdsaKey)
+}
@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// seedVectors are known answers for the derivation from a fixed seed, a change of the derivation
+// would make existing mnemonics restore other keys
+var seedVectors = []struct {
+	seed        string
+	aesKey      string
+	ecdsaScalar string
+}{
+	{
+		seed:        "0000000000000000000000000000000000000000000000000000000000000000",
+		aesKey:      "592e137e0f18938ba1c96f183222eb0f",
+		ecdsaScalar: "92924b8a30cb847835879a55999cc7cb95e364271b5a08ba706305da54fdb445",
+	},
+	{
+		seed:        "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		aesKey:      "d28cb404cd2cd9a7505e9963bd9ea0dc",
+		ecdsaScalar: "12f659676fb4843fe986bdcb649963938c8c0b8cfa887151aef55331176c6f11",
+	},
+}
+
+func TestKeysFromSeed(t *testing.T) {
+	for i, vector := range seedVectors {
+		seed, err := hex.DecodeString(vector.seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := KeysFromSeed(seed)
+		if err != nil {
+			t.Fatalf("vector #%d: %v", i, err)
+		}
+		if keys.AesKey != vector.aesKey {
+			t.Errorf("vector #%d: aes key %s, expected %s", i, keys.AesKey, vector.aesKey)
+		}
+		ecdsaKey, err := parseECDSAKey(keys.EcdsaKey)
+		if err != nil {
+			t.Fatalf("vector #%d: %v", i, err)
+		}
+		scalar := hex.EncodeToString(ecdsaKey.D.FillBytes(make([]byte, ecdsaScalarSize)))
+		if scalar != vector.ecdsaScalar {
+			t.Errorf("vector #%d: ecdsa scalar %s, expected %s", i, scalar, vector.ecdsaScalar)
+		}
+	}
+}
+
+func TestDeriveKeyPurposes(t *testing.T) {
+	seed := make([]byte, SEED_SIZE)
+	encryption, err := DeriveKey(seed, PURPOSE_ENCRYPTION, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing, err := DeriveKey(seed, PURPOSE_SIGNING, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(encryption, signing) {
+		t.Error("keys of different purposes are equal")
+	}
+}
+
+func TestDeriveKeySeedSize(t *testing.T) {
+	if _, err := DeriveKey(make([]byte, SEED_SIZE-1), PURPOSE_ENCRYPTION, 16); err == nil {
+		t.Error("short seed accepted")
+	}
+}
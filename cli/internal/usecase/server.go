@@ -1,37 +1,286 @@
 package usecase
 
 import (
+	"cli/internal/entity"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TRACKER_FAILOVER = "failover"
+	TRACKER_MERGE    = "merge"
+	// TRACKER_TIMEOUT limits a whole request to a tracker
+	TRACKER_TIMEOUT = 10 * time.Second
 )
 
 type ServerUC struct {
-	serverURL string
+	serverURLs []string
+	mode       string
+	cachePath  string
+	ttl        time.Duration
+	client     *http.Client
+
+	fetchedAt time.Time
 }
 
-func NewServerUC(serverURL string) *ServerUC {
-	u := url.URL{Scheme: "http", Host: serverURL}
-	serverURL, _ = url.PathUnescape(u.String())
+// NewServerUC accepts tracker urls either without scheme (http is used) or with http:// or https://.
+// In failover mode trackers are queried in order until one answers, in merge mode all of them are queried
+// and their node lists are merged. The last good list is kept in cachePath and used without asking
+// trackers while it's younger than ttl
+func NewServerUC(serverURLs []string, mode string, cachePath string, ttl time.Duration) *ServerUC {
+	urls := make([]string, 0, len(serverURLs))
+	for _, serverURL := range serverURLs {
+		if !strings.Contains(serverURL, "://") {
+			u := url.URL{Scheme: "http", Host: serverURL}
+			serverURL, _ = url.PathUnescape(u.String())
+		}
+		urls = append(urls, serverURL)
+	}
 	return &ServerUC{
-		serverURL: serverURL,
+		serverURLs: urls,
+		mode:       mode,
+		cachePath:  cachePath,
+		ttl:        ttl,
+		client:     &http.Client{Timeout: TRACKER_TIMEOUT},
 	}
 }
 
-func (s *ServerUC) GetAvailableNodes() (map[string]string, error) {
-	availableNodes := make(map[string]string)
-	resp, err := http.Get(s.serverURL)
+// nodeCache is the last node list received from trackers.
+// ETag is the version of the list reported by the Source tracker, empty in merge mode
+type nodeCache struct {
+	FetchedAt time.Time              `json:"fetched_at"`
+	Source    string                 `json:"source,omitempty"`
+	ETag      string                 `json:"etag,omitempty"`
+	Nodes     map[string]entity.Node `json:"nodes"`
+}
+
+// fetchNodes asks the tracker for nodes. If etag is the current version of the list,
+// the tracker answers 304 and nil nodes are returned
+func (s *ServerUC) fetchNodes(serverURL string, etag string) (map[string]entity.Node, string, error) {
+	availableNodes := make(map[string]entity.Node)
+	req, err := http.NewRequest(http.MethodGet, serverURL, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return nil, etag, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+		return nil, "", fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
 	}
 	err = json.NewDecoder(resp.Body).Decode(&availableNodes)
 	if err != nil {
+		return nil, "", err
+	}
+	for addr, node := range availableNodes {
+		node.Addr = addr
+		availableNodes[addr] = node
+	}
+	return availableNodes, resp.Header.Get("ETag"), nil
+}
+
+func (s *ServerUC) failover(cache *nodeCache) (*nodeCache, error) {
+	errs := make([]error, 0, len(s.serverURLs))
+	for _, serverURL := range s.serverURLs {
+		etag := ""
+		if cache != nil && cache.Source == serverURL {
+			etag = cache.ETag
+		}
+		nodes, etag, err := s.fetchNodes(serverURL, etag)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", serverURL, err))
+			continue
+		}
+		if nodes == nil {
+			// not modified since the cached list
+			nodes = cache.Nodes
+		}
+		return &nodeCache{FetchedAt: time.Now(), Source: serverURL, ETag: etag, Nodes: nodes}, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// merge queries all trackers at once, a node known to several trackers
+// is taken from the most recent announcement
+func (s *ServerUC) merge() (map[string]entity.Node, error) {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	merged := make(map[string]entity.Node)
+	answered := 0
+	for _, serverURL := range s.serverURLs {
+		wg.Add(1)
+		go func(serverURL string) {
+			defer wg.Done()
+			nodes, _, err := s.fetchNodes(serverURL, "")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", serverURL, err))
+				return
+			}
+			answered += 1
+			for addr, node := range nodes {
+				if known, exists := merged[addr]; !exists || node.Timestamp > known.Timestamp {
+					merged[addr] = node
+				}
+			}
+		}(serverURL)
+	}
+	wg.Wait()
+	if answered == 0 {
+		return nil, errors.Join(errs...)
+	}
+	return merged, nil
+}
+
+// GetAvailableNodes returns the nodes currently connected to trackers, keyed by address.
+// A cached list younger than ttl is returned as is. If no tracker answers,
+// the last good list is returned, see NodesAge
+func (s *ServerUC) GetAvailableNodes() (map[string]entity.Node, error) {
+	cache, cacheErr := s.readCache()
+	if cacheErr == nil && time.Since(cache.FetchedAt) < s.ttl {
+		s.fetchedAt = cache.FetchedAt
+		return cache.Nodes, nil
+	}
+	var (
+		fresh *nodeCache
+		err   error
+	)
+	if s.mode == TRACKER_MERGE {
+		var nodes map[string]entity.Node
+		nodes, err = s.merge()
+		fresh = &nodeCache{FetchedAt: time.Now(), Nodes: nodes}
+	} else {
+		fresh, err = s.failover(cache)
+	}
+	if err != nil {
+		if cacheErr != nil {
+			return nil, err
+		}
+		s.fetchedAt = cache.FetchedAt
+		return cache.Nodes, nil
+	}
+	s.fetchedAt = fresh.FetchedAt
+	// failing to cache the list doesn't make it less fresh
+	_ = s.writeCache(*fresh)
+	return fresh.Nodes, nil
+}
+
+// eventsURL turns the tracker's node list url into the url of its event stream,
+// for example http://tracker/nodes into ws://tracker/events
+func eventsURL(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unknown scheme: %s", u.Scheme)
+	}
+	u.Path = path.Join(path.Dir(u.Path), "events")
+	return u.String(), nil
+}
+
+// Subscribe connects to the event stream of the first tracker that accepts it.
+// The channel is closed when the connection breaks or stop is closed
+func (s *ServerUC) Subscribe(stop <-chan struct{}) (<-chan entity.NodeEvent, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = TRACKER_TIMEOUT
+	errs := make([]error, 0, len(s.serverURLs))
+	var conn *websocket.Conn
+	for _, serverURL := range s.serverURLs {
+		u, err := eventsURL(serverURL)
+		if err == nil {
+			conn, _, err = dialer.Dial(u, nil)
+		}
+		if err == nil {
+			break
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", serverURL, err))
+	}
+	if conn == nil {
+		return nil, errors.Join(errs...)
+	}
+	events := make(chan entity.NodeEvent)
+	go func() {
+		<-stop
+		_ = conn.Close()
+	}()
+	go func() {
+		defer close(events)
+		defer conn.Close()
+		for {
+			event := entity.NodeEvent{}
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			select {
+			case events <- event:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// NodesAge returns how long ago the last returned node list was received from a tracker
+func (s *ServerUC) NodesAge() time.Duration {
+	if s.fetchedAt.IsZero() {
+		return 0
+	}
+	return time.Since(s.fetchedAt)
+}
+
+func (s *ServerUC) readCache() (*nodeCache, error) {
+	file, err := os.Open(s.cachePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	cache := &nodeCache{}
+	if err := json.NewDecoder(file).Decode(cache); err != nil {
 		return nil, err
 	}
-	return availableNodes, nil
+	return cache, nil
+}
+
+func (s *ServerUC) writeCache(cache nodeCache) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.cachePath), filepath.Base(s.cachePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(cache); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.cachePath)
 }
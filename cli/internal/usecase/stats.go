@@ -0,0 +1,281 @@
+package usecase
+
+import (
+	"cli/internal/entity"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// LATENCY_WINDOW is how many recent latencies are kept per node
+	LATENCY_WINDOW = 32
+	// QUARANTINE_FAILURES consecutive failures put the node into quarantine
+	QUARANTINE_FAILURES = 3
+	// QUARANTINE_BASE is the first quarantine period, it doubles with every next failure
+	QUARANTINE_BASE = 5 * time.Minute
+	// QUARANTINE_MAX caps quarantine period, integrity failures are always quarantined for it
+	QUARANTINE_MAX = 24 * time.Hour
+	// flakyFailureRate is the failure rate after which the node is tried after the others
+	flakyFailureRate = 0.5
+	flakyMinRequests = 4
+)
+
+// StatsUC keeps per node request stats in nodes.json. Commands running in parallel record their
+// own requests, so Save merges them into the file under an advisory lock on nodes.json.lock
+type StatsUC struct {
+	statsPath string
+	lockPath  string
+
+	mu     sync.Mutex
+	loaded bool
+	stats  map[string]entity.NodeStats
+	// pending holds what this process recorded since the last Save
+	pending map[string]entity.NodeStats
+}
+
+func NewStatsUC(statsPath string) *StatsUC {
+	return &StatsUC{statsPath: statsPath, lockPath: statsPath + ".lock", pending: make(map[string]entity.NodeStats)}
+}
+
+// load reads stats file once, missing file means no stats yet. Must be called with mu held
+func (s *StatsUC) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+	s.stats = s.read()
+}
+
+// read returns stats stored in the file, missing or broken file means no stats
+func (s *StatsUC) read() map[string]entity.NodeStats {
+	stats := make(map[string]entity.NodeStats)
+	file, err := os.Open(s.statsPath)
+	if err != nil {
+		return stats
+	}
+	defer file.Close()
+	// broken stats are not worth failing a command, start over instead
+	if err := json.NewDecoder(file).Decode(&stats); err != nil {
+		return make(map[string]entity.NodeStats)
+	}
+	return stats
+}
+
+// update applies the change both to the known stats and to the ones pending for Save
+func (s *StatsUC) update(addr string, f func(stats *entity.NodeStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	stats := s.stats[addr]
+	f(&stats)
+	s.stats[addr] = stats
+	pending := s.pending[addr]
+	f(&pending)
+	pending.QuarantinedUntil = stats.QuarantinedUntil
+	s.pending[addr] = pending
+}
+
+// mergeStats adds what was recorded by this process to the stats saved meanwhile by others.
+// Non-zero LastSeen of recorded means a success, consecutive failures are counted from it
+func mergeStats(saved entity.NodeStats, recorded entity.NodeStats) entity.NodeStats {
+	merged := saved
+	merged.Successes += recorded.Successes
+	merged.Failures += recorded.Failures
+	merged.IntegrityFailures += recorded.IntegrityFailures
+	if recorded.LastSeen.IsZero() {
+		merged.ConsecutiveFailures += recorded.ConsecutiveFailures
+	} else {
+		merged.ConsecutiveFailures = recorded.ConsecutiveFailures
+	}
+	if recorded.LastSeen.After(merged.LastSeen) {
+		merged.LastSeen = recorded.LastSeen
+	}
+	if recorded.QuarantinedUntil.After(merged.QuarantinedUntil) {
+		merged.QuarantinedUntil = recorded.QuarantinedUntil
+	}
+	merged.Latencies = append(slices.Clone(merged.Latencies), recorded.Latencies...)
+	if len(merged.Latencies) > LATENCY_WINDOW {
+		merged.Latencies = merged.Latencies[len(merged.Latencies)-LATENCY_WINDOW:]
+	}
+	return merged
+}
+
+func (s *StatsUC) RecordSuccess(addr string, latency time.Duration) {
+	s.update(addr, func(stats *entity.NodeStats) {
+		stats.Successes += 1
+		stats.ConsecutiveFailures = 0
+		stats.LastSeen = time.Now()
+		stats.Latencies = append(stats.Latencies, float64(latency.Microseconds())/1000)
+		if len(stats.Latencies) > LATENCY_WINDOW {
+			stats.Latencies = stats.Latencies[len(stats.Latencies)-LATENCY_WINDOW:]
+		}
+	})
+}
+
+// RecordFailure counts failed request, after QUARANTINE_FAILURES failures in a row
+// the node is quarantined for an exponentially growing period
+func (s *StatsUC) RecordFailure(addr string) {
+	s.update(addr, func(stats *entity.NodeStats) {
+		stats.Failures += 1
+		stats.ConsecutiveFailures += 1
+		if stats.ConsecutiveFailures >= QUARANTINE_FAILURES {
+			period := QUARANTINE_MAX
+			if shift := stats.ConsecutiveFailures - QUARANTINE_FAILURES; shift < 16 {
+				period = min(QUARANTINE_BASE<<shift, QUARANTINE_MAX)
+			}
+			stats.QuarantinedUntil = time.Now().Add(period)
+		}
+	})
+}
+
+// RecordIntegrityFailure counts a chunk that didn't match its hash and quarantines the node
+func (s *StatsUC) RecordIntegrityFailure(addr string) {
+	s.update(addr, func(stats *entity.NodeStats) {
+		stats.Failures += 1
+		stats.IntegrityFailures += 1
+		stats.QuarantinedUntil = time.Now().Add(QUARANTINE_MAX)
+	})
+}
+
+func (s *StatsUC) GetNodeStats(addr string) entity.NodeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	return s.stats[addr]
+}
+
+func (s *StatsUC) IsQuarantined(addr string) bool {
+	return time.Now().Before(s.GetNodeStats(addr).QuarantinedUntil)
+}
+
+// health ranks the node: 0 - healthy, 1 - flaky, 2 - quarantined
+func (s *StatsUC) health(addr string) int {
+	stats := s.GetNodeStats(addr)
+	if time.Now().Before(stats.QuarantinedUntil) {
+		return 2
+	}
+	requests := stats.Successes + stats.Failures
+	if requests >= flakyMinRequests && float64(stats.Failures)/float64(requests) > flakyFailureRate {
+		return 1
+	}
+	return 0
+}
+
+// OrderReplicas orders replica addresses for reading: healthy nodes first,
+// among them the ones with lower mean latency, nodes without measurements after measured ones
+func (s *StatsUC) OrderReplicas(addrs []string) []string {
+	ordered := slices.Clone(addrs)
+	slices.SortStableFunc(ordered, func(a, b string) int {
+		if d := s.health(a) - s.health(b); d != 0 {
+			return d
+		}
+		latencyA, okA := meanLatency(s.GetNodeStats(a))
+		latencyB, okB := meanLatency(s.GetNodeStats(b))
+		switch {
+		case okA && okB && latencyA < latencyB, okA && !okB:
+			return -1
+		case okA && okB && latencyA > latencyB, !okA && okB:
+			return 1
+		}
+		return 0
+	})
+	return ordered
+}
+
+// LatencyPercentile returns the p-th percentile (0 < p <= 1) of recent latencies of the node
+func (s *StatsUC) LatencyPercentile(addr string, p float64) (time.Duration, bool) {
+	latencies := slices.Clone(s.GetNodeStats(addr).Latencies)
+	if len(latencies) == 0 {
+		return 0, false
+	}
+	slices.Sort(latencies)
+	idx := int(math.Ceil(p*float64(len(latencies)))) - 1
+	idx = max(0, min(idx, len(latencies)-1))
+	return time.Duration(latencies[idx] * float64(time.Millisecond)), true
+}
+
+func meanLatency(stats entity.NodeStats) (float64, bool) {
+	if len(stats.Latencies) == 0 {
+		return 0, false
+	}
+	var sum float64
+	for _, latency := range stats.Latencies {
+		sum += latency
+	}
+	return sum / float64(len(stats.Latencies)), true
+}
+
+// Save merges stats recorded by this process into the file, through a temporary file so a crash
+// can't corrupt it. The file is re-read under the lock, so stats of parallel commands are kept
+func (s *StatsUC) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	lock, err := os.OpenFile(s.lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock, true); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", s.lockPath, err)
+	}
+
+	stats := s.read()
+	for addr, recorded := range s.pending {
+		stats[addr] = mergeStats(stats[addr], recorded)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.statsPath), filepath.Base(s.statsPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(stats); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.statsPath); err != nil {
+		return err
+	}
+	s.stats = stats
+	s.pending = make(map[string]entity.NodeStats)
+	return nil
+}
+
+// StatsPlacement runs the inner strategy separately over healthy, flaky and quarantined nodes,
+// so the healthy ones are tried first and keep the inner strategy's guarantees among themselves
+type StatsPlacement struct {
+	Inner PlacementStrategy
+	Stats *StatsUC
+}
+
+func (p *StatsPlacement) Place(chunkHash string, nodes map[string]entity.Node) []entity.Node {
+	return p.PlaceAround(chunkHash, nil, nodes)
+}
+
+func (p *StatsPlacement) PlaceAround(chunkHash string, existing []entity.Node, nodes map[string]entity.Node) []entity.Node {
+	tiers := make([]map[string]entity.Node, 3)
+	for i := range tiers {
+		tiers[i] = make(map[string]entity.Node)
+	}
+	for addr, node := range nodes {
+		tiers[p.Stats.health(addr)][addr] = node
+	}
+	placed := make([]entity.Node, 0, len(nodes))
+	for _, tier := range tiers {
+		if len(tier) > 0 {
+			placed = append(placed, PlaceAround(p.Inner, chunkHash, existing, tier)...)
+		}
+	}
+	return placed
+}
@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsSaveMergesParallelCommands(t *testing.T) {
+	statsPath := filepath.Join(t.TempDir(), "nodes.json")
+	first := NewStatsUC(statsPath)
+	second := NewStatsUC(statsPath)
+
+	// both commands load the stats before either of them saves
+	first.RecordSuccess("a", 10*time.Millisecond)
+	second.RecordFailure("a")
+	second.RecordSuccess("b", 20*time.Millisecond)
+
+	if err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := NewStatsUC(statsPath)
+	a := saved.GetNodeStats("a")
+	if a.Successes != 1 || a.Failures != 1 || a.ConsecutiveFailures != 1 || len(a.Latencies) != 1 {
+		t.Errorf("stats of a = %+v, want one success and one failure", a)
+	}
+	if b := saved.GetNodeStats("b"); b.Successes != 1 {
+		t.Errorf("stats of b = %+v, want one success", b)
+	}
+
+	// saving again doesn't count the same requests twice
+	if err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if a := NewStatsUC(statsPath).GetNodeStats("a"); a.Successes != 1 {
+		t.Errorf("successes of a = %d after a repeated save, want 1", a.Successes)
+	}
+}
@@ -2,9 +2,10 @@ package usecase
 
 import (
 	"cli/internal/entity"
+	"crypto"
 	"crypto/ecdh"
-	"crypto/ecdsa"
 	"github.com/google/uuid"
+	"time"
 )
 
 type Crypto interface {
@@ -14,20 +15,90 @@ type Crypto interface {
 	AESEncrypt(key []byte, plaintext []byte) ([]byte, error)
 	AESDecrypt(key []byte, ciphertext []byte) ([]byte, error)
 	Hash(contents []byte) []byte
-	ReadECDSAPrivKey() (*ecdsa.PrivateKey, error)
-	ReadAesKey() ([]byte, error)
-	PrepareVerification(aesKey []byte, ecdsaKey *ecdsa.PrivateKey) ([]byte, error)
+	Signer() (crypto.Signer, error)
+	PreviousSigner() (crypto.Signer, error)
+	EncryptWithMasterKey(plaintext []byte) ([]byte, error)
+	DecryptWithMasterKey(sealed []byte) ([]byte, error)
+	DecryptWithPreviousMasterKey(sealed []byte) ([]byte, error)
+	PrepareVerification(aesKey []byte, signer crypto.Signer) ([]byte, error)
+	SignReplication(signer crypto.Signer, chunkId []byte, targetAddr []byte, expiry int64) ([]byte, error)
+	SignHandover(signer crypto.Signer, newAddr []byte, expiry int64) ([]byte, error)
+	VerifyNodeCertificate(rawCerts [][]byte, addr []byte) error
+	VerifyAnnouncement(signed entity.SignedAnnouncement) (*entity.Node, error)
+	IndexPointerID(signer crypto.Signer) ([]byte, error)
+}
+
+// KeyStore keeps the user's keys and exports them as passphrase-encrypted bundles
+type KeyStore interface {
+	Path() string
+	Exists() bool
+	IsEncrypted() (bool, error)
+	Read() (*entity.Keys, error)
+	Write(keys entity.Keys) error
+	WriteEncrypted(keys entity.Keys, passphrase []byte) error
+	Export(keys entity.Keys, passphrase []byte) ([]byte, error)
+	Import(bundle []byte, passphrase []byte) (*entity.Keys, error)
+}
+
+// KeyAgent holds decrypted keys in a separate process so the passphrase isn't asked on every command.
+// It signs and encrypts with them, the keys are never handed out
+type KeyAgent interface {
+	SocketPath() string
+	Available() bool
+	Public(previous bool) ([]byte, error)
+	Sign(digest []byte, previous bool) ([]byte, error)
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(sealed []byte, previous bool) ([]byte, error)
+	Stop() error
 }
 
 type Storage interface {
 	GetFileInfos() (map[uuid.UUID]entity.FileInfo, error)
-	WriteFileInfos(fileInfos map[uuid.UUID]entity.FileInfo) error
+	// Modify runs read-modify-write of the whole index atomically
+	Modify(fn func(fileInfos map[uuid.UUID]entity.FileInfo) error) error
 	GetFileInfo(uuid uuid.UUID) (*entity.FileInfo, error)
 	AppendFileInfo(fileInfo entity.FileInfo) (*uuid.UUID, error)
 	DeleteFileInfo(uuid uuid.UUID) error
 	UpdateFileInfo(uuid uuid.UUID, fileInfo entity.FileInfo) error
+	GetIndexBackup() (*entity.IndexBackup, error)
+	SetIndexBackup(backup entity.IndexBackup) error
+}
+
+// PlacementStrategy orders available nodes by preference for storing the chunk,
+// upload takes the first nodes that accept it
+type PlacementStrategy interface {
+	Place(chunkHash string, nodes map[string]entity.Node) []entity.Node
+}
+
+// ReplicaPlacement is a placement strategy that takes existing replicas of the chunk into account
+type ReplicaPlacement interface {
+	PlaceAround(chunkHash string, existing []entity.Node, nodes map[string]entity.Node) []entity.Node
+}
+
+// NodeStats keeps per-node reliability and latency between runs
+type NodeStats interface {
+	RecordSuccess(addr string, latency time.Duration)
+	RecordFailure(addr string)
+	RecordIntegrityFailure(addr string)
+	GetNodeStats(addr string) entity.NodeStats
+	IsQuarantined(addr string) bool
+	OrderReplicas(addrs []string) []string
+	LatencyPercentile(addr string, p float64) (time.Duration, bool)
+	Save() error
+}
+
+// ChunkLocator finds nodes that store the chunk, regardless of what local file info says
+type ChunkLocator interface {
+	LocateChunk(chunkHash string) (map[string]entity.Node, error)
 }
 
 type Server interface {
-	GetAvailableNodes() (map[string]string, error)
+	GetAvailableNodes() (map[string]entity.Node, error)
+	// NodesAge is the age of the node list last returned by GetAvailableNodes
+	NodesAge() time.Duration
+}
+
+// NodeEvents streams changes of the node list, see entity.NodeEvent
+type NodeEvents interface {
+	Subscribe(stop <-chan struct{}) (<-chan entity.NodeEvent, error)
 }
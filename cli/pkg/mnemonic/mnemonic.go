@@ -0,0 +1,80 @@
+// Package mnemonic encodes binary data as words of the BIP39 english wordlist.
+// Like BIP39, the data is followed by the first len(data)*8/32 bits of its SHA-256
+// and split into 11-bit word indices, but any data length divisible by 4 is allowed.
+// For 16 to 32 bytes the words are the same as BIP39 mnemonics of that entropy
+package mnemonic
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/tyler-smith/go-bip39/wordlists"
+	"math/big"
+	"strings"
+)
+
+const bitsPerWord = 11
+
+var wordIndex = func() map[string]int {
+	index := make(map[string]int, len(wordlists.English))
+	for i, word := range wordlists.English {
+		index[word] = i
+	}
+	return index
+}()
+
+// Encode returns the words for data, its length must be a positive multiple of 4
+func Encode(data []byte) ([]string, error) {
+	if len(data) == 0 || len(data)%4 != 0 {
+		return nil, fmt.Errorf("data length must be a positive multiple of 4, got %d", len(data))
+	}
+	checksumBits := len(data) * 8 / 32
+	total := len(data)*8 + checksumBits
+	n := new(big.Int).SetBytes(data)
+	n.Lsh(n, uint(checksumBits))
+	n.Or(n, checksum(data, checksumBits))
+
+	words := make([]string, total/bitsPerWord)
+	mask := big.NewInt(1<<bitsPerWord - 1)
+	idx := new(big.Int)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = wordlists.English[idx.And(n, mask).Int64()]
+		n.Rsh(n, bitsPerWord)
+	}
+	return words, nil
+}
+
+// Decode converts the words back to data and verifies the checksum
+func Decode(words []string) ([]byte, error) {
+	total := len(words) * bitsPerWord
+	// total = 33 * checksumBits
+	if len(words) == 0 || total%33 != 0 {
+		return nil, fmt.Errorf("wrong number of words: %d", len(words))
+	}
+	checksumBits := total / 33
+	if checksumBits >= 63 {
+		return nil, fmt.Errorf("too many words: %d", len(words))
+	}
+	n := new(big.Int)
+	for _, word := range words {
+		i, ok := wordIndex[strings.ToLower(word)]
+		if !ok {
+			return nil, fmt.Errorf("unknown word %q", word)
+		}
+		n.Lsh(n, bitsPerWord)
+		n.Or(n, big.NewInt(int64(i)))
+	}
+	sum := new(big.Int).And(n, big.NewInt(1<<checksumBits-1))
+	n.Rsh(n, uint(checksumBits))
+	data := n.FillBytes(make([]byte, checksumBits*4))
+	if sum.Cmp(checksum(data, checksumBits)) != 0 {
+		return nil, fmt.Errorf("checksum mismatch, check the words")
+	}
+	return data, nil
+}
+
+// checksum returns the first bits of the SHA-256 of data
+func checksum(data []byte, bits int) *big.Int {
+	hash := sha256.Sum256(data)
+	sum := new(big.Int).SetBytes(hash[:])
+	return sum.Rsh(sum, uint(len(hash)*8-bits))
+}
@@ -0,0 +1,111 @@
+package mnemonic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// bip39Vectors are entropy and mnemonic pairs from the BIP39 reference test vectors
+var bip39Vectors = []struct {
+	entropy  string
+	mnemonic string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+	},
+	{
+		"80808080808080808080808080808080",
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+	},
+	{
+		"ffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon " +
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+	},
+	{
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+	},
+	{
+		"9e885d952ad362caeb4efe34a8e91bd2",
+		"ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic",
+	},
+	{
+		"68a79eaca2324873eacc50cb9c6eca8cc68ea5d936f98787c60c7ebc74e6ce7c",
+		"hamster diagram private dutch cause delay private meat slide toddler razor book happy fancy " +
+			"gospel tennis maple dilemma loan word shrug inflict delay length",
+	},
+}
+
+func TestBIP39Vectors(t *testing.T) {
+	for _, vector := range bip39Vectors {
+		entropy, err := hex.DecodeString(vector.entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		words, err := Encode(entropy)
+		if err != nil {
+			t.Fatalf("%s: %v", vector.entropy, err)
+		}
+		if got := strings.Join(words, " "); got != vector.mnemonic {
+			t.Errorf("%s: got %q, expected %q", vector.entropy, got, vector.mnemonic)
+		}
+		decoded, err := Decode(strings.Fields(vector.mnemonic))
+		if err != nil {
+			t.Fatalf("%s: %v", vector.entropy, err)
+		}
+		if !bytes.Equal(decoded, entropy) {
+			t.Errorf("%s: decoded %x", vector.entropy, decoded)
+		}
+	}
+}
+
+func TestRoundTripKeyBundle(t *testing.T) {
+	// exported key bundles are 92 bytes
+	data := make([]byte, 92)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	words, err := Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != 69 {
+		t.Fatalf("got %d words, expected 69", len(words))
+	}
+	decoded, err := Decode(words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Errorf("decoded %x", decoded)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	words := strings.Fields(bip39Vectors[0].mnemonic)
+	words[len(words)-1] = "abandon"
+	if _, err := Decode(words); err == nil {
+		t.Error("wrong checksum accepted")
+	}
+	if _, err := Decode(words[:len(words)-1]); err == nil {
+		t.Error("wrong number of words accepted")
+	}
+	if _, err := Decode(append(words[:len(words)-1], "notaword")); err == nil {
+		t.Error("unknown word accepted")
+	}
+	if _, err := Encode(make([]byte, 5)); err == nil {
+		t.Error("data length not divisible by 4 accepted")
+	}
+}
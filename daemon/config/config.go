@@ -2,9 +2,13 @@ package config
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/ilyakaznacheev/cleanenv"
+	"log"
 	"os"
+	"path"
+	"slices"
 )
 
 type (
@@ -12,23 +16,106 @@ type (
 		Port      string `toml:"port"`
 		ServerURL string `toml:"server_url"`
 		BasePath  string `toml:"base_path" env-default:"~/.distorage/"`
-		Addr      string `toml:"addr"`
+		KeyFile   string `toml:"key_file"`
+
+		// адрес узла раньше совпадал с адресом пользователя, теперь он вычисляется из key_file
+		LegacyAddr string `toml:"addr"`
+
+		// список трекеров, узел анонсирует себя всем сразу; server_url добавляется к нему
+		ServerURLs []string `toml:"server_urls"`
+
+		// узел участвует в DHT и входит в нее через bootstrap-узлы (scheme://host:port)
+		DHT       bool     `toml:"dht"`
+		Bootstrap []string `toml:"bootstrap"`
+
+		// узел анонсирует себя в локальной сети через multicast-группу (host:port)
+		LAN          bool   `toml:"lan"`
+		LANGroup     string `toml:"lan_group" env-default:"239.255.77.77:53590"`
+		LANInterface string `toml:"lan_interface"`
+
+		// адрес, по которому узел доступен клиентам (например, за NAT или reverse proxy)
+		AdvertiseHost string `toml:"advertise_host"`
+		AdvertisePort string `toml:"advertise_port"`
+		Scheme        string `toml:"scheme" env-default:"ws"`
+
+		// метки размещения, по которым клиенты разносят реплики
+		Region string `toml:"region"`
+		Zone   string `toml:"zone"`
+		// идентификатор машины, по умолчанию вычисляется из /etc/machine-id или имени хоста
+		HostID string `toml:"host_id"`
+		// сколько байт узел готов отдать под хранилище, 0 - без ограничений
+		Capacity uint64 `toml:"capacity"`
+
+		// сертификат для wss; если не задан, используется самоподписанный сертификат на ключе узла
+		TLSCertFile string `toml:"tls_cert_file"`
+		TLSKeyFile  string `toml:"tls_key_file"`
+
+		PidFile    string `toml:"pid_file" env-default:"distorage_daemon.pid"`
+		LogFile    string `toml:"log_file" env-default:"distorage_daemon.log"`
+		Foreground bool   `toml:"foreground"`
+
+		// ConfigPath - путь до файла, из которого был прочитан конфиг
+		ConfigPath string `toml:"-"`
 	}
 )
 
+// NewConfig читает конфиг из файла, переданного флагом -config (или единственным аргументом),
+// и применяет к нему остальные флаги командной строки
 func NewConfig() (*Config, error) {
-	if len(os.Args) < 2 {
-		return nil, errors.New("please provide path to config file")
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to config file")
+	foreground := flags.Bool("foreground", false, "run in foreground and log to stderr instead of forking")
+	pidFile := flags.String("pid-file", "", "path to pid file (overrides pid_file from config)")
+	logFile := flags.String("log-file", "", "path to log file (overrides log_file from config)")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return nil, err
 	}
-	if len(os.Args) > 2 {
+
+	switch {
+	case *configPath == "" && flags.NArg() == 1:
+		*configPath = flags.Arg(0)
+	case flags.NArg() > 1 || (*configPath != "" && flags.NArg() > 0):
 		return nil, errors.New("too many arguments")
+	case *configPath == "":
+		return nil, errors.New("please provide path to config file")
 	}
-	cfg := &Config{}
 
-	err := cleanenv.ReadConfig(os.Args[1], cfg)
+	cfg := &Config{}
+	err := cleanenv.ReadConfig(*configPath, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("config error: %w", err)
 	}
+	cfg.ConfigPath = *configPath
+	if cfg.LegacyAddr != "" {
+		// старые конфиги продолжают работать, адрес из них просто не используется
+		log.Printf("config - NewConfig - addr %s in %s is ignored, the node address is derived from key_file. "+
+			"Chunks uploaded before are still stored, their owners have to run distorage relocate "+
+			"to find them under the new address\n", cfg.LegacyAddr, *configPath)
+	}
+	if cfg.ServerURL != "" && !slices.Contains(cfg.ServerURLs, cfg.ServerURL) {
+		cfg.ServerURLs = append([]string{cfg.ServerURL}, cfg.ServerURLs...)
+	}
+	if len(cfg.ServerURLs) == 0 && !cfg.DHT && !cfg.LAN {
+		return nil, errors.New("config error: no tracker url provided, dht and lan discovery are disabled")
+	}
+	if cfg.AdvertisePort == "" {
+		cfg.AdvertisePort = cfg.Port
+	}
+	if cfg.Scheme != "ws" && cfg.Scheme != "wss" {
+		return nil, fmt.Errorf("config error: unknown scheme %s", cfg.Scheme)
+	}
+	if cfg.KeyFile == "" {
+		cfg.KeyFile = path.Join(cfg.BasePath, "node_key.json")
+	}
+	if *foreground {
+		cfg.Foreground = true
+	}
+	if *pidFile != "" {
+		cfg.PidFile = *pidFile
+	}
+	if *logFile != "" {
+		cfg.LogFile = *logFile
+	}
 
 	return cfg, nil
 }
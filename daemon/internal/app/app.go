@@ -1,15 +1,21 @@
 package app
 
 import (
+	"crypto/tls"
 	"encoding/hex"
-	"github.com/gorilla/websocket"
+	"errors"
 	"github.com/s1lur/distorage/daemon/config"
 	"github.com/s1lur/distorage/daemon/internal/controller/ws"
+	"github.com/s1lur/distorage/daemon/internal/entity"
 	"github.com/s1lur/distorage/daemon/internal/usecase"
+	"github.com/s1lur/distorage/daemon/pkg/kademlia"
+	"github.com/s1lur/distorage/daemon/pkg/lan"
+	"github.com/s1lur/distorage/daemon/pkg/sdnotify"
+	"github.com/s1lur/distorage/daemon/pkg/tracker"
 	"github.com/s1lur/distorage/daemon/pkg/wsserver"
 	"github.com/sevlyar/go-daemon"
 	"log"
-	"net/url"
+	"net/http"
 	"os"
 	"os/signal"
 	"path"
@@ -20,44 +26,128 @@ import (
 // мне в падлу здесь писать доку, можно на этот файл не обращать внимания
 
 func Run(cfg *config.Config) {
-	cntxt := &daemon.Context{
-		PidFileName: "distorage_daemon.pid",
-		PidFilePerm: 0644,
-		LogFileName: "distorage_daemon.log",
-		LogFilePerm: 0640,
-		WorkDir:     "./",
-		Umask:       027,
-		Args:        []string{"[go-daemon app]", os.Args[1]},
+	if !cfg.Foreground {
+		cntxt := &daemon.Context{
+			PidFileName: cfg.PidFile,
+			PidFilePerm: 0644,
+			LogFileName: cfg.LogFile,
+			LogFilePerm: 0640,
+			WorkDir:     "./",
+			Umask:       027,
+			Args:        append([]string{"[go-daemon app]"}, os.Args[1:]...),
+		}
+
+		d, err := cntxt.Reborn()
+		if err != nil {
+			log.Fatal("Unable to run: ", err)
+		}
+		if d != nil {
+			return
+		}
+		defer cntxt.Release()
 	}
 
-	d, err := cntxt.Reborn()
+	cryptoUseCase := usecase.NewCryptoUC()
+
+	nodeKey, err := cryptoUseCase.LoadOrGenerateKey(cfg.KeyFile)
+	if err != nil {
+		log.Fatal("Error loading node key: ", err)
+	}
+	byteAddr, err := cryptoUseCase.GetKeyAddress(nodeKey)
 	if err != nil {
-		log.Fatal("Unable to run: ", err)
+		log.Fatal("Error deriving node address: ", err)
 	}
-	if d != nil {
-		return
+	log.Printf("node address: %s", hex.EncodeToString(byteAddr))
+	storageUseCase := usecase.NewStorageUC(
+		path.Join(cfg.BasePath, "store"),
+		path.Join(cfg.BasePath, "index.json"),
+	)
+	if err := storageUseCase.LoadIndex(); err != nil {
+		log.Fatal("Error loading owner index: ", err)
 	}
-	defer cntxt.Release()
 
-	byteAddr, err := hex.DecodeString(cfg.Addr)
-	if err != nil {
-		log.Fatal("Error decoding address: ", err)
+	serverOptions := []wsserver.Option{wsserver.Port(cfg.Port)}
+	announcement := entity.Announcement{
+		Addr:            hex.EncodeToString(byteAddr),
+		Host:            cfg.AdvertiseHost,
+		Port:            cfg.AdvertisePort,
+		Scheme:          cfg.Scheme,
+		Version:         Version,
+		ProtocolVersion: ProtocolVersion,
+		Region:          cfg.Region,
+		Zone:            cfg.Zone,
+		HostID:          cfg.HostID,
+	}
+	if announcement.HostID == "" {
+		announcement.HostID = hostID()
+	}
+	if cfg.Scheme == "wss" {
+		var cert tls.Certificate
+		if cfg.TLSCertFile != "" {
+			cert, err = tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			cert, err = cryptoUseCase.SelfSignedCertificate(nodeKey)
+		}
+		if err != nil {
+			log.Fatal("Error loading TLS certificate: ", err)
+		}
+		serverOptions = append(serverOptions, wsserver.TLS(cert))
+		announcement.CertFingerprint = cryptoUseCase.CertificateFingerprint(cert)
 	}
 
-	cryptoUseCase := usecase.NewCryptoUC()
-	storageUseCase := usecase.NewStorageUC(path.Join(cfg.BasePath, "store"))
+	announcerUseCase := usecase.NewAnnouncerUC(cryptoUseCase, storageUseCase, nodeKey, announcement, cfg.Capacity)
+
+	trackerClients := make([]*tracker.Client, 0, len(cfg.ServerURLs))
+	trackerStatuses := make([]ws.TrackerStatus, 0, len(cfg.ServerURLs))
+	for _, serverURL := range cfg.ServerURLs {
+		trackerClient, err := tracker.New(
+			serverURL,
+			trackerHandshake(cryptoUseCase, nodeKey, announcerUseCase),
+			tracker.Heartbeat(sendAnnouncement(announcerUseCase)),
+		)
+		if err != nil {
+			log.Fatal("Error parsing tracker url: ", err)
+		}
+		trackerClients = append(trackerClients, trackerClient)
+		trackerStatuses = append(trackerStatuses, trackerClient)
+	}
 
-	router := ws.RegisterRoutes(cryptoUseCase, storageUseCase)
+	router := ws.RegisterRoutes(cryptoUseCase, storageUseCase, byteAddr)
+	var dhtStatus ws.DHTStatus
+	var dhtNode *kademlia.DHT
+	stopPublisher := make(chan struct{})
+	if cfg.DHT {
+		dhtNode = newDHT(cfg, cryptoUseCase, announcerUseCase, byteAddr)
+		ws.RegisterDHT(router, dhtNode)
+		dhtStatus = dhtNode
+	}
+	var lanStatus ws.LANStatus
+	var lanAnnouncer *lan.Announcer
+	if cfg.LAN {
+		lanAnnouncer, err = newLANAnnouncer(cfg, announcerUseCase)
+		if err != nil {
+			log.Fatal("Error starting lan discovery: ", err)
+		}
+		lanStatus = lanAnnouncer
+	}
+	ws.RegisterHealth(router, trackerStatuses, dhtStatus, lanStatus)
 
-	wsServer := wsserver.New(router, wsserver.Port(cfg.Port))
+	wsServer := wsserver.New(router, serverOptions...)
+	if dhtNode != nil {
+		dhtNode.Start()
+		go runProviderPublisher(dhtNode, storageUseCase, stopPublisher)
+	}
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
-	go connectToServer(
-		cfg.ServerURL,
-		byteAddr,
-		interrupt)
+	if _, err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		log.Printf("app - Run - sdnotify: %s", err)
+	}
+	stopWatchdog := make(chan struct{})
+	if interval, ok := sdnotify.WatchdogInterval(); ok {
+		go runWatchdog(interval, stopWatchdog)
+	}
 
 	select {
 	case s := <-interrupt:
@@ -66,79 +156,45 @@ func Run(cfg *config.Config) {
 		log.Fatalf("app - Run - httpServer.Notify: %s", err)
 	}
 
+	_, _ = sdnotify.Notify(sdnotify.Stopping)
+	close(stopWatchdog)
+	for _, trackerClient := range trackerClients {
+		trackerClient.Shutdown()
+	}
+	if dhtNode != nil {
+		close(stopPublisher)
+		dhtNode.Shutdown()
+	}
+	if lanAnnouncer != nil {
+		lanAnnouncer.Shutdown()
+	}
+
 	err = wsServer.Shutdown()
 	if err != nil {
 		log.Fatalf("app - Run - httpServer.Shutdown: %s", err)
 	}
 
 	err = <-wsServer.Notify()
-	log.Fatalf("app - run - wsServer.Notify: %s", err)
-}
-
-func connectToServer(serverURL string, addr []byte, interrupt chan os.Signal) {
-	u := url.URL{Scheme: "ws", Host: serverURL}
-	serverPath, err := url.PathUnescape(u.String())
-	if err != nil {
-		log.Fatalf("error parsing url: %e", err)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("app - run - wsServer.Notify: %s", err)
 	}
-	log.Printf("connecting to %s", serverPath)
-
-	c, _, err := websocket.DefaultDialer.Dial(serverPath, nil)
-	if err != nil {
-		log.Fatal("dial:", err)
-		return
+	if err := storageUseCase.SaveIndex(); err != nil {
+		log.Printf("app - Run - storageUseCase.SaveIndex: %s", err)
 	}
-	c.SetPongHandler(func(string) error { log.Println("received pong from server"); return nil })
-	defer c.Close()
-
-	done := make(chan struct{})
-
-	log.Printf("sending address")
-	err = c.WriteMessage(websocket.BinaryMessage, addr)
-	if err != nil {
-		log.Fatal("connectToServer - error sending message: ", err)
-		return
-	}
-	go func() {
-		defer c.Close()
-		defer close(done)
-		go func() {
-			for {
-				if _, _, err := c.NextReader(); err != nil {
-					break
-				}
-			}
-		}()
-		for {
-			log.Println("pinging server")
-			err = c.WriteMessage(websocket.PingMessage, []byte{})
-			if err != nil {
-				log.Println("write to server:", err)
-				return
-			}
-			time.Sleep(20 * time.Second)
-		}
-	}()
+}
 
-	ticker := time.NewTicker(time.Second)
+// runWatchdog пингует watchdog systemd в два раза чаще, чем требует WATCHDOG_USEC
+func runWatchdog(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval / 2)
 	defer ticker.Stop()
-
 	for {
 		select {
-		case <-done:
+		case <-stop:
 			return
-		case <-interrupt:
-			log.Println("interrupt")
-			err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-			if err != nil {
-				log.Println("write close:", err)
-				return
+		case <-ticker.C:
+			if _, err := sdnotify.Notify(sdnotify.Watchdog); err != nil {
+				log.Printf("app - runWatchdog - sdnotify: %s", err)
 			}
-			select {
-			case <-done:
-			case <-time.After(time.Second):
-			}
-			return
 		}
 	}
 }
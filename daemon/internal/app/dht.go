@@ -0,0 +1,138 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/s1lur/distorage/daemon/config"
+	"github.com/s1lur/distorage/daemon/internal/entity"
+	"github.com/s1lur/distorage/daemon/internal/usecase"
+	"github.com/s1lur/distorage/daemon/pkg/kademlia"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// announcementMaxAge - анонсы старше этого возраста не принимаются от узлов DHT
+	announcementMaxAge = time.Hour
+	// newChunksInterval - как часто публикуются только что сохраненные чанки
+	newChunksInterval = time.Minute
+	// republishInterval - как часто заново публикуются все чанки, меньше времени жизни записи
+	republishInterval = 30 * time.Minute
+	// contactTTL - как долго подписанный анонс узла переиспользуется в RPC, как и heartbeat трекеру
+	contactTTL = 20 * time.Second
+)
+
+// newDHT создает узел DHT, контактом которого служит подписанный анонс узла
+func newDHT(cfg *config.Config, c usecase.Crypto, a usecase.Announcer, addr []byte) *kademlia.DHT {
+	var self kademlia.ID
+	copy(self[:], addr)
+	selfURL := url.URL{Scheme: cfg.Scheme, Host: net.JoinHostPort(cfg.AdvertiseHost, cfg.AdvertisePort)}
+	selfContact := func() (kademlia.Contact, error) {
+		signed, err := a.Announce()
+		if err != nil {
+			return kademlia.Contact{}, err
+		}
+		info, err := json.Marshal(signed)
+		if err != nil {
+			return kademlia.Contact{}, err
+		}
+		return kademlia.Contact{ID: self, URL: selfURL.String(), Info: info}, nil
+	}
+	return kademlia.New(
+		self,
+		selfContact,
+		kademlia.Bootstrap(cfg.Bootstrap),
+		kademlia.Verify(dhtVerifier(c)),
+		kademlia.ContactTTL(contactTTL),
+	)
+}
+
+// dhtVerifier принимает контакт, только если его информация - свежий анонс, подписанный ключом
+// с адресом, равным ID контакта. Если узел предъявил TLS-сертификат, он должен совпадать
+// с анонсированным отпечатком или быть выпущен на ключ узла
+func dhtVerifier(c usecase.Crypto) kademlia.Verifier {
+	return func(contact kademlia.Contact, rawCerts [][]byte) error {
+		signed := &entity.SignedAnnouncement{}
+		if err := json.Unmarshal(contact.Info, signed); err != nil {
+			return err
+		}
+		announcement, err := c.VerifyAnnouncement(signed)
+		if err != nil {
+			return err
+		}
+		if announcement.Addr != contact.ID.String() {
+			return errors.New("contact id doesn't match announcement")
+		}
+		if age := time.Since(time.Unix(announcement.Timestamp, 0)); age > announcementMaxAge {
+			return fmt.Errorf("announcement is too old: %s", age)
+		}
+		contactURL, err := url.Parse(contact.URL)
+		if err != nil {
+			return err
+		}
+		if contactURL.Scheme != announcement.Scheme {
+			return errors.New("contact scheme doesn't match announcement")
+		}
+		if len(rawCerts) == 0 {
+			return nil
+		}
+		fingerprint := sha256.Sum256(rawCerts[0])
+		if announcement.CertFingerprint != "" &&
+			hex.EncodeToString(fingerprint[:]) == strings.ToLower(announcement.CertFingerprint) {
+			return nil
+		}
+		return c.VerifyNodeCertificate(rawCerts, contact.ID[:])
+	}
+}
+
+// runProviderPublisher объявляет узел поставщиком хранящихся чанков:
+// новые чанки публикуются раз в newChunksInterval, все - раз в republishInterval
+func runProviderPublisher(d *kademlia.DHT, s usecase.Storage, stop chan struct{}) {
+	published := make(map[string]bool)
+	lastRepublish := time.Now()
+	ticker := time.NewTicker(newChunksInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if d.Size() == 0 {
+			continue
+		}
+		if time.Since(lastRepublish) > republishInterval {
+			published = make(map[string]bool)
+			lastRepublish = time.Now()
+		}
+		chunks := s.ListAllChunks()
+		stored := make(map[string]bool, len(chunks))
+		for _, chunkId := range chunks {
+			stored[chunkId] = true
+			if published[chunkId] {
+				continue
+			}
+			chunkIdBytes, err := hex.DecodeString(chunkId)
+			if err != nil {
+				continue
+			}
+			if err := d.Provide(kademlia.KeyID(chunkIdBytes)); err != nil {
+				log.Printf("dht - provide %s: %v", chunkId, err)
+				continue
+			}
+			published[chunkId] = true
+		}
+		// удаленные чанки перестают переопубликовываться и истекают сами
+		for chunkId := range published {
+			if !stored[chunkId] {
+				delete(published, chunkId)
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+)
+
+// hostID возвращает идентификатор машины для метки домена отказа.
+// machine-id нельзя раскрывать как есть, поэтому анонсируется хэш от него
+func hostID() string {
+	id, err := os.ReadFile("/etc/machine-id")
+	if err != nil || len(strings.TrimSpace(string(id))) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return ""
+		}
+		id = []byte(hostname)
+	}
+	sum := sha256.Sum256(append([]byte("distorage-host-id"), strings.TrimSpace(string(id))...))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/s1lur/distorage/daemon/config"
+	"github.com/s1lur/distorage/daemon/internal/usecase"
+	"github.com/s1lur/distorage/daemon/pkg/lan"
+	"net"
+)
+
+// newLANAnnouncer запускает анонсирование узла в локальной сети.
+// В датаграмме передается тот же подписанный анонс, что и трекеру
+func newLANAnnouncer(cfg *config.Config, a usecase.Announcer) (*lan.Announcer, error) {
+	opts := make([]lan.Option, 0, 1)
+	if cfg.LANInterface != "" {
+		iface, err := net.InterfaceByName(cfg.LANInterface)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, lan.Interface(iface))
+	}
+	announcer, err := lan.New(cfg.LANGroup, func() ([]byte, error) {
+		signed, err := a.Announce()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(signed)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := announcer.Start(); err != nil {
+		return nil, err
+	}
+	return announcer, nil
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/s1lur/distorage/daemon/internal/usecase"
+	"github.com/s1lur/distorage/daemon/pkg/tracker"
+)
+
+// trackerHandshake отвечает на challenge трекера подписью ключа узла:
+// трекер присылает случайные байты, узел возвращает публичный ключ и подпись (см. SignChallenge),
+// трекер подтверждает регистрацию сообщением 0xc8, и узел отправляет подписанный анонс
+func trackerHandshake(c usecase.Crypto, key *ecdsa.PrivateKey, a usecase.Announcer) tracker.Handshake {
+	return func(conn *websocket.Conn) error {
+		mt, challenge, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if mt != websocket.BinaryMessage {
+			return fmt.Errorf("wrong message type received: %d", mt)
+		}
+		response, err := c.SignChallenge(key, challenge)
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, response); err != nil {
+			return err
+		}
+		mt, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if mt != websocket.BinaryMessage || !bytes.Equal(message, []byte{0xc8}) {
+			return fmt.Errorf("tracker rejected registration: %x", message)
+		}
+		return sendAnnouncement(a)(conn)
+	}
+}
+
+// sendAnnouncement отправляет трекеру свежий подписанный анонс узла
+func sendAnnouncement(a usecase.Announcer) func(conn *websocket.Conn) error {
+	return func(conn *websocket.Conn) error {
+		announcement, err := a.Announce()
+		if err != nil {
+			return err
+		}
+		return conn.WriteJSON(announcement)
+	}
+}
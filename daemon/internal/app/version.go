@@ -0,0 +1,8 @@
+package app
+
+// Version - версия демона, задается при сборке через -ldflags "-X .../internal/app.Version=..."
+var Version = "dev"
+
+// ProtocolVersion - версия протокола общения с клиентами и другими узлами,
+// увеличивается при несовместимых изменениях ручек
+const ProtocolVersion = 1
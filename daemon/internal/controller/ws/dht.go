@@ -0,0 +1,48 @@
+package ws
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/s1lur/distorage/daemon/pkg/kademlia"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// RegisterDHT добавляет ручки RPC Kademlia (POST /dht/{rpc}).
+// Тело запроса и ответа - JSON (см. kademlia.Request и kademlia.Response)
+func RegisterDHT(r *mux.Router, d *kademlia.DHT) {
+	r.HandleFunc(kademlia.RPCPath("{rpc}"), func(w http.ResponseWriter, r *http.Request) {
+		req := kademlia.Request{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, kademlia.MaxMessageSize)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// узел, не знающий свой внешний адрес, анонсирует пустой хост - подставляем адрес подключения
+		if req.Sender.URL != "" {
+			senderURL, err := url.Parse(req.Sender.URL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if senderURL.Hostname() == "" {
+				remoteHost, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err == nil {
+					senderURL.Host = net.JoinHostPort(remoteHost, senderURL.Port())
+					req.Sender.URL = senderURL.String()
+				}
+			}
+		}
+		resp, err := d.Handle(mux.Vars(r)["rpc"], req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("ws - dht - %v\n", err)
+		}
+	}).Methods("POST")
+}
@@ -0,0 +1,81 @@
+package ws
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Handover ручка, через которую владелец после смены ключей передает все свои чанки на узле новому адресу.
+//
+// После преамбулы клиент отправляет данные для проверки адреса старым ключом (как в Store), за которыми
+// следует передача в том же формате, что и разрешение на репликацию (см. parseAuthorisation), но вместо
+// адреса узла-получателя в ней новый адрес владельца, а подпись проверяется VerifyHandover.
+// В ответ отправляется 0xc8 и количество переданных чанков (4 байта, little endian)
+func (routes *Routes) Handover(w http.ResponseWriter, r *http.Request) {
+	// апгрейд соединения и сохранение информации о соединении
+	connection, _ := upgrader.Upgrade(w, r, nil)
+	defer connection.Close()
+	routes.clients[connection] = true
+	defer delete(routes.clients, connection)
+
+	// сохранение данных, полученных из преамбулы, в соответствующие переменные
+	session, err := routes.executePreamble(connection)
+	if err != nil {
+		log.Printf("ws - handover - %v\n", err)
+		return
+	}
+	sigSize := session.requestMessage[0]
+	nonce := session.requestMessage[1 : 1+aes.BlockSize]
+	sig := session.requestMessage[1+aes.BlockSize : 1+aes.BlockSize+sigSize]
+	body := session.requestMessage[1+aes.BlockSize+sigSize:]
+
+	// проверка адреса (см. VerifyAddress)
+	err = routes.cryptoUC.VerifyAddress(
+		session.sharedKey,
+		nonce,
+		sig,
+		session.remotePubKey,
+	)
+	if err != nil {
+		log.Printf("ws - handover - %v\n", err)
+		return
+	}
+
+	// получаем адрес из публичного ключа ЭП
+	remoteAddr := routes.cryptoUC.GetAddress(session.remotePubKey)
+
+	// разбор и проверка передачи
+	handover, _, err := parseAuthorisation(body)
+	if err != nil || bytes.Equal(handover.targetAddr, remoteAddr) {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+	if time.Now().Unix() > handover.expiry {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x91})
+		return
+	}
+	err = routes.cryptoUC.VerifyHandover(session.remotePubKey, handover.targetAddr, handover.expiry, handover.sig)
+	if err != nil {
+		log.Printf("ws - handover - %v\n", err)
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x91})
+		return
+	}
+
+	// передача чанков, при повторном запросе передаются только оставшиеся
+	moved := routes.storageUC.TransferFiles(remoteAddr, handover.targetAddr)
+
+	// отправка сообщения об успехе
+	err = connection.WriteMessage(
+		websocket.BinaryMessage,
+		binary.LittleEndian.AppendUint32([]byte{0xc8}, uint32(moved)),
+	)
+	if err != nil {
+		log.Printf("ws - handover - %v\n", err)
+		return
+	}
+}
@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/s1lur/distorage/daemon/pkg/tracker"
+	"net/http"
+)
+
+// TrackerStatus отдает состояние подключения к трекеру
+type TrackerStatus interface {
+	Status() tracker.Status
+	State() tracker.State
+}
+
+// DHTStatus отдает состояние узла DHT
+type DHTStatus interface {
+	Size() int
+}
+
+// LANStatus отдает состояние анонсирования в локальной сети
+type LANStatus interface {
+	Announcing() bool
+}
+
+// RegisterHealth добавляет ручку /health, отвечающую 200, если работает хотя бы один из включенных
+// способов обнаружения: демон подключен к трекеру, знает хотя бы один узел DHT или анонсирует себя
+// в локальной сети, и 503 в противном случае. В теле ответа - состояние подключения к каждому трекеру,
+// размер таблицы DHT и состояние анонсирования; dht и lan равны nil, если они выключены
+func RegisterHealth(r *mux.Router, trackers []TrackerStatus, dht DHTStatus, lan LANStatus) {
+	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		connected := false
+		statuses := make([]tracker.Status, 0, len(trackers))
+		for _, t := range trackers {
+			connected = connected || t.State() == tracker.Connected
+			statuses = append(statuses, t.Status())
+		}
+		body := map[string]any{
+			"trackers": statuses,
+		}
+		if dht != nil {
+			body["dht_nodes"] = dht.Size()
+			connected = connected || dht.Size() > 0
+		}
+		if lan != nil {
+			body["lan_announcing"] = lan.Announcing()
+			connected = connected || lan.Announcing()
+		}
+		if !connected {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	}).Methods("GET")
+}
@@ -0,0 +1,309 @@
+package ws
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/s1lur/distorage/daemon/internal/usecase"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// authorisation - разрешение владельца на копирование чанка на узел targetAddr
+type authorisation struct {
+	targetAddr []byte
+	expiry     int64
+	sig        []byte
+}
+
+// parseAuthorisation разбирает разрешение вида
+// [20 байт адрес получателя][8 байт expiry, little endian][1 байт длина подписи][подпись]
+// и возвращает оставшуюся часть сообщения
+func parseAuthorisation(message []byte) (*authorisation, []byte, error) {
+	if len(message) < usecase.ADDR_SIZE+8+1 {
+		return nil, nil, errors.New("authorisation too short")
+	}
+	auth := &authorisation{
+		targetAddr: message[:usecase.ADDR_SIZE],
+		expiry:     int64(binary.LittleEndian.Uint64(message[usecase.ADDR_SIZE : usecase.ADDR_SIZE+8])),
+	}
+	sigSize := int(message[usecase.ADDR_SIZE+8])
+	rest := message[usecase.ADDR_SIZE+8+1:]
+	if len(rest) < sigSize {
+		return nil, nil, errors.New("authorisation too short")
+	}
+	auth.sig = rest[:sigSize]
+	return auth, rest[sigSize:], nil
+}
+
+// Replicate ручка, через которую владелец просит узел отправить чанк напрямую на другой узел.
+//
+// После преамбулы клиент отправляет данные для проверки адреса (как в Store), за которыми следуют
+// разрешение на репликацию (см. parseAuthorisation) и адрес узла-получателя в виде scheme://host:port.
+// Ответ узла-получателя пересылается клиенту без изменений
+func (routes *Routes) Replicate(w http.ResponseWriter, r *http.Request) {
+	// апгрейд соединения и сохранение информации о соединении
+	connection, _ := upgrader.Upgrade(w, r, nil)
+	defer connection.Close()
+	routes.clients[connection] = true
+	defer delete(routes.clients, connection)
+
+	// получение названия файла и проверка длины
+	vars := mux.Vars(r)
+	fileId := vars["fileId"]
+	if !checkFileId(fileId) {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+
+	// сохранение данных, полученных из преамбулы, в соответствующие переменные
+	session, err := routes.executePreamble(connection)
+	if err != nil {
+		log.Printf("ws - replicate - %v\n", err)
+		return
+	}
+	sigSize := session.requestMessage[0]
+	nonce := session.requestMessage[1 : 1+aes.BlockSize]
+	sig := session.requestMessage[1+aes.BlockSize : 1+aes.BlockSize+sigSize]
+	body := session.requestMessage[1+aes.BlockSize+sigSize:]
+
+	// проверка адреса (см. VerifyAddress)
+	err = routes.cryptoUC.VerifyAddress(
+		session.sharedKey,
+		nonce,
+		sig,
+		session.remotePubKey,
+	)
+	if err != nil {
+		log.Printf("ws - replicate - %v\n", err)
+		return
+	}
+
+	// получаем адрес из публичного ключа ЭП
+	remoteAddr := routes.cryptoUC.GetAddress(session.remotePubKey)
+
+	// разбор разрешения и адреса узла-получателя
+	auth, target, err := parseAuthorisation(body)
+	if err != nil {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+	targetURL, err := url.Parse(string(target))
+	if err != nil || (targetURL.Scheme != "ws" && targetURL.Scheme != "wss") || targetURL.Host == "" {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+
+	// проверка на то, что файл существует
+	if !routes.storageUC.CheckExistence(fileId) {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x94})
+		return
+	}
+
+	// чтение файла из файловой системы устройства (внутри метода идет проверка адреса)
+	contents, err := routes.storageUC.ReadFile(fileId, remoteAddr)
+	if err != nil {
+		log.Printf("ws - replicate - %v\n", err)
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x93})
+		return
+	}
+
+	// отправка чанка на узел-получатель
+	response, err := routes.pushToNode(
+		targetURL,
+		fileId,
+		session.remotePubKey,
+		auth,
+		routes.storageUC.GetFileContents(contents),
+	)
+	if err != nil {
+		log.Printf("ws - replicate - %v\n", err)
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0xf6})
+		return
+	}
+
+	// пересылка ответа узла-получателя
+	err = connection.WriteMessage(websocket.BinaryMessage, response)
+	if err != nil {
+		log.Printf("ws - replicate - %v\n", err)
+		return
+	}
+}
+
+// pushToNode отправляет чанк вместе с разрешением владельца на узел target
+// и возвращает его ответ
+func (routes *Routes) pushToNode(target *url.URL, fileId string, ownerPubKey []byte, auth *authorisation, body []byte) ([]byte, error) {
+	u := url.URL{Scheme: target.Scheme, Host: target.Host, Path: fmt.Sprintf("/push/%s", fileId)}
+	pushURL, err := url.PathUnescape(u.String())
+	if err != nil {
+		return nil, err
+	}
+	dialer := *websocket.DefaultDialer
+	if target.Scheme == "wss" {
+		dialer.TLSClientConfig = nodeTLSConfig(target.Hostname(), func(rawCerts [][]byte) error {
+			return routes.cryptoUC.VerifyNodeCertificate(rawCerts, auth.targetAddr)
+		})
+	}
+	conn, _, err := dialer.Dial(pushURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	msg := make([]byte, 0, 2+len(ownerPubKey)+8+1+len(auth.sig)+len(body))
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(len(ownerPubKey)))
+	msg = append(msg, ownerPubKey...)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(auth.expiry))
+	msg = append(msg, byte(len(auth.sig)))
+	msg = append(msg, auth.sig...)
+	msg = append(msg, body...)
+	if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		return nil, err
+	}
+
+	mt, message, err := conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if mt != websocket.BinaryMessage {
+		return nil, fmt.Errorf("wrong message type received: %d", mt)
+	}
+	return message, nil
+}
+
+// Push ручка, принимающая чанк от другого узла.
+//
+// Сообщение имеет вид
+// [2 байта длина ключа, little endian][публичный ключ владельца][8 байт expiry][1 байт длина подписи][подпись][тело чанка].
+// Узел проверяет подпись владельца (см. VerifyReplication), срок действия разрешения
+// и то, что хэш тела совпадает с ID чанка
+func (routes *Routes) Push(w http.ResponseWriter, r *http.Request) {
+	// апгрейд соединения и сохранение информации о соединении
+	connection, _ := upgrader.Upgrade(w, r, nil)
+	defer connection.Close()
+	routes.clients[connection] = true
+	defer delete(routes.clients, connection)
+
+	// получение названия файла и проверка длины
+	vars := mux.Vars(r)
+	fileId := vars["fileId"]
+	if !checkFileId(fileId) {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+
+	mt, message, err := connection.ReadMessage()
+	if err != nil {
+		log.Printf("ws - push - %v\n", err)
+		return
+	}
+	if mt != websocket.BinaryMessage || len(message) < 2 {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+
+	// разбор сообщения
+	pubKeySize := int(binary.LittleEndian.Uint16(message[:2]))
+	if len(message) < 2+pubKeySize+8+1 {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+	ownerPubKey := message[2 : 2+pubKeySize]
+	expiry := int64(binary.LittleEndian.Uint64(message[2+pubKeySize : 2+pubKeySize+8]))
+	sigSize := int(message[2+pubKeySize+8])
+	rest := message[2+pubKeySize+8+1:]
+	if len(rest) < sigSize {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+	sig := rest[:sigSize]
+	body := rest[sigSize:]
+
+	// проверка срока действия и подписи разрешения
+	chunkId, _ := hex.DecodeString(fileId)
+	if time.Now().Unix() > expiry {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x91})
+		return
+	}
+	err = routes.cryptoUC.VerifyReplication(ownerPubKey, chunkId, routes.addr, expiry, sig)
+	if err != nil {
+		log.Printf("ws - push - %v\n", err)
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x91})
+		return
+	}
+
+	// проверка того, что содержимое соответствует ID чанка
+	if !bytes.Equal(routes.cryptoUC.Hash(body), chunkId) {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x90})
+		return
+	}
+
+	// проверка на то, что файл может быть сохранён
+	ownerAddr := routes.cryptoUC.GetAddress(ownerPubKey)
+	if !routes.storageUC.CanBeStored(fileId, ownerAddr) {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x93})
+		return
+	}
+
+	// сохранение файла
+	err = routes.storageUC.StoreFile(fileId, ownerAddr, body)
+	if err != nil {
+		log.Printf("ws - push - %v\n", err)
+		return
+	}
+
+	// отправка сообщения об успехе
+	err = connection.WriteMessage(
+		websocket.BinaryMessage,
+		[]byte{0xc8},
+	)
+	if err != nil {
+		log.Printf("ws - push - %v\n", err)
+		return
+	}
+}
+
+// nodeTLSConfig возвращает настройки TLS для подключения к другому узлу.
+// Сертификат принимается, если он выпущен на ключ узла (verifyIdentity),
+// либо если он проходит обычную проверку цепочки до системных корневых сертификатов
+func nodeTLSConfig(host string, verifyIdentity func(rawCerts [][]byte) error) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// стандартная проверка заменена на VerifyPeerCertificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			identityErr := verifyIdentity(rawCerts)
+			if identityErr == nil {
+				return nil
+			}
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+			if len(certs) == 0 {
+				return identityErr
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+			if _, err := certs[0].Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates}); err != nil {
+				return fmt.Errorf("%v; %v", identityErr, err)
+			}
+			return nil
+		},
+	}
+}
@@ -3,6 +3,7 @@ package ws
 import (
 	"crypto/aes"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"github.com/gorilla/mux"
@@ -12,6 +13,15 @@ import (
 	"net/http"
 )
 
+const (
+	listBatchSize = 1024
+	listEntrySize = usecase.CHUNK_ID_SIZE + 8
+
+	// LIST_FORMAT_MTIME запрашивает список, в котором после размера чанка идет время его изменения
+	LIST_FORMAT_MTIME  = 0x01
+	listMtimeEntrySize = listEntrySize + 8
+)
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
@@ -23,6 +33,7 @@ type Routes struct {
 	clients   map[*websocket.Conn]bool
 	cryptoUC  usecase.Crypto
 	storageUC usecase.Storage
+	addr      []byte
 }
 
 // sessionInfo - служебная структура, используемая как возвращаемое знаение функции преамбулы
@@ -32,17 +43,23 @@ type sessionInfo struct {
 	remotePubKey   []byte
 }
 
-// RegisterRoutes инициализирует все ручки апи демона
-func RegisterRoutes(c usecase.Crypto, s usecase.Storage) *mux.Router {
+// RegisterRoutes инициализирует все ручки апи демона.
+// addr - адрес самого узла, нужен для проверки разрешений на репликацию
+func RegisterRoutes(c usecase.Crypto, s usecase.Storage, addr []byte) *mux.Router {
 	routes := Routes{
 		clients:   make(map[*websocket.Conn]bool),
 		cryptoUC:  c,
 		storageUC: s,
+		addr:      addr,
 	}
 	r := mux.NewRouter()
 	r.HandleFunc("/store/{fileId}", routes.Store).Methods("GET", "POST")
 	r.HandleFunc("/get/{fileId}", routes.Get).Methods("GET", "POST")
 	r.HandleFunc("/delete/{fileId}", routes.Delete).Methods("GET", "POST")
+	r.HandleFunc("/list", routes.List).Methods("GET", "POST")
+	r.HandleFunc("/replicate/{fileId}", routes.Replicate).Methods("GET", "POST")
+	r.HandleFunc("/push/{fileId}", routes.Push).Methods("GET", "POST")
+	r.HandleFunc("/handover", routes.Handover).Methods("GET", "POST")
 	return r
 }
 
@@ -127,7 +144,7 @@ func (routes *Routes) Store(w http.ResponseWriter, r *http.Request) {
 		session.remotePubKey,
 	)
 	if err != nil {
-		log.Printf("ws - store - %w\n", err)
+		log.Printf("ws - store - %v\n", err)
 		return
 	}
 
@@ -147,7 +164,7 @@ func (routes *Routes) Store(w http.ResponseWriter, r *http.Request) {
 		body,
 	)
 	if err != nil {
-		log.Printf("ws - store - %w\n", err)
+		log.Printf("ws - store - %v\n", err)
 		return
 	}
 
@@ -156,7 +173,7 @@ func (routes *Routes) Store(w http.ResponseWriter, r *http.Request) {
 		websocket.BinaryMessage,
 		[]byte{0xc8})
 	if err != nil {
-		log.Printf("ws - store - %w\n", err)
+		log.Printf("ws - store - %v\n", err)
 		return
 	}
 }
@@ -180,7 +197,7 @@ func (routes *Routes) Get(w http.ResponseWriter, r *http.Request) {
 	// сохранение данных, полученных из преамбулы, в соответствующие переменные
 	session, err := routes.executePreamble(connection)
 	if err != nil {
-		log.Printf("ws - get - %w\n", err)
+		log.Printf("ws - get - %v\n", err)
 		return
 	}
 	sigSize := session.requestMessage[0]
@@ -195,7 +212,7 @@ func (routes *Routes) Get(w http.ResponseWriter, r *http.Request) {
 		session.remotePubKey,
 	)
 	if err != nil {
-		log.Printf("ws - get - %w\n", err)
+		log.Printf("ws - get - %v\n", err)
 		return
 	}
 
@@ -211,7 +228,7 @@ func (routes *Routes) Get(w http.ResponseWriter, r *http.Request) {
 	// чтение файл из файловой системы устройства (внутри метода идет проверка адреса)
 	contents, err := routes.storageUC.ReadFile(fileId, remoteAddr)
 	if err != nil {
-		log.Printf("ws - get - %w\n", err)
+		log.Printf("ws - get - %v\n", err)
 		return
 	}
 
@@ -220,7 +237,7 @@ func (routes *Routes) Get(w http.ResponseWriter, r *http.Request) {
 		websocket.BinaryMessage,
 		routes.storageUC.GetFileContents(contents))
 	if err != nil {
-		log.Printf("ws - get - %w\n", err)
+		log.Printf("ws - get - %v\n", err)
 		return
 	}
 }
@@ -244,7 +261,7 @@ func (routes *Routes) Delete(w http.ResponseWriter, r *http.Request) {
 	// сохранение данных, полученных из преамбулы, в соответствующие переменные
 	session, err := routes.executePreamble(connection)
 	if err != nil {
-		log.Printf("ws - delete - %w\n", err)
+		log.Printf("ws - delete - %v\n", err)
 		return
 	}
 	sigSize := session.requestMessage[0]
@@ -259,7 +276,7 @@ func (routes *Routes) Delete(w http.ResponseWriter, r *http.Request) {
 		session.remotePubKey,
 	)
 	if err != nil {
-		log.Printf("ws - delete - %w\n", err)
+		log.Printf("ws - delete - %v\n", err)
 		return
 	}
 
@@ -275,7 +292,7 @@ func (routes *Routes) Delete(w http.ResponseWriter, r *http.Request) {
 	// удаление файла из файловой системы устройства (внутри метода идет проверка адреса)
 	err = routes.storageUC.DeleteFile(fileId, remoteAddr)
 	if err != nil {
-		log.Printf("ws - delete - %w\n", err)
+		log.Printf("ws - delete - %v\n", err)
 		return
 	}
 
@@ -285,7 +302,90 @@ func (routes *Routes) Delete(w http.ResponseWriter, r *http.Request) {
 		[]byte{0xcc},
 	)
 	if err != nil {
-		log.Printf("ws - delete - %w\n", err)
+		log.Printf("ws - delete - %v\n", err)
+		return
+	}
+}
+
+// List отправляет список чанков, принадлежащих клиенту, вместе с их размерами.
+//
+// Список передается несколькими сообщениями, каждое из которых содержит
+// до listBatchSize записей вида [32 байта ID чанка][8 байт размера, little endian].
+// Конец списка обозначается сообщением 0xc8.
+//
+// Если после данных для проверки адреса клиент отправил байт LIST_FORMAT_MTIME, к каждой записи
+// добавляется время изменения чанка (8 байт unix-времени, little endian), а конец списка
+// обозначается сообщением 0xc8 0x01, так клиент отличает такой список от списка старых узлов
+func (routes *Routes) List(w http.ResponseWriter, r *http.Request) {
+	// апгрейд соединения и сохранение информации о соединении
+	connection, _ := upgrader.Upgrade(w, r, nil)
+	defer connection.Close()
+	routes.clients[connection] = true
+	defer delete(routes.clients, connection)
+
+	// сохранение данных, полученных из преамбулы, в соответствующие переменные
+	session, err := routes.executePreamble(connection)
+	if err != nil {
+		log.Printf("ws - list - %v\n", err)
+		return
+	}
+	sigSize := session.requestMessage[0]
+	nonce := session.requestMessage[1 : 1+aes.BlockSize]
+	sig := session.requestMessage[1+aes.BlockSize : 1+aes.BlockSize+sigSize]
+	body := session.requestMessage[1+aes.BlockSize+sigSize:]
+
+	// проверка адреса (см. VerifyAddress)
+	err = routes.cryptoUC.VerifyAddress(
+		session.sharedKey,
+		nonce,
+		sig,
+		session.remotePubKey,
+	)
+	if err != nil {
+		log.Printf("ws - list - %v\n", err)
+		return
+	}
+	withMtime := len(body) > 0 && body[0] == LIST_FORMAT_MTIME
+	entrySize := listEntrySize
+	end := []byte{0xc8}
+	if withMtime {
+		entrySize = listMtimeEntrySize
+		end = []byte{0xc8, LIST_FORMAT_MTIME}
+	}
+
+	// получаем адрес из публичного ключа ЭП
+	remoteAddr := routes.cryptoUC.GetAddress(session.remotePubKey)
+
+	// отправка списка чанков пачками
+	chunks := routes.storageUC.ListChunks(remoteAddr)
+	for start := 0; start < len(chunks); start += listBatchSize {
+		stop := min(start+listBatchSize, len(chunks))
+		msg := make([]byte, 0, (stop-start)*entrySize)
+		for _, chunk := range chunks[start:stop] {
+			id, err := hex.DecodeString(chunk.ID)
+			if err != nil {
+				log.Printf("ws - list - %v\n", err)
+				return
+			}
+			msg = append(msg, id...)
+			msg = binary.LittleEndian.AppendUint64(msg, uint64(chunk.Size))
+			if withMtime {
+				msg = binary.LittleEndian.AppendUint64(msg, uint64(chunk.ModTime))
+			}
+		}
+		if err := connection.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+			log.Printf("ws - list - %v\n", err)
+			return
+		}
+	}
+
+	// отправка сообщения об окончании списка
+	err = connection.WriteMessage(
+		websocket.BinaryMessage,
+		end,
+	)
+	if err != nil {
+		log.Printf("ws - list - %v\n", err)
 		return
 	}
 }
@@ -0,0 +1,33 @@
+package entity
+
+// Announcement - информация об узле, которую он сообщает трекеру при подключении и на каждом heartbeat.
+//
+// Пустой Host означает, что трекер должен использовать адрес, с которого пришло подключение.
+// CertFingerprint - sha256 от TLS-сертификата узла, клиенты сверяют его при подключении по wss.
+// Region, Zone и HostID - метки доменов отказа, клиенты стараются не класть реплики в один домен.
+// Ёмкость указывается в байтах, Uptime - в секундах, Timestamp - unix-время создания анонса
+type Announcement struct {
+	Addr            string `json:"addr"`
+	Host            string `json:"host"`
+	Port            string `json:"port"`
+	Scheme          string `json:"scheme"`
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+	Version         string `json:"version"`
+	ProtocolVersion int    `json:"protocol_version"`
+	Region          string `json:"region,omitempty"`
+	Zone            string `json:"zone,omitempty"`
+	HostID          string `json:"host_id,omitempty"`
+	TotalCapacity   uint64 `json:"total_capacity"`
+	FreeCapacity    uint64 `json:"free_capacity"`
+	Uptime          int64  `json:"uptime"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+// SignedAnnouncement - анонс вместе с подписью ключа узла.
+// Подписываются ровно те байты, что лежат в Announcement (JSON), поэтому они передаются в base64,
+// а не вложенным объектом, который при повторной сериализации может измениться
+type SignedAnnouncement struct {
+	Announcement []byte `json:"announcement"`
+	PubKey       string `json:"pub_key"`
+	Signature    string `json:"signature"`
+}
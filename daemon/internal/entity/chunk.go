@@ -0,0 +1,9 @@
+package entity
+
+// ChunkInfo описывает чанк, хранящийся на устройстве
+type ChunkInfo struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+	// ModTime время последнего изменения файла чанка (unix-время в секундах)
+	ModTime int64 `json:"mtime"`
+}
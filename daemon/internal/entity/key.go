@@ -0,0 +1,6 @@
+package entity
+
+// NodeKey - ключ, из которого выводится адрес узла
+type NodeKey struct {
+	EcdsaKey string `json:"ecdsaKey"`
+}
@@ -0,0 +1,73 @@
+package usecase
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"github.com/s1lur/distorage/daemon/internal/entity"
+	"log"
+	"sync"
+	"time"
+)
+
+// AnnouncerUC собирает актуальный анонс узла и подписывает его ключом узла
+type AnnouncerUC struct {
+	cryptoUC  Crypto
+	storageUC Storage
+	key       *ecdsa.PrivateKey
+	base      entity.Announcement
+	capacity  uint64
+	started   time.Time
+
+	// ошибка определения свободного места пишется в лог один раз, а не на каждый heartbeat
+	diskUsageWarning sync.Once
+}
+
+// NewAnnouncerUC создает экземпляр AnnouncerUC.
+// base содержит неизменяемые поля анонса (адрес, хост, версию и т.д.),
+// capacity - сколько байт узел готов отдать под хранилище (0 - весь диск)
+func NewAnnouncerUC(c Crypto, s Storage, key *ecdsa.PrivateKey, base entity.Announcement, capacity uint64) *AnnouncerUC {
+	return &AnnouncerUC{
+		cryptoUC:  c,
+		storageUC: s,
+		key:       key,
+		base:      base,
+		capacity:  capacity,
+		started:   time.Now(),
+	}
+}
+
+// Announce возвращает подписанный анонс с текущими ёмкостью, свободным местом и uptime
+func (a *AnnouncerUC) Announce() (*entity.SignedAnnouncement, error) {
+	announcement := a.base
+	announcement.Uptime = int64(time.Since(a.started).Seconds())
+	announcement.Timestamp = time.Now().Unix()
+
+	// без данных о диске узел анонсируется без ёмкости (или только с лимитом из конфига),
+	// иначе он не смог бы подключиться к трекерам и DHT
+	usage, err := a.storageUC.DiskUsage()
+	if err != nil {
+		a.diskUsageWarning.Do(func() {
+			log.Printf("usecase - Announce - disk usage: %v, announcing without it\n", err)
+		})
+		usage.Total = a.capacity
+		usage.Free = a.capacity
+	}
+	announcement.TotalCapacity = usage.Total
+	announcement.FreeCapacity = usage.Free
+	// если ёмкость ограничена в конфиге, свободное место считается от лимита
+	if a.capacity > 0 && a.capacity <= usage.Total {
+		announcement.TotalCapacity = a.capacity
+		used := a.storageUC.UsedSpace()
+		if used >= a.capacity {
+			announcement.FreeCapacity = 0
+		} else {
+			announcement.FreeCapacity = min(usage.Free, a.capacity-used)
+		}
+	}
+
+	announcementBytes, err := json.Marshal(announcement)
+	if err != nil {
+		return nil, err
+	}
+	return a.cryptoUC.SignAnnouncement(a.key, announcementBytes)
+}
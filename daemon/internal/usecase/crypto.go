@@ -1,16 +1,44 @@
 package usecase
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/s1lur/distorage/daemon/internal/entity"
 	"github.com/wealdtech/go-merkletree/keccak256"
+	"math/big"
+	"os"
+	"time"
 )
 
+// REPLICATION_PREFIX добавляется в начало подписываемого разрешения на репликацию,
+// чтобы его нельзя было спутать с другими подписями владельца
+const REPLICATION_PREFIX = "distorage-replicate"
+
+// HANDOVER_PREFIX добавляется в начало подписываемой передачи чанков новому адресу владельца
+const HANDOVER_PREFIX = "distorage-handover"
+
+// CHALLENGE_PREFIX добавляется в начало подписываемого challenge'а от трекера
+const CHALLENGE_PREFIX = "distorage-tracker-challenge"
+
+// ANNOUNCEMENT_PREFIX добавляется в начало подписываемого анонса узла
+const ANNOUNCEMENT_PREFIX = "distorage-announcement"
+
+// CERT_VALIDITY - срок действия самоподписанного сертификата узла
+const CERT_VALIDITY = 365 * 24 * time.Hour
+
 // CryptoUC структура, методы которой отвечают за криптиграфию
 // (генерацию ключей, проверку ЭП и т.д.)
 type CryptoUC struct {
@@ -89,6 +117,230 @@ func (c *CryptoUC) VerifyAddress(aesKey []byte, nonce []byte, sig []byte, pubKey
 
 // GetAddress получает адрес клиента из предоставленного публичного ключа
 func (c *CryptoUC) GetAddress(pubKeyBytes []byte) []byte {
+	return c.Hash(pubKeyBytes)[12:]
+}
+
+// Hash вычисляет keccak256 хэш от переданных данных
+func (c *CryptoUC) Hash(contents []byte) []byte {
 	keccak := keccak256.New()
-	return keccak.Hash(pubKeyBytes)[12:]
+	return keccak.Hash(contents)
+}
+
+// VerifyReplication проверяет разрешение владельца чанка на его копирование на другой узел.
+//
+// Владелец подписывает keccak256 хэш от конкатенации
+// REPLICATION_PREFIX, ID чанка, адреса узла-получателя и времени истечения разрешения
+// (unix-время в секундах, 8 байт little endian)
+func (c *CryptoUC) VerifyReplication(pubKeyBytes []byte, chunkId []byte, targetAddr []byte, expiry int64, sig []byte) error {
+	msg := make([]byte, 0, len(REPLICATION_PREFIX)+len(chunkId)+len(targetAddr)+8)
+	msg = append(msg, REPLICATION_PREFIX...)
+	msg = append(msg, chunkId...)
+	msg = append(msg, targetAddr...)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(expiry))
+	return c.verifySignature(pubKeyBytes, c.Hash(msg), sig)
+}
+
+// VerifyHandover проверяет подпись владельца под передачей его чанков адресу newAddr.
+//
+// Владелец подписывает старым ключом keccak256 хэш от конкатенации
+// HANDOVER_PREFIX, старого адреса, нового адреса и времени истечения (8 байт little endian)
+func (c *CryptoUC) VerifyHandover(pubKeyBytes []byte, newAddr []byte, expiry int64, sig []byte) error {
+	oldAddr := c.GetAddress(pubKeyBytes)
+	msg := make([]byte, 0, len(HANDOVER_PREFIX)+len(oldAddr)+len(newAddr)+8)
+	msg = append(msg, HANDOVER_PREFIX...)
+	msg = append(msg, oldAddr...)
+	msg = append(msg, newAddr...)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(expiry))
+	return c.verifySignature(pubKeyBytes, c.Hash(msg), sig)
+}
+
+// LoadOrGenerateKey читает ключ узла из файла, а если файла нет - генерирует новый
+// ключ ECDSA P-256 и сохраняет его с правами 0600
+func (c *CryptoUC) LoadOrGenerateKey(keyPath string) (*ecdsa.PrivateKey, error) {
+	f, err := os.Open(keyPath)
+	if err == nil {
+		defer f.Close()
+		nodeKey := &entity.NodeKey{}
+		if err := json.NewDecoder(f).Decode(nodeKey); err != nil {
+			return nil, err
+		}
+		keyBytes, err := hex.DecodeString(nodeKey.EcdsaKey)
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseECPrivateKey(keyBytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err = os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(f).Encode(entity.NodeKey{EcdsaKey: hex.EncodeToString(keyBytes)}); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+// GetKeyAddress получает адрес узла из его приватного ключа так же, как GetAddress
+func (c *CryptoUC) GetKeyAddress(key *ecdsa.PrivateKey) ([]byte, error) {
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetAddress(pubKeyBytes), nil
+}
+
+// SignChallenge доказывает трекеру владение адресом.
+//
+// Ответ имеет вид [1 байт длина ключа][публичный ключ в формате PKIX][подпись],
+// где подпись ставится на keccak256 хэш от CHALLENGE_PREFIX и challenge'а
+func (c *CryptoUC) SignChallenge(key *ecdsa.PrivateKey, challenge []byte) ([]byte, error) {
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(CHALLENGE_PREFIX)+len(challenge))
+	msg = append(msg, CHALLENGE_PREFIX...)
+	msg = append(msg, challenge...)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, c.Hash(msg))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]byte, 0, 1+len(pubKeyBytes)+len(sig))
+	res = append(res, byte(len(pubKeyBytes)))
+	res = append(res, pubKeyBytes...)
+	res = append(res, sig...)
+	return res, nil
+}
+
+// SignAnnouncement подписывает сериализованный анонс узла.
+// Подпись ставится на keccak256 хэш от ANNOUNCEMENT_PREFIX и анонса
+func (c *CryptoUC) SignAnnouncement(key *ecdsa.PrivateKey, announcement []byte) (*entity.SignedAnnouncement, error) {
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(ANNOUNCEMENT_PREFIX)+len(announcement))
+	msg = append(msg, ANNOUNCEMENT_PREFIX...)
+	msg = append(msg, announcement...)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, c.Hash(msg))
+	if err != nil {
+		return nil, err
+	}
+	return &entity.SignedAnnouncement{
+		Announcement: announcement,
+		PubKey:       hex.EncodeToString(pubKeyBytes),
+		Signature:    hex.EncodeToString(sig),
+	}, nil
+}
+
+// VerifyAnnouncement проверяет подпись анонса другого узла (см. SignAnnouncement)
+// и то, что адрес в анонсе совпадает с адресом ключа подписи
+func (c *CryptoUC) VerifyAnnouncement(signed *entity.SignedAnnouncement) (*entity.Announcement, error) {
+	pubKeyBytes, err := hex.DecodeString(signed.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(ANNOUNCEMENT_PREFIX)+len(signed.Announcement))
+	msg = append(msg, ANNOUNCEMENT_PREFIX...)
+	msg = append(msg, signed.Announcement...)
+	if err := c.verifySignature(pubKeyBytes, c.Hash(msg), sig); err != nil {
+		return nil, err
+	}
+	announcement := &entity.Announcement{}
+	if err := json.Unmarshal(signed.Announcement, announcement); err != nil {
+		return nil, err
+	}
+	if announcement.Addr != hex.EncodeToString(c.GetAddress(pubKeyBytes)) {
+		return nil, errors.New("announcement address mismatch")
+	}
+	return announcement, nil
+}
+
+// SelfSignedCertificate создает самоподписанный TLS-сертификат на ключе узла.
+// Клиент проверяет такой сертификат, сравнивая адрес, полученный из его публичного ключа,
+// с адресом узла (см. VerifyNodeCertificate)
+func (c *CryptoUC) SelfSignedCertificate(key *ecdsa.PrivateKey) (tls.Certificate, error) {
+	addr, err := c.GetKeyAddress(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hex.EncodeToString(addr)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(CERT_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// CertificateFingerprint возвращает hex от sha256 листового сертификата
+func (c *CryptoUC) CertificateFingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	fingerprint := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// VerifyNodeCertificate проверяет, что сертификат выпущен на ключ узла с адресом addr
+func (c *CryptoUC) VerifyNodeCertificate(rawCerts [][]byte, addr []byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no certificate presented")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(c.GetAddress(pubKeyBytes), addr) {
+		return errors.New("certificate is not bound to the node address")
+	}
+	return nil
+}
+
+// verifySignature проверяет ECDSA подпись хэша публичным ключом в формате PKIX
+func (c *CryptoUC) verifySignature(pubKeyBytes []byte, hash []byte, sig []byte) error {
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return err
+	}
+	switch v := pubKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(v, hash, sig) {
+			return errors.New("signature check failed")
+		}
+	default:
+		return fmt.Errorf("recived wrong key type: %T", v)
+	}
+	return nil
 }
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"github.com/s1lur/distorage/daemon/internal/entity"
+	"github.com/s1lur/distorage/daemon/pkg/diskusage"
 	"hash/crc32"
+	"log"
 	"os"
 	"path"
 )
@@ -13,6 +16,8 @@ const (
 	MAGIC_SIZE = 2
 	CRC32_SIZE = 4
 	ADDR_SIZE  = 20
+
+	CHUNK_ID_SIZE = 32
 )
 
 var MAGIC = [2]byte{0xd1, 0x57}
@@ -20,11 +25,16 @@ var MAGIC = [2]byte{0xd1, 0x57}
 // StorageUC это юзкейс для работы с файловой системой
 type StorageUC struct {
 	basePath string
+	index    *ownerIndex
 }
 
-// NewStorageUC создает экземпляр StorageUC для дальнейшей работы
-func NewStorageUC(basePath string) *StorageUC {
-	return &StorageUC{basePath: basePath}
+// NewStorageUC создает экземпляр StorageUC для дальнейшей работы.
+// indexPath - путь до файла с индексом "владелец -> чанки"
+func NewStorageUC(basePath string, indexPath string) *StorageUC {
+	return &StorageUC{
+		basePath: basePath,
+		index:    newOwnerIndex(indexPath),
+	}
 }
 
 // VerifyFile проверяет целостность переданного содержимого файла
@@ -78,6 +88,20 @@ func (f *StorageUC) ReadFile(fileName string, addr []byte) ([]byte, error) {
 	return contents, nil
 }
 
+// encodeFile дописывает к содержимому служебную информацию: magic, адрес владельца и CRC32
+func encodeFile(addr []byte, contents []byte) ([]byte, error) {
+	fileContents := make([]byte, MAGIC_SIZE+ADDR_SIZE+len(contents))
+	copy(fileContents[:MAGIC_SIZE], MAGIC[:])
+	copy(fileContents[MAGIC_SIZE:MAGIC_SIZE+ADDR_SIZE], addr)
+	copy(fileContents[MAGIC_SIZE+ADDR_SIZE:], contents)
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(fileContents))
+	if err != nil {
+		return nil, err
+	}
+	return append(fileContents, buf.Bytes()...), nil
+}
+
 // StoreFile сохраняет файл в файловую систему устройства и дописывает в него служебную информацию
 func (f *StorageUC) StoreFile(fileName string, addr []byte, contents []byte) error {
 	// создание файла для записи
@@ -88,20 +112,54 @@ func (f *StorageUC) StoreFile(fileName string, addr []byte, contents []byte) err
 	}
 
 	// запись служебной информации
-	fileContents := make([]byte, MAGIC_SIZE+ADDR_SIZE+len(contents))
-	copy(fileContents[:MAGIC_SIZE], MAGIC[:])
-	copy(fileContents[MAGIC_SIZE:MAGIC_SIZE+ADDR_SIZE], addr)
-	copy(fileContents[MAGIC_SIZE+ADDR_SIZE:], contents)
-	buf := new(bytes.Buffer)
-	err = binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(fileContents))
+	fileContents, err := encodeFile(addr, contents)
 	if err != nil {
 		return err
 	}
-	fileContents = append(fileContents, buf.Bytes()...)
 
 	// запись файла в файловую систему устройства
-	_, err = file.Write(fileContents)
-	return err
+	if _, err = file.Write(fileContents); err != nil {
+		return err
+	}
+	f.index.add(addr, fileName, int64(len(contents)))
+	return nil
+}
+
+// transferFile передает файл новому владельцу: заголовок переписывается с адресом newAddr.
+// Файл заменяется атомарно (через временный файл и rename), чтобы чанк не потерялся при сбое
+func (f *StorageUC) transferFile(fileName string, oldAddr []byte, newAddr []byte) error {
+	contents, err := f.ReadFile(fileName, oldAddr)
+	if err != nil {
+		return err
+	}
+	fileContents, err := encodeFile(newAddr, f.GetFileContents(contents))
+	if err != nil {
+		return err
+	}
+	tmpPath := path.Join(f.basePath, fileName+".tmp")
+	if err := os.WriteFile(tmpPath, fileContents, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path.Join(f.basePath, fileName)); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// TransferFiles передает все файлы владельца oldAddr новому владельцу newAddr и возвращает,
+// сколько файлов передано. Файлы, которые не удалось передать, остаются у старого владельца
+func (f *StorageUC) TransferFiles(oldAddr []byte, newAddr []byte) int {
+	moved := make([]string, 0)
+	for _, chunk := range f.index.list(oldAddr) {
+		if err := f.transferFile(chunk.ID, oldAddr, newAddr); err != nil {
+			log.Printf("usecase - TransferFiles - %s: %v\n", chunk.ID, err)
+			continue
+		}
+		moved = append(moved, chunk.ID)
+	}
+	f.index.moveAll(oldAddr, newAddr, moved)
+	return len(moved)
 }
 
 // DeleteFile удаляет файл из файловой системы устройства, предварительно проверяя его целостность
@@ -113,7 +171,11 @@ func (f *StorageUC) DeleteFile(fileName string, addr []byte) error {
 	if err := f.VerifyFile(contents, addr); err != nil {
 		return err
 	}
-	return os.Remove(path.Join(f.basePath, fileName))
+	if err := os.Remove(path.Join(f.basePath, fileName)); err != nil {
+		return err
+	}
+	f.index.remove(addr, fileName)
+	return nil
 }
 
 // GetAddress получает адрес клиента, записанный в файле
@@ -141,3 +203,45 @@ func (f *StorageUC) CanBeStored(fileName string, addr []byte) bool {
 	_, err := f.ReadFile(fileName, addr)
 	return err == nil
 }
+
+// ListChunks возвращает список чанков, принадлежащих адресу, вместе с их размерами и временем изменения.
+// Чанки, файлов которых уже нет на диске, в список не попадают
+func (f *StorageUC) ListChunks(addr []byte) []entity.ChunkInfo {
+	chunks := f.index.list(addr)
+	listed := make([]entity.ChunkInfo, 0, len(chunks))
+	for _, chunk := range chunks {
+		stat, err := os.Stat(path.Join(f.basePath, chunk.ID))
+		if err != nil {
+			continue
+		}
+		chunk.ModTime = stat.ModTime().Unix()
+		listed = append(listed, chunk)
+	}
+	return listed
+}
+
+// ListAllChunks возвращает ID всех хранящихся чанков независимо от владельца
+func (f *StorageUC) ListAllChunks() []string {
+	return f.index.chunks()
+}
+
+// UsedSpace возвращает суммарный размер хранящихся чанков
+func (f *StorageUC) UsedSpace() uint64 {
+	return f.index.size()
+}
+
+// DiskUsage возвращает размер и свободное место файловой системы хранилища
+func (f *StorageUC) DiskUsage() (diskusage.Usage, error) {
+	return diskusage.Get(f.basePath)
+}
+
+// LoadIndex читает индекс "владелец -> чанки", сохраненный при остановке, или перестраивает его
+// по заголовкам хранящихся файлов
+func (f *StorageUC) LoadIndex() error {
+	return f.index.load(f.basePath)
+}
+
+// SaveIndex записывает индекс "владелец -> чанки" на диск, вызывается при остановке
+func (f *StorageUC) SaveIndex() error {
+	return f.index.save()
+}
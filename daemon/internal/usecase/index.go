@@ -0,0 +1,238 @@
+package usecase
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/s1lur/distorage/daemon/internal/entity"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"sync"
+)
+
+// ownerIndex хранит соответствие "адрес владельца -> принадлежащие ему чанки".
+// Индекс записывается на диск при остановке демона и читается при запуске,
+// чтобы не перечитывать заголовки всех файлов
+type ownerIndex struct {
+	mu     sync.RWMutex
+	path   string
+	owners map[string]map[string]int64
+}
+
+func newOwnerIndex(indexPath string) *ownerIndex {
+	return &ownerIndex{
+		path:   indexPath,
+		owners: make(map[string]map[string]int64),
+	}
+}
+
+// add добавляет чанк в индекс
+func (i *ownerIndex) add(addr []byte, fileName string, size int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	owner := hex.EncodeToString(addr)
+	if _, ok := i.owners[owner]; !ok {
+		i.owners[owner] = make(map[string]int64)
+	}
+	i.owners[owner][fileName] = size
+}
+
+// remove удаляет чанк из индекса
+func (i *ownerIndex) remove(addr []byte, fileName string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	owner := hex.EncodeToString(addr)
+	delete(i.owners[owner], fileName)
+	if len(i.owners[owner]) == 0 {
+		delete(i.owners, owner)
+	}
+}
+
+// moveAll переносит чанки от одного владельца к другому
+func (i *ownerIndex) moveAll(oldAddr []byte, newAddr []byte, fileNames []string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	oldOwner := hex.EncodeToString(oldAddr)
+	newOwner := hex.EncodeToString(newAddr)
+	for _, fileName := range fileNames {
+		size, ok := i.owners[oldOwner][fileName]
+		if !ok {
+			continue
+		}
+		delete(i.owners[oldOwner], fileName)
+		if _, ok := i.owners[newOwner]; !ok {
+			i.owners[newOwner] = make(map[string]int64)
+		}
+		i.owners[newOwner][fileName] = size
+	}
+	if len(i.owners[oldOwner]) == 0 {
+		delete(i.owners, oldOwner)
+	}
+}
+
+// list возвращает отсортированный по ID список чанков владельца
+func (i *ownerIndex) list(addr []byte) []entity.ChunkInfo {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	chunks := make([]entity.ChunkInfo, 0, len(i.owners[hex.EncodeToString(addr)]))
+	for id, size := range i.owners[hex.EncodeToString(addr)] {
+		chunks = append(chunks, entity.ChunkInfo{ID: id, Size: size})
+	}
+	sort.Slice(chunks, func(a, b int) bool { return chunks[a].ID < chunks[b].ID })
+	return chunks
+}
+
+// size возвращает суммарный размер всех чанков в индексе
+func (i *ownerIndex) size() uint64 {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var total uint64
+	for _, chunks := range i.owners {
+		for _, size := range chunks {
+			total += uint64(size)
+		}
+	}
+	return total
+}
+
+// chunks возвращает ID всех хранящихся чанков
+func (i *ownerIndex) chunks() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	ids := make([]string, 0)
+	for _, chunks := range i.owners {
+		for id := range chunks {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// load читает индекс, записанный при остановке демона. Файл удаляется сразу после чтения:
+// после падения демона его не будет, и индекс перестроится по заголовкам файлов.
+// Индекс перестраивается и тогда, когда файл поврежден
+func (i *ownerIndex) load(storePath string) error {
+	owners, err := i.read()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("usecase - ownerIndex - load: %v, rebuilding the index\n", err)
+		}
+		return i.rebuild(storePath)
+	}
+	if err := os.Remove(i.path); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.owners = owners
+	return nil
+}
+
+// read читает индекс с диска
+func (i *ownerIndex) read() (map[string]map[string]int64, error) {
+	file, err := os.Open(i.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	owners := make(map[string]map[string]int64)
+	if err := json.NewDecoder(file).Decode(&owners); err != nil {
+		return nil, err
+	}
+	for owner, chunks := range owners {
+		if _, err := hex.DecodeString(owner); err != nil || len(chunks) == 0 {
+			return nil, errors.New("index is invalid (wrong owner entry)")
+		}
+		for id, size := range chunks {
+			if !isChunkId(id) || size < 0 {
+				return nil, errors.New("index is invalid (wrong chunk entry)")
+			}
+		}
+	}
+	return owners, nil
+}
+
+// rebuild заново строит индекс по заголовкам всех файлов в директории хранилища
+func (i *ownerIndex) rebuild(storePath string) error {
+	entries, err := os.ReadDir(storePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	owners := make(map[string]map[string]int64)
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !isChunkId(e.Name()) {
+			continue
+		}
+		addr, size, err := readHeader(path.Join(storePath, e.Name()))
+		if err != nil {
+			// битые файлы в индекс не попадают, их всё равно нельзя прочитать
+			continue
+		}
+		owner := hex.EncodeToString(addr)
+		if _, ok := owners[owner]; !ok {
+			owners[owner] = make(map[string]int64)
+		}
+		owners[owner][e.Name()] = size
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.owners = owners
+	return nil
+}
+
+// save атомарно записывает индекс на диск (через временный файл и rename)
+func (i *ownerIndex) save() error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	tmpPath := i.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(i.owners); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, i.path)
+}
+
+// readHeader читает из заголовка файла адрес владельца и вычисляет размер тела файла
+func readHeader(filePath string) ([]byte, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if stat.Size() < MAGIC_SIZE+ADDR_SIZE+CRC32_SIZE {
+		return nil, 0, errors.New("file too short")
+	}
+	header := make([]byte, MAGIC_SIZE+ADDR_SIZE)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(header[:MAGIC_SIZE], MAGIC[:]) {
+		return nil, 0, errors.New("file is invalid (wrong magic string)")
+	}
+	return header[MAGIC_SIZE:], stat.Size() - MAGIC_SIZE - ADDR_SIZE - CRC32_SIZE, nil
+}
+
+// isChunkId проверяет, что имя файла является идентификатором чанка (hex от 32 байт)
+func isChunkId(name string) bool {
+	if len(name) != 2*CHUNK_ID_SIZE {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
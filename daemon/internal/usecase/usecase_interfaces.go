@@ -2,6 +2,10 @@ package usecase
 
 import (
 	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"github.com/s1lur/distorage/daemon/internal/entity"
+	"github.com/s1lur/distorage/daemon/pkg/diskusage"
 )
 
 type Crypto interface {
@@ -9,6 +13,17 @@ type Crypto interface {
 	ExecuteECDH(own *ecdh.PrivateKey, remoteBytes []byte) ([]byte, error)
 	VerifyAddress(aesKey []byte, nonce []byte, sig []byte, pubKeyBytes []byte) error
 	GetAddress(pubKeyBytes []byte) []byte
+	Hash(contents []byte) []byte
+	VerifyReplication(pubKeyBytes []byte, chunkId []byte, targetAddr []byte, expiry int64, sig []byte) error
+	VerifyHandover(pubKeyBytes []byte, newAddr []byte, expiry int64, sig []byte) error
+	LoadOrGenerateKey(keyPath string) (*ecdsa.PrivateKey, error)
+	GetKeyAddress(key *ecdsa.PrivateKey) ([]byte, error)
+	SignChallenge(key *ecdsa.PrivateKey, challenge []byte) ([]byte, error)
+	SelfSignedCertificate(key *ecdsa.PrivateKey) (tls.Certificate, error)
+	CertificateFingerprint(cert tls.Certificate) string
+	VerifyNodeCertificate(rawCerts [][]byte, addr []byte) error
+	SignAnnouncement(key *ecdsa.PrivateKey, announcement []byte) (*entity.SignedAnnouncement, error)
+	VerifyAnnouncement(signed *entity.SignedAnnouncement) (*entity.Announcement, error)
 }
 
 type Storage interface {
@@ -16,8 +31,19 @@ type Storage interface {
 	ReadFile(fileName string, addr []byte) ([]byte, error)
 	StoreFile(fileName string, addr []byte, contents []byte) error
 	DeleteFile(fileName string, addr []byte) error
+	TransferFiles(oldAddr []byte, newAddr []byte) int
 	GetAddress(contents []byte) []byte
 	GetFileContents(contents []byte) []byte
 	CheckExistence(fileName string) bool
 	CanBeStored(fileName string, addr []byte) bool
+	ListChunks(addr []byte) []entity.ChunkInfo
+	ListAllChunks() []string
+	LoadIndex() error
+	SaveIndex() error
+	UsedSpace() uint64
+	DiskUsage() (diskusage.Usage, error)
+}
+
+type Announcer interface {
+	Announce() (*entity.SignedAnnouncement, error)
 }
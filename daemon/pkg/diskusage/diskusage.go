@@ -0,0 +1,7 @@
+package diskusage
+
+// Usage - размер файловой системы и свободное на ней место в байтах
+type Usage struct {
+	Total uint64
+	Free  uint64
+}
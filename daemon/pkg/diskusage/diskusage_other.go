@@ -0,0 +1,12 @@
+//go:build !unix
+
+package diskusage
+
+import (
+	"errors"
+)
+
+// Get на этой платформе не поддерживается
+func Get(path string) (Usage, error) {
+	return Usage{}, errors.New("disk usage is not supported on this platform")
+}
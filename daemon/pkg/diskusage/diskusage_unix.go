@@ -0,0 +1,20 @@
+//go:build unix
+
+package diskusage
+
+import (
+	"syscall"
+)
+
+// Get возвращает размер и свободное место файловой системы, на которой находится path.
+// Свободным считается место, доступное непривилегированному пользователю
+func Get(path string) (Usage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		Total: uint64(stat.Blocks) * uint64(stat.Bsize),
+		Free:  uint64(stat.Bavail) * uint64(stat.Bsize),
+	}, nil
+}
@@ -0,0 +1,34 @@
+package kademlia
+
+import (
+	"encoding/json"
+)
+
+// Contact - узел DHT: его идентификатор, адрес вида scheme://host:port
+// и непрозрачная для DHT информация об узле (например, подписанный анонс),
+// по которой Verifier решает, можно ли доверять контакту
+type Contact struct {
+	ID   ID              `json:"id"`
+	URL  string          `json:"url"`
+	Info json.RawMessage `json:"info,omitempty"`
+}
+
+// Request - тело любого RPC. Sender пуст, если запрос отправлен клиентом, а не узлом DHT
+type Request struct {
+	Sender Contact `json:"sender"`
+	Target ID      `json:"target"`
+}
+
+// Response - ответ на RPC: ближайшие к Target контакты и известные поставщики ключа
+type Response struct {
+	Sender    Contact   `json:"sender"`
+	Contacts  []Contact `json:"contacts,omitempty"`
+	Providers []Contact `json:"providers,omitempty"`
+}
+
+const (
+	RPCPing          = "ping"
+	RPCFindNode      = "find_node"
+	RPCFindProviders = "find_providers"
+	RPCAddProvider   = "add_provider"
+)
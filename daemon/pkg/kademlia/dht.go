@@ -0,0 +1,282 @@
+package kademlia
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultK               = 20
+	_defaultAlpha           = 3
+	_defaultTimeout         = 5 * time.Second
+	_defaultProviderTTL     = 2 * time.Hour
+	_defaultRefreshInterval = 15 * time.Minute
+	_minBootstrapDelay      = time.Second
+	_maxBootstrapDelay      = time.Minute
+	_maxProvidersPerKey     = 64
+)
+
+var (
+	ErrEmptyTable   = errors.New("routing table is empty")
+	ErrUnverified   = errors.New("contact verification failed")
+	ErrUnknownRPC   = errors.New("unknown rpc")
+	ErrWrongContact = errors.New("node answered with another id")
+)
+
+type providerRecord struct {
+	contact Contact
+	expires time.Time
+}
+
+// DHT - узел Kademlia, ключами которой являются адреса узлов и ID чанков (см. KeyID).
+// RPC передаются как JSON по HTTP (см. Handle), сам DHT ручки не регистрирует
+type DHT struct {
+	self            ID
+	selfContact     func() (Contact, error)
+	table           *table
+	k               int
+	alpha           int
+	providerTTL     time.Duration
+	refreshInterval time.Duration
+	bootstrap       []string
+	verify          Verifier
+	client          *http.Client
+	contactTTL      time.Duration
+
+	contactMu sync.Mutex
+	contact   Contact
+	contactAt time.Time
+
+	mu        sync.Mutex
+	providers map[ID]map[ID]providerRecord
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New создает узел DHT с идентификатором self. selfContact возвращает актуальный контакт узла,
+// он отправляется в каждом запросе и ответе; с опцией ContactTTL контакт переиспользуется.
+// Фоновое обслуживание запускается через Start
+func New(self ID, selfContact func() (Contact, error), opts ...Option) *DHT {
+	d := &DHT{
+		self:            self,
+		selfContact:     selfContact,
+		k:               _defaultK,
+		alpha:           _defaultAlpha,
+		providerTTL:     _defaultProviderTTL,
+		refreshInterval: _defaultRefreshInterval,
+		verify:          func(Contact, [][]byte) error { return nil },
+		client:          newHTTPClient(),
+		providers:       make(map[ID]map[ID]providerRecord),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.table = newTable(self, d.k)
+	return d
+}
+
+// Size возвращает количество узлов в таблице маршрутизации
+func (d *DHT) Size() int {
+	return d.table.size()
+}
+
+// Handle обрабатывает входящий RPC. URL отправителя должен быть уже дополнен адресом,
+// с которого пришел запрос, если узел его не знает
+func (d *DHT) Handle(rpc string, req Request) (*Response, error) {
+	verified := false
+	if !req.Sender.ID.IsZero() && req.Sender.ID != d.self {
+		if err := d.verify(req.Sender, nil); err == nil {
+			verified = true
+			d.table.seen(req.Sender)
+		}
+	}
+	self, err := d.currentContact()
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{Sender: self}
+	switch rpc {
+	case RPCPing:
+	case RPCFindNode:
+		resp.Contacts = d.closestExcept(req.Target, req.Sender.ID)
+	case RPCFindProviders:
+		resp.Providers = d.getProviders(req.Target)
+		resp.Contacts = d.closestExcept(req.Target, req.Sender.ID)
+	case RPCAddProvider:
+		if !verified {
+			return nil, ErrUnverified
+		}
+		d.addProvider(req.Target, req.Sender)
+	default:
+		return nil, ErrUnknownRPC
+	}
+	return resp, nil
+}
+
+func (d *DHT) closestExcept(target ID, except ID) []Contact {
+	contacts := d.table.closest(target, d.k+1)
+	if i := indexOf(contacts, except); i >= 0 {
+		contacts = append(contacts[:i], contacts[i+1:]...)
+	}
+	if len(contacts) > d.k {
+		contacts = contacts[:d.k]
+	}
+	return contacts
+}
+
+func (d *DHT) addProvider(key ID, c Contact) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	records, ok := d.providers[key]
+	if !ok {
+		records = make(map[ID]providerRecord)
+		d.providers[key] = records
+	}
+	if _, exists := records[c.ID]; !exists && len(records) >= _maxProvidersPerKey {
+		return
+	}
+	records[c.ID] = providerRecord{contact: c, expires: time.Now().Add(d.providerTTL)}
+}
+
+func (d *DHT) getProviders(key ID) []Contact {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	providers := make([]Contact, 0, len(d.providers[key]))
+	for _, record := range d.providers[key] {
+		if time.Now().Before(record.expires) {
+			providers = append(providers, record.contact)
+		}
+	}
+	return providers
+}
+
+func (d *DHT) expireProviders() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for key, records := range d.providers {
+		for id, record := range records {
+			if now.After(record.expires) {
+				delete(records, id)
+			}
+		}
+		if len(records) == 0 {
+			delete(d.providers, key)
+		}
+	}
+}
+
+// currentContact возвращает контакт узла, полученный от selfContact не раньше contactTTL назад
+func (d *DHT) currentContact() (Contact, error) {
+	d.contactMu.Lock()
+	defer d.contactMu.Unlock()
+	if !d.contactAt.IsZero() && time.Since(d.contactAt) < d.contactTTL {
+		return d.contact, nil
+	}
+	contact, err := d.selfContact()
+	if err != nil {
+		return Contact{}, err
+	}
+	d.contact = contact
+	d.contactAt = time.Now()
+	return contact, nil
+}
+
+// query отправляет RPC контакту и, если ответ прошел проверку, запоминает контакт.
+// Пустой ID контакта означает, что идентификатор узла заранее неизвестен (bootstrap)
+func (d *DHT) query(c Contact, rpc string, target ID) (*Response, error) {
+	req := Request{Target: target}
+	if self, err := d.currentContact(); err == nil {
+		req.Sender = self
+	}
+	resp, rawCerts, err := d.call(c.URL, rpc, req)
+	if err != nil {
+		d.table.failed(c.ID)
+		return nil, err
+	}
+	if !c.ID.IsZero() && resp.Sender.ID != c.ID {
+		d.table.failed(c.ID)
+		return nil, ErrWrongContact
+	}
+	// узел мог не знать свой внешний адрес, а этот точно рабочий
+	resp.Sender.URL = c.URL
+	if err := d.verify(resp.Sender, rawCerts); err != nil {
+		d.table.failed(c.ID)
+		return nil, err
+	}
+	if resp.Sender.ID != d.self {
+		d.table.seen(resp.Sender)
+	}
+	return resp, nil
+}
+
+// Start запускает фоновое обслуживание: вход в сеть через bootstrap-узлы,
+// периодическое обновление таблицы и удаление устаревших записей о поставщиках
+func (d *DHT) Start() {
+	go d.run()
+}
+
+// Shutdown останавливает фоновое обслуживание
+func (d *DHT) Shutdown() {
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+	<-d.done
+}
+
+func (d *DHT) run() {
+	defer close(d.done)
+	delay := _minBootstrapDelay
+	for d.Size() == 0 && len(d.bootstrap) > 0 {
+		if err := d.Bootstrap(d.bootstrap); err != nil {
+			log.Printf("dht - bootstrap: %v, retrying in %s", err, delay)
+			select {
+			case <-d.stop:
+				return
+			case <-time.After(delay):
+			}
+			delay = min(2*delay, _maxBootstrapDelay)
+		}
+	}
+	ticker := time.NewTicker(d.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.expireProviders()
+			if d.Size() == 0 && len(d.bootstrap) > 0 {
+				_ = d.Bootstrap(d.bootstrap)
+				continue
+			}
+			// поиск себя и случайного ключа наполняет и освежает корзины
+			_, _ = d.FindNode(d.self)
+			_, _ = d.FindNode(RandomID())
+		}
+	}
+}
+
+// Bootstrap опрашивает узлы по адресам urls и ищет в сети собственный идентификатор,
+// чтобы о нем узнали ближайшие узлы
+func (d *DHT) Bootstrap(urls []string) error {
+	errs := make([]error, 0)
+	for _, u := range urls {
+		if _, err := d.query(Contact{URL: u}, RPCPing, d.self); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.Size() == 0 {
+		return errors.Join(append(errs, ErrEmptyTable)...)
+	}
+	_, err := d.FindNode(d.self)
+	return err
+}
@@ -0,0 +1,184 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testInfo заменяет подписанный анонс: проверяющий узел принимает только контакты,
+// чья информация выписана на их ID
+func testInfo(id ID) json.RawMessage {
+	info, _ := json.Marshal("signed:" + id.String())
+	return info
+}
+
+func testVerifier(c Contact, _ [][]byte) error {
+	if string(c.Info) != string(testInfo(c.ID)) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+type testNode struct {
+	dht      *DHT
+	server   *httptest.Server
+	contacts atomic.Int32
+}
+
+// newTestNode поднимает узел DHT на httptest-сервере, ручка повторяет RegisterDHT демона
+func newTestNode(t *testing.T, id ID, info json.RawMessage, opts ...Option) *testNode {
+	t.Helper()
+	node := &testNode{}
+	node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := Request{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := node.dht.Handle(strings.TrimPrefix(r.URL.Path, RPCPath("")), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(node.server.Close)
+	selfContact := func() (Contact, error) {
+		node.contacts.Add(1)
+		return Contact{ID: id, URL: node.server.URL, Info: info}, nil
+	}
+	node.dht = New(id, selfContact, append([]Option{Verify(testVerifier)}, opts...)...)
+	return node
+}
+
+// newTestNetwork поднимает count узлов, каждый входит в сеть через первый
+func newTestNetwork(t *testing.T, count int, opts ...Option) []*testNode {
+	t.Helper()
+	nodes := make([]*testNode, 0, count)
+	for i := 0; i < count; i++ {
+		id := KeyID([]byte(fmt.Sprintf("node-%d", i)))
+		nodes = append(nodes, newTestNode(t, id, testInfo(id), opts...))
+	}
+	for _, node := range nodes[1:] {
+		if err := node.dht.Bootstrap([]string{nodes[0].server.URL}); err != nil {
+			t.Fatalf("bootstrap: %v", err)
+		}
+	}
+	return nodes
+}
+
+func TestFindNodeReturnsClosest(t *testing.T) {
+	const k = 4
+	nodes := newTestNetwork(t, 16, K(k))
+	target := KeyID([]byte("target"))
+
+	expected := make([]Contact, 0, len(nodes))
+	for _, node := range nodes[1:] {
+		expected = append(expected, Contact{ID: node.dht.self})
+	}
+	sortByDistance(target, expected)
+
+	found, err := nodes[0].dht.FindNode(target)
+	if err != nil {
+		t.Fatalf("find node: %v", err)
+	}
+	if len(found) != k {
+		t.Fatalf("found %d contacts, want %d", len(found), k)
+	}
+	for i := range found {
+		if found[i].ID != expected[i].ID {
+			t.Errorf("contact #%d is %s, want %s", i, found[i].ID, expected[i].ID)
+		}
+	}
+}
+
+func TestBootstrapFillsTables(t *testing.T) {
+	nodes := newTestNetwork(t, 8)
+	for i, node := range nodes {
+		if node.dht.Size() == 0 {
+			t.Errorf("node #%d has an empty routing table", i)
+		}
+	}
+	// первый узел узнает обо всех остальных из их запросов
+	if size := nodes[0].dht.Size(); size != len(nodes)-1 {
+		t.Errorf("bootstrap node knows %d nodes, want %d", size, len(nodes)-1)
+	}
+}
+
+func TestProvideAndFindProviders(t *testing.T) {
+	nodes := newTestNetwork(t, 10, K(3))
+	key := KeyID([]byte("chunk"))
+	if err := nodes[4].dht.Provide(key); err != nil {
+		t.Fatalf("provide: %v", err)
+	}
+	providers, err := nodes[9].dht.FindProviders(key)
+	if err != nil {
+		t.Fatalf("find providers: %v", err)
+	}
+	if len(providers) != 1 || providers[0].ID != nodes[4].dht.self {
+		t.Fatalf("providers = %v, want only node #4", providers)
+	}
+	if _, err := nodes[9].dht.FindProviders(KeyID([]byte("unknown"))); err != nil {
+		t.Fatalf("find providers of unknown key: %v", err)
+	}
+}
+
+func TestUnverifiedContactIsRejected(t *testing.T) {
+	nodes := newTestNetwork(t, 4)
+	forgedID := KeyID([]byte("forged"))
+	forged := newTestNode(t, forgedID, testInfo(nodes[1].dht.self))
+
+	// ответ с чужой подписью не принимается, узел не попадает в таблицу
+	if err := forged.dht.Bootstrap([]string{nodes[0].server.URL}); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	for i, node := range nodes {
+		if indexOf(node.dht.table.closest(forgedID, node.dht.k), forgedID) >= 0 {
+			t.Errorf("node #%d accepted the forged contact", i)
+		}
+	}
+
+	// непроверенный узел не может объявить себя поставщиком
+	key := KeyID([]byte("chunk"))
+	if _, err := forged.dht.query(Contact{ID: nodes[0].dht.self, URL: nodes[0].server.URL}, RPCAddProvider, key); err == nil {
+		t.Fatalf("add provider from a forged contact succeeded")
+	}
+	if providers := nodes[0].dht.getProviders(key); len(providers) != 0 {
+		t.Fatalf("forged contact was recorded as provider: %v", providers)
+	}
+
+	// узел, ответивший с другим ID, считается чужим
+	_, err := nodes[0].dht.query(Contact{ID: nodes[2].dht.self, URL: nodes[3].server.URL}, RPCPing, nodes[0].dht.self)
+	if !errors.Is(err, ErrWrongContact) {
+		t.Fatalf("query to a wrong node: %v, want %v", err, ErrWrongContact)
+	}
+}
+
+func TestSelfContactIsCached(t *testing.T) {
+	nodes := newTestNetwork(t, 3, ContactTTL(time.Hour))
+	for i := 0; i < 5; i++ {
+		if _, err := nodes[1].dht.FindNode(RandomID()); err != nil {
+			t.Fatalf("find node: %v", err)
+		}
+	}
+	for i, node := range nodes {
+		if calls := node.contacts.Load(); calls != 1 {
+			t.Errorf("node #%d built its contact %d times, want 1", i, calls)
+		}
+	}
+
+	uncached := newTestNetwork(t, 2)
+	for i := 0; i < 3; i++ {
+		_, _ = uncached[1].dht.FindNode(RandomID())
+	}
+	if calls := uncached[1].contacts.Load(); calls < 2 {
+		t.Errorf("contact without ttl was built %d times, want it rebuilt on every rpc", calls)
+	}
+}
@@ -0,0 +1,94 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+)
+
+// IDSize - размер идентификатора в байтах, совпадает с размером адреса узла
+const IDSize = 20
+
+// ID - идентификатор узла или ключа в пространстве DHT
+type ID [IDSize]byte
+
+// ParseID разбирает идентификатор из hex-строки
+func ParseID(s string) (ID, error) {
+	var id ID
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return id, err
+	}
+	if len(b) != IDSize {
+		return id, fmt.Errorf("wrong id size: %d", len(b))
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// KeyID отображает произвольный ключ (например, ID чанка) в пространство идентификаторов
+func KeyID(key []byte) ID {
+	var id ID
+	sum := sha256.Sum256(key)
+	copy(id[:], sum[:IDSize])
+	return id
+}
+
+// RandomID возвращает случайный идентификатор, используется при обновлении таблицы
+func RandomID() ID {
+	var id ID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id ID) IsZero() bool {
+	return id == ID{}
+}
+
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *ID) UnmarshalText(text []byte) error {
+	parsed, err := ParseID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// distance - XOR-метрика Kademlia
+func distance(a ID, b ID) ID {
+	var d ID
+	for i := range a {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// closer сообщает, ближе ли a к target, чем b
+func closer(target ID, a ID, b ID) bool {
+	da := distance(target, a)
+	db := distance(target, b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// bucketIndex возвращает номер k-корзины для id относительно self:
+// количество общих старших бит, для self возвращается -1
+func bucketIndex(self ID, id ID) int {
+	d := distance(self, id)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return -1
+}
@@ -0,0 +1,139 @@
+package kademlia
+
+import (
+	"sync"
+)
+
+type lookupResult struct {
+	contact Contact
+	resp    *Response
+	err     error
+}
+
+// lookup - итеративный поиск Kademlia: на каждом шаге опрашиваются alpha ближайших
+// еще не опрошенных узлов, пока k ближайших известных узлов не будут опрошены.
+// Возвращает ответившие узлы, отсортированные по расстоянию до target, и найденных поставщиков
+func (d *DHT) lookup(target ID, rpc string) ([]Contact, []Contact, error) {
+	shortlist := d.table.closest(target, d.k)
+	if len(shortlist) == 0 {
+		return nil, nil, ErrEmptyTable
+	}
+	known := map[ID]bool{d.self: true}
+	for _, c := range shortlist {
+		known[c.ID] = true
+	}
+	queried := make(map[ID]bool)
+	answered := make([]Contact, 0)
+	providers := make(map[ID]Contact)
+
+	for {
+		batch := make([]Contact, 0, d.alpha)
+		for i := 0; i < len(shortlist) && i < d.k && len(batch) < d.alpha; i++ {
+			if !queried[shortlist[i].ID] {
+				batch = append(batch, shortlist[i])
+				queried[shortlist[i].ID] = true
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		results := make(chan lookupResult, len(batch))
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			wg.Add(1)
+			go func(c Contact) {
+				defer wg.Done()
+				resp, err := d.query(c, rpc, target)
+				results <- lookupResult{contact: c, resp: resp, err: err}
+			}(c)
+		}
+		wg.Wait()
+		close(results)
+
+		failed := make(map[ID]bool)
+		for result := range results {
+			if result.err != nil {
+				failed[result.contact.ID] = true
+				continue
+			}
+			answered = append(answered, result.resp.Sender)
+			for _, c := range result.resp.Contacts {
+				if !known[c.ID] && !c.ID.IsZero() {
+					known[c.ID] = true
+					shortlist = append(shortlist, c)
+				}
+			}
+			for _, p := range result.resp.Providers {
+				providers[p.ID] = p
+			}
+		}
+		// неответившие узлы больше не занимают места среди k ближайших
+		alive := shortlist[:0]
+		for _, c := range shortlist {
+			if !failed[c.ID] {
+				alive = append(alive, c)
+			}
+		}
+		shortlist = alive
+		sortByDistance(target, shortlist)
+		if rpc == RPCFindProviders && len(providers) > 0 {
+			break
+		}
+	}
+
+	sortByDistance(target, answered)
+	if len(answered) > d.k {
+		answered = answered[:d.k]
+	}
+	found := make([]Contact, 0, len(providers))
+	for _, p := range providers {
+		found = append(found, p)
+	}
+	return answered, found, nil
+}
+
+// FindNode возвращает до k ответивших узлов, ближайших к target
+func (d *DHT) FindNode(target ID) ([]Contact, error) {
+	contacts, _, err := d.lookup(target, RPCFindNode)
+	return contacts, err
+}
+
+// FindProviders ищет узлы, объявившие себя поставщиками ключа
+func (d *DHT) FindProviders(key ID) ([]Contact, error) {
+	providers := d.getProviders(key)
+	_, found, err := d.lookup(key, RPCFindProviders)
+	if err != nil && len(providers) == 0 {
+		return nil, err
+	}
+	seen := make(map[ID]bool)
+	for _, p := range providers {
+		seen[p.ID] = true
+	}
+	for _, p := range found {
+		if !seen[p.ID] {
+			providers = append(providers, p)
+		}
+	}
+	return providers, nil
+}
+
+// Provide объявляет узел поставщиком ключа на k ближайших к ключу узлах
+func (d *DHT) Provide(key ID) error {
+	contacts, err := d.FindNode(key)
+	if err != nil {
+		return err
+	}
+	self, err := d.currentContact()
+	if err != nil {
+		return err
+	}
+	// ближайшим к ключу может оказаться и сам узел
+	if len(contacts) < d.k || closer(key, d.self, contacts[len(contacts)-1].ID) {
+		d.addProvider(key, self)
+	}
+	for _, c := range contacts {
+		_, _ = d.query(c, RPCAddProvider, key)
+	}
+	return nil
+}
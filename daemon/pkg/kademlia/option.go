@@ -0,0 +1,67 @@
+package kademlia
+
+import (
+	"time"
+)
+
+type Option func(*DHT)
+
+// Verifier проверяет контакт перед добавлением в таблицу маршрутизации.
+// rawCerts - TLS-сертификаты, предъявленные узлом при исходящем запросе (nil для входящих и ws)
+type Verifier func(c Contact, rawCerts [][]byte) error
+
+// K задает размер k-корзины и количество узлов, возвращаемых при поиске
+func K(k int) Option {
+	return func(d *DHT) {
+		d.k = k
+	}
+}
+
+// Alpha задает количество параллельных запросов при итеративном поиске
+func Alpha(alpha int) Option {
+	return func(d *DHT) {
+		d.alpha = alpha
+	}
+}
+
+// Timeout задает таймаут одного RPC
+func Timeout(timeout time.Duration) Option {
+	return func(d *DHT) {
+		d.client.Timeout = timeout
+	}
+}
+
+// ProviderTTL задает, сколько хранится запись о поставщике ключа без повторной публикации
+func ProviderTTL(ttl time.Duration) Option {
+	return func(d *DHT) {
+		d.providerTTL = ttl
+	}
+}
+
+// RefreshInterval задает период обновления таблицы маршрутизации
+func RefreshInterval(interval time.Duration) Option {
+	return func(d *DHT) {
+		d.refreshInterval = interval
+	}
+}
+
+// Verify задает проверку контактов, без нее принимаются любые контакты
+func Verify(verify Verifier) Option {
+	return func(d *DHT) {
+		d.verify = verify
+	}
+}
+
+// Bootstrap задает адреса узлов (scheme://host:port), через которые узел входит в сеть
+func Bootstrap(urls []string) Option {
+	return func(d *DHT) {
+		d.bootstrap = urls
+	}
+}
+
+// ContactTTL задает, сколько переиспользуется контакт узла, прежде чем selfContact вызовется снова
+func ContactTTL(ttl time.Duration) Option {
+	return func(d *DHT) {
+		d.contactTTL = ttl
+	}
+}
@@ -0,0 +1,113 @@
+package kademlia
+
+import (
+	"sort"
+	"sync"
+)
+
+// bucket - k-корзина: контакты от давно известных к недавно увиденным
+// и кэш замены, из которого берутся контакты на место неотвечающих
+type bucket struct {
+	contacts     []Contact
+	replacements []Contact
+}
+
+// table - таблица маршрутизации Kademlia
+type table struct {
+	mu      sync.RWMutex
+	self    ID
+	k       int
+	buckets [IDSize * 8]bucket
+}
+
+func newTable(self ID, k int) *table {
+	return &table{self: self, k: k}
+}
+
+// seen добавляет контакт или переносит его в конец корзины.
+// Если корзина заполнена, контакт попадает в кэш замены: Kademlia предпочитает давно живущие узлы
+func (t *table) seen(c Contact) {
+	idx := bucketIndex(t.self, c.ID)
+	if idx < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[idx]
+	if i := indexOf(b.contacts, c.ID); i >= 0 {
+		b.contacts = append(append(b.contacts[:i], b.contacts[i+1:]...), c)
+		return
+	}
+	if len(b.contacts) < t.k {
+		b.contacts = append(b.contacts, c)
+		return
+	}
+	if i := indexOf(b.replacements, c.ID); i >= 0 {
+		b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+	}
+	b.replacements = append(b.replacements, c)
+	if len(b.replacements) > t.k {
+		b.replacements = b.replacements[1:]
+	}
+}
+
+// failed удаляет неответивший контакт и заменяет его самым свежим из кэша замены
+func (t *table) failed(id ID) {
+	idx := bucketIndex(t.self, id)
+	if idx < 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[idx]
+	i := indexOf(b.contacts, id)
+	if i < 0 {
+		return
+	}
+	b.contacts = append(b.contacts[:i], b.contacts[i+1:]...)
+	if n := len(b.replacements); n > 0 {
+		b.contacts = append(b.contacts, b.replacements[n-1])
+		b.replacements = b.replacements[:n-1]
+	}
+}
+
+// closest возвращает до count известных контактов, ближайших к target
+func (t *table) closest(target ID, count int) []Contact {
+	t.mu.RLock()
+	all := make([]Contact, 0)
+	for i := range t.buckets {
+		all = append(all, t.buckets[i].contacts...)
+	}
+	t.mu.RUnlock()
+	sortByDistance(target, all)
+	if len(all) > count {
+		all = all[:count]
+	}
+	return all
+}
+
+// size возвращает количество контактов в таблице
+func (t *table) size() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n := 0
+	for i := range t.buckets {
+		n += len(t.buckets[i].contacts)
+	}
+	return n
+}
+
+func indexOf(contacts []Contact, id ID) int {
+	for i, c := range contacts {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func sortByDistance(target ID, contacts []Contact) {
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return closer(target, contacts[i].ID, contacts[j].ID)
+	})
+}
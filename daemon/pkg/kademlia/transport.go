@@ -0,0 +1,81 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// MaxMessageSize ограничивает размер запроса и ответа RPC
+const MaxMessageSize = 1 << 20
+
+// RPCPath возвращает путь ручки RPC на узле
+func RPCPath(rpc string) string {
+	return "/dht/" + rpc
+}
+
+// httpURL переводит адрес узла ws(s)://host:port в http(s)://host:port/dht/rpc
+func httpURL(nodeURL string, rpc string) (string, error) {
+	u, err := url.Parse(nodeURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme = "https"
+	default:
+		return "", fmt.Errorf("unknown scheme: %s", u.Scheme)
+	}
+	u.Path = RPCPath(rpc)
+	return u.String(), nil
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: _defaultTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				// сертификат проверяет Verifier по подписанной информации об узле
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+}
+
+// call отправляет RPC узлу и возвращает ответ вместе с TLS-сертификатами узла
+func (d *DHT) call(nodeURL string, rpc string, req Request) (*Response, [][]byte, error) {
+	target, err := httpURL(nodeURL, rpc)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := d.client.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("status code error: %d %s", resp.StatusCode, resp.Status)
+	}
+	response := &Response{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, MaxMessageSize)).Decode(response); err != nil {
+		return nil, nil, err
+	}
+	var rawCerts [][]byte
+	if resp.TLS != nil {
+		for _, cert := range resp.TLS.PeerCertificates {
+			rawCerts = append(rawCerts, cert.Raw)
+		}
+	}
+	return response, rawCerts, nil
+}
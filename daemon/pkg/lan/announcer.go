@@ -0,0 +1,134 @@
+package lan
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// QueryMessage - запрос клиента, на который все узлы в сети отвечают своим анонсом
+	QueryMessage = "distorage-query"
+	// MaxPacketSize - максимальный размер анонса, больше не помещается в одну UDP-датаграмму
+	MaxPacketSize = 8192
+
+	_defaultInterval = 10 * time.Second
+)
+
+// Payload возвращает актуальный анонс узла
+type Payload func() ([]byte, error)
+
+// Announcer анонсирует узел в локальной сети через UDP multicast в духе mDNS:
+// раз в interval рассылает анонс в группу и сразу отвечает на запросы клиентов
+// напрямую отправителю
+type Announcer struct {
+	group    *net.UDPAddr
+	iface    *net.Interface
+	payload  Payload
+	interval time.Duration
+
+	conn *net.UDPConn
+	stop chan struct{}
+	done chan struct{}
+	// announcing - удалась ли последняя рассылка в группу
+	announcing atomic.Bool
+}
+
+// New создает анонсер для группы, заданной как host:port (например, 239.255.77.77:53590)
+func New(group string, payload Payload, opts ...Option) (*Announcer, error) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, err
+	}
+	a := &Announcer{
+		group:    groupAddr,
+		payload:  payload,
+		interval: _defaultInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a, nil
+}
+
+// Start подключается к группе и запускает рассылку анонсов
+func (a *Announcer) Start() error {
+	conn, err := net.ListenMulticastUDP("udp4", a.iface, a.group)
+	if err != nil {
+		return err
+	}
+	a.conn = conn
+	go a.serve()
+	go a.run()
+	return nil
+}
+
+// Announcing сообщает, удалась ли последняя рассылка анонса в группу
+func (a *Announcer) Announcing() bool {
+	return a.announcing.Load()
+}
+
+// Shutdown останавливает рассылку и выходит из группы
+func (a *Announcer) Shutdown() {
+	if a.conn == nil {
+		return
+	}
+	close(a.stop)
+	_ = a.conn.Close()
+	<-a.done
+}
+
+func (a *Announcer) send(to *net.UDPAddr) error {
+	payload, err := a.payload()
+	if err != nil {
+		log.Printf("lan - announce: %v", err)
+		return err
+	}
+	if len(payload) > MaxPacketSize {
+		log.Printf("lan - announcement is too big: %d bytes", len(payload))
+		return errors.New("announcement is too big")
+	}
+	if _, err := a.conn.WriteToUDP(payload, to); err != nil {
+		log.Printf("lan - send to %s: %v", to, err)
+		return err
+	}
+	return nil
+}
+
+func (a *Announcer) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		a.announcing.Store(a.send(a.group) == nil)
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serve отвечает на запросы клиентов; анонсы других узлов, пришедшие в группу, пропускаются
+func (a *Announcer) serve() {
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-a.stop:
+			default:
+				log.Printf("lan - read: %v", err)
+			}
+			return
+		}
+		if bytes.Equal(buf[:n], []byte(QueryMessage)) {
+			_ = a.send(from)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package lan
+
+import (
+	"net"
+	"time"
+)
+
+type Option func(*Announcer)
+
+// Interval задает период рассылки анонсов в группу
+func Interval(interval time.Duration) Option {
+	return func(a *Announcer) {
+		a.interval = interval
+	}
+}
+
+// Interface задает сетевой интерфейс для multicast, по умолчанию выбирается системой
+func Interface(iface *net.Interface) Option {
+	return func(a *Announcer) {
+		a.iface = iface
+	}
+}
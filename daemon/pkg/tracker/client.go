@@ -0,0 +1,290 @@
+package tracker
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultPingInterval = 20 * time.Second
+	_defaultMinBackoff   = time.Second
+	_defaultMaxBackoff   = time.Minute
+	_closeTimeout        = time.Second
+)
+
+// State - состояние подключения к трекеру
+type State int
+
+const (
+	Disconnected State = iota
+	Connecting
+	Connected
+)
+
+func (s State) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	default:
+		return "disconnected"
+	}
+}
+
+// Status - снимок состояния подключения, используется в проверках здоровья демона
+type Status struct {
+	URL       string    `json:"url"`
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Handshake вызывается после каждого (пере)подключения к трекеру,
+// в нем демон сообщает трекеру о себе
+type Handshake func(conn *websocket.Conn) error
+
+// Client поддерживает подключение к трекеру: переподключается с экспоненциальной
+// задержкой со случайным разбросом и заново анонсирует себя после переподключения
+type Client struct {
+	url          string
+	handshake    Handshake
+	heartbeat    func(conn *websocket.Conn) error
+	pingInterval time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+
+	mu        sync.RWMutex
+	state     State
+	since     time.Time
+	attempts  int
+	lastError error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New создает клиента трекера и запускает цикл подключения.
+// serverURL задается без схемы (например, 127.0.0.1:8000/connect), тогда используется ws://,
+// или со схемой ws:// либо wss://
+func New(serverURL string, handshake Handshake, opts ...Option) (*Client, error) {
+	serverPath := serverURL
+	if !strings.Contains(serverURL, "://") {
+		u := url.URL{Scheme: "ws", Host: serverURL}
+		var err error
+		serverPath, err = url.PathUnescape(u.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+	c := &Client{
+		url:          serverPath,
+		handshake:    handshake,
+		pingInterval: _defaultPingInterval,
+		minBackoff:   _defaultMinBackoff,
+		maxBackoff:   _defaultMaxBackoff,
+		since:        time.Now(),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	go c.run()
+
+	return c, nil
+}
+
+// Status возвращает текущее состояние подключения
+func (c *Client) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	status := Status{
+		URL:      c.url,
+		State:    c.state.String(),
+		Since:    c.since,
+		Attempts: c.attempts,
+	}
+	if c.lastError != nil {
+		status.LastError = c.lastError.Error()
+	}
+	return status
+}
+
+// State возвращает текущее состояние подключения
+func (c *Client) State() State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// Shutdown закрывает подключение к трекеру и останавливает переподключения
+func (c *Client) Shutdown() {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+}
+
+func (c *Client) setState(state State, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != state {
+		c.since = time.Now()
+	}
+	c.state = state
+	switch state {
+	case Connecting:
+		c.attempts += 1
+	case Connected:
+		c.attempts = 0
+	}
+	if err != nil {
+		c.lastError = err
+	}
+}
+
+// backoff вычисляет задержку перед очередной попыткой: случайное значение
+// от половины до целого minBackoff * 2^attempts, но не больше maxBackoff
+func (c *Client) backoff() time.Duration {
+	c.mu.RLock()
+	attempts := c.attempts
+	c.mu.RUnlock()
+	ceiling := c.maxBackoff
+	if attempts < 32 {
+		if d := c.minBackoff << attempts; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+	for {
+		c.setState(Connecting, nil)
+		log.Printf("tracker - connecting to %s", c.url)
+		err := c.session()
+		if err == nil {
+			// сессия завершилась по запросу Shutdown
+			c.setState(Disconnected, nil)
+			return
+		}
+		log.Printf("tracker - %s: %v", c.url, err)
+		c.setState(Disconnected, err)
+
+		delay := c.backoff()
+		log.Printf("tracker - reconnecting to %s in %s", c.url, delay.Round(time.Millisecond))
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// session подключается к трекеру и держит подключение, пока оно живо.
+// Возвращает nil, только если подключение было закрыто через Shutdown
+func (c *Client) session() error {
+	// подключение и handshake прерываются по Shutdown, не дожидаясь таймаутов
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// websocket сам не прерывает чтение ответа на upgrade по ctx, поэтому до конца handshake
+	// отмена ctx прерывает операции с соединением через истекший дедлайн
+	var stopHandshake func() bool
+	dialer := *websocket.DefaultDialer
+	dialer.NetDialContext = func(dialCtx context.Context, network string, addr string) (net.Conn, error) {
+		netConn, err := (&net.Dialer{}).DialContext(dialCtx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		stopHandshake = context.AfterFunc(ctx, func() {
+			_ = netConn.SetDeadline(time.Now())
+		})
+		return netConn, nil
+	}
+	conn, _, err := dialer.DialContext(ctx, c.url, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer conn.Close()
+	// если трекер перестал отвечать на ping, подключение считается разорванным
+	_ = conn.SetReadDeadline(time.Now().Add(2 * c.pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * c.pingInterval))
+	})
+
+	err = c.handshake(conn)
+	if !stopHandshake() {
+		// подключение прервано через Shutdown
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.setState(Connected, nil)
+	log.Printf("tracker - connected to %s", c.url)
+
+	// чтение входящих сообщений нужно для обработки pong и обнаружения разрыва
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+	for {
+		if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+			return err
+		}
+		select {
+		case err := <-readErr:
+			return err
+		case <-ticker.C:
+			if c.heartbeat != nil {
+				if err := c.heartbeat(conn); err != nil {
+					return err
+				}
+			}
+		case <-c.stop:
+			err := conn.WriteMessage(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			)
+			if err != nil {
+				return nil
+			}
+			select {
+			case <-readErr:
+			case <-time.After(_closeTimeout):
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package tracker
+
+import (
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+type Option func(*Client)
+
+// PingInterval задает период отправки ping трекеру
+func PingInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		c.pingInterval = interval
+	}
+}
+
+// Heartbeat задает функцию, которая вызывается вместе с каждым ping трекеру,
+// например, чтобы обновить анонс узла
+func Heartbeat(heartbeat func(conn *websocket.Conn) error) Option {
+	return func(c *Client) {
+		c.heartbeat = heartbeat
+	}
+}
+
+// Backoff задает минимальную и максимальную задержку между попытками переподключения
+func Backoff(min time.Duration, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
@@ -1,6 +1,7 @@
 package wsserver
 
 import (
+	"crypto/tls"
 	"net"
 )
 
@@ -11,3 +12,13 @@ func Port(port string) Option {
 		s.server.Addr = net.JoinHostPort("0.0.0.0", port)
 	}
 }
+
+// TLS включает TLS (wss://) с переданным сертификатом
+func TLS(cert tls.Certificate) Option {
+	return func(s *Server) {
+		s.server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+}
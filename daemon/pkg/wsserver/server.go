@@ -2,7 +2,9 @@ package wsserver
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
@@ -38,10 +40,21 @@ func New(handler http.Handler, opts ...Option) *Server {
 	return s
 }
 
+// start открывает порт синхронно, чтобы после New сервер уже принимал подключения,
+// а обслуживание запросов запускает в отдельной горутине
 func (s *Server) start() {
 	log.Printf("Starting server on port %s", s.server.Addr)
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		s.notify <- err
+		close(s.notify)
+		return
+	}
+	if s.server.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.server.TLSConfig)
+	}
 	go func() {
-		s.notify <- s.server.ListenAndServe()
+		s.notify <- s.server.Serve(listener)
 		close(s.notify)
 	}()
 }
@@ -0,0 +1,94 @@
+import base64
+import binascii
+import json
+
+from Crypto.Hash import keccak
+from cryptography.exceptions import InvalidSignature
+from cryptography.hazmat.primitives import hashes
+from cryptography.hazmat.primitives.asymmetric import ec, utils
+from cryptography.hazmat.primitives.serialization import load_der_public_key
+
+CHALLENGE_PREFIX = b'distorage-tracker-challenge'
+CHALLENGE_SIZE = 32
+ANNOUNCEMENT_PREFIX = b'distorage-announcement'
+
+
+class InvalidChallengeResponse(Exception):
+    pass
+
+
+class InvalidAnnouncement(Exception):
+    pass
+
+
+def keccak256(data: bytes) -> bytes:
+    return keccak.new(digest_bits=256, data=data).digest()
+
+
+def address(pub_key: bytes) -> str:
+    return keccak256(pub_key)[12:].hex()
+
+
+def _load_pub_key(pub_key_bytes: bytes) -> ec.EllipticCurvePublicKey:
+    pub_key = load_der_public_key(pub_key_bytes)
+    if not isinstance(pub_key, ec.EllipticCurvePublicKey):
+        raise ValueError('wrong key type')
+    return pub_key
+
+
+def verify_challenge(challenge: bytes, response: bytes) -> str:
+    """
+    Checks the daemon's answer to the challenge and returns the address it proved.
+    The answer is [1 byte key length][PKIX public key][ASN.1 ECDSA signature]
+    of keccak256(CHALLENGE_PREFIX + challenge).
+    """
+    if len(response) < 1 or len(response) < 1 + response[0]:
+        raise InvalidChallengeResponse('response too short')
+    pub_key_bytes = response[1:1 + response[0]]
+    sig = response[1 + response[0]:]
+    try:
+        pub_key = _load_pub_key(pub_key_bytes)
+    except ValueError as e:
+        raise InvalidChallengeResponse('invalid public key') from e
+    try:
+        pub_key.verify(
+            sig,
+            keccak256(CHALLENGE_PREFIX + challenge),
+            ec.ECDSA(utils.Prehashed(hashes.SHA256())),
+        )
+    except InvalidSignature as e:
+        raise InvalidChallengeResponse('signature check failed') from e
+    return address(pub_key_bytes)
+
+
+def verify_announcement(signed: dict, expected_address: str) -> dict:
+    """
+    Checks a signed node announcement and returns its fields.
+    The announcement is base64 of the JSON the daemon signed, pub_key and signature are hex;
+    the signature is over keccak256(ANNOUNCEMENT_PREFIX + announcement) and the key
+    must belong to the address that passed the challenge.
+    """
+    try:
+        announcement_bytes = base64.b64decode(signed['announcement'], validate=True)
+        pub_key_bytes = bytes.fromhex(signed['pub_key'])
+        sig = bytes.fromhex(signed['signature'])
+    except (KeyError, TypeError, ValueError, binascii.Error) as e:
+        raise InvalidAnnouncement('malformed announcement') from e
+    if address(pub_key_bytes) != expected_address:
+        raise InvalidAnnouncement('announcement signed by another key')
+    try:
+        pub_key = _load_pub_key(pub_key_bytes)
+        pub_key.verify(
+            sig,
+            keccak256(ANNOUNCEMENT_PREFIX + announcement_bytes),
+            ec.ECDSA(utils.Prehashed(hashes.SHA256())),
+        )
+    except (ValueError, InvalidSignature) as e:
+        raise InvalidAnnouncement('signature check failed') from e
+    try:
+        announcement = json.loads(announcement_bytes)
+    except ValueError as e:
+        raise InvalidAnnouncement('announcement is not json') from e
+    if not isinstance(announcement, dict) or announcement.get('addr') != expected_address:
+        raise InvalidAnnouncement('announcement address mismatch')
+    return announcement
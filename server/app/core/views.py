@@ -1,3 +1,5 @@
+import hashlib
+import json
 import os
 
 from aiohttp import web
@@ -9,7 +11,12 @@ from app.store.ws.ws_accessor import WSContext
 
 class IndexView(View):
     async def get(self):
-        return web.json_response(await self.store.nodes_accessor.node_dict())
+        endpoints = json.dumps(await self.store.nodes_accessor.endpoints(), sort_keys=True)
+        etag = f'"{hashlib.sha256(endpoints.encode()).hexdigest()[:32]}"'
+        if self.request.headers.get('If-None-Match') == etag:
+            return web.Response(status=304, headers={'ETag': etag})
+        body = json.dumps(await self.store.nodes_accessor.node_dict(), sort_keys=True)
+        return web.Response(text=body, content_type='application/json', headers={'ETag': etag})
 
 
 class WSConnectView(View):
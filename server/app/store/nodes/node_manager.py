@@ -1,9 +1,21 @@
+import json
 import logging
+import os
 
 import aiohttp
 from app.base.accessor import BaseManager
+from app.base.crypto import (
+    CHALLENGE_SIZE,
+    InvalidAnnouncement,
+    InvalidChallengeResponse,
+    verify_announcement,
+    verify_challenge,
+)
 from app.store.ws.ws_accessor import Event
 
+ACCEPTED = b'\xc8'
+REJECTED = b'\x01\x91'
+
 
 class NodeManager(BaseManager):
     class Meta:
@@ -11,14 +23,40 @@ class NodeManager(BaseManager):
 
     async def handle(self, connection_id: str):
         logging.info(f'accepting new connection: {connection_id}')
-        ip_addr, pub_addr = await self.store.ws_accessor.initial_info(connection_id)
+        challenge = os.urandom(CHALLENGE_SIZE)
+        ip_addr, response = await self.store.ws_accessor.initial_info(connection_id, challenge)
+        try:
+            pub_addr = verify_challenge(challenge, response)
+        except InvalidChallengeResponse as e:
+            logging.info(f'rejecting connection {connection_id}: {e}')
+            await self.store.ws_accessor.send_bytes(connection_id, REJECTED)
+            return
+        await self.store.ws_accessor.send_bytes(connection_id, ACCEPTED)
+        try:
+            announcement = verify_announcement(
+                await self.store.ws_accessor.receive_json(connection_id),
+                pub_addr,
+            )
+        except InvalidAnnouncement as e:
+            logging.info(f'rejecting announcement of {pub_addr}: {e}')
+            return
         await self.store.nodes_accessor.add(
             _id=connection_id,
             pub_addr=pub_addr,
             ip_addr=ip_addr,
+            announcement=announcement,
         )
-        async for _ in self.store.ws_accessor.stream(connection_id):
-            continue
+        # every heartbeat carries a fresh signed announcement
+        async for event in self.store.ws_accessor.stream(connection_id):
+            if event.kind != aiohttp.WSMsgType.TEXT:
+                continue
+            await self.store.ws_accessor.refresh_connection(connection_id)
+            try:
+                announcement = verify_announcement(json.loads(event.data), pub_addr)
+            except (ValueError, InvalidAnnouncement) as e:
+                logging.info(f'ignoring announcement of {pub_addr}: {e}')
+                continue
+            await self.store.nodes_accessor.update(connection_id, announcement)
 
     async def on_user_disconnect(self, connection_id: str) -> None:
         await self.store.nodes_accessor.remove(connection_id)
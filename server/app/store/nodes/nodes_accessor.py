@@ -1,3 +1,4 @@
+import typing
 from dataclasses import dataclass
 
 from app.base.accessor import BaseAccessor
@@ -8,9 +9,37 @@ class Node:
     id: str
     pub_addr: str
     ip_addr: str
+    host: str
+    port: str
+    scheme: str
+    cert_fingerprint: str = ''
+    version: str = ''
+    protocol_version: int = 0
+    region: str = ''
+    zone: str = ''
+    host_id: str = ''
+    total_capacity: int = 0
+    free_capacity: int = 0
+    uptime: int = 0
+    timestamp: int = 0
 
     def __str__(self):
-        return f'Node {self.pubAddr} ({self.ip_addr})'
+        return f'Node {self.pub_addr} ({self.ip_addr})'
+
+    def update(self, announcement: dict) -> None:
+        self.host = announcement.get('host') or self.ip_addr
+        self.port = str(announcement.get('port', ''))
+        self.scheme = announcement.get('scheme') or 'ws'
+        self.cert_fingerprint = announcement.get('cert_fingerprint', '')
+        self.version = announcement.get('version', '')
+        self.protocol_version = int(announcement.get('protocol_version', 0))
+        self.region = announcement.get('region', '')
+        self.zone = announcement.get('zone', '')
+        self.host_id = announcement.get('host_id', '')
+        self.total_capacity = int(announcement.get('total_capacity', 0))
+        self.free_capacity = int(announcement.get('free_capacity', 0))
+        self.uptime = int(announcement.get('uptime', 0))
+        self.timestamp = int(announcement.get('timestamp', 0))
 
 
 class NodesAccessor(BaseAccessor):
@@ -20,25 +49,46 @@ class NodesAccessor(BaseAccessor):
     async def list_nodes(self) -> list[Node]:
         return list(self._nodes.values())
 
-    async def node_dict(self) -> dict[str, str]:
-        return {node.pub_addr: node.ip_addr for node in self._nodes.values()}
-
-    async def add(
-            self,
-            _id: str,
-            pub_addr: str,
-            ip_addr: str,
-    ) -> Node:
-        node = Node(
-            id=_id,
-            pub_addr=pub_addr,
-            ip_addr=ip_addr,
-        )
+    async def node_dict(self) -> dict[str, dict[str, typing.Any]]:
+        return {
+            node.pub_addr: {
+                'host': node.host,
+                'port': node.port,
+                'scheme': node.scheme,
+                'cert_fingerprint': node.cert_fingerprint,
+                'version': node.version,
+                'protocol_version': node.protocol_version,
+                'region': node.region,
+                'zone': node.zone,
+                'host_id': node.host_id,
+                'total_capacity': node.total_capacity,
+                'free_capacity': node.free_capacity,
+                'uptime': node.uptime,
+                'timestamp': node.timestamp,
+            }
+            for node in self._nodes.values()
+        }
+
+    async def endpoints(self) -> dict[str, list[str]]:
+        # heartbeats change only capacity, uptime and timestamp, the list version follows what clients connect to
+        return {
+            node.pub_addr: [node.host, node.port, node.scheme, node.cert_fingerprint]
+            for node in self._nodes.values()
+        }
+
+    async def add(self, _id: str, pub_addr: str, ip_addr: str, announcement: dict) -> Node:
+        node = Node(id=_id, pub_addr=pub_addr, ip_addr=ip_addr, host='', port='', scheme='')
+        node.update(announcement)
         self._nodes[_id] = node
         return node
 
+    async def update(self, _id: str, announcement: dict) -> None:
+        node = self._nodes.get(_id)
+        if node:
+            node.update(announcement)
+
     async def remove(self, _id: str) -> None:
-        self._nodes.pop(_id)
+        self._nodes.pop(_id, None)
 
     async def get(self, _id: str) -> Node:
         return self._nodes[_id]
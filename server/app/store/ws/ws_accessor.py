@@ -18,6 +18,7 @@ if typing.TYPE_CHECKING:
 @dataclass
 class Event:
     kind: int
+    data: typing.Any = None
 
     def __str__(self):
         return f'Event<{self.kind}>'
@@ -122,11 +123,18 @@ class WSAccessor(BaseAccessor):
             if message.type == aiohttp.WSMsgType.PING:
                 await self._connections[connection_id].session.pong()
                 await self.refresh_connection(connection_id)
-            yield Event(kind=message.type)
+            yield Event(kind=message.type, data=message.data)
 
-    async def initial_info(self, connection_id: str) -> typing.Tuple[str, str]:
+    async def initial_info(self, connection_id: str, challenge: bytes) -> typing.Tuple[str, bytes]:
+        await self.send_bytes(connection_id, challenge)
         return (self._connections[connection_id].ip_addr,
-                (await self._connections[connection_id].session.receive_bytes(timeout=10)).hex())
+                await self._connections[connection_id].session.receive_bytes(timeout=10))
+
+    async def receive_json(self, connection_id: str) -> dict:
+        return await self._connections[connection_id].session.receive_json(timeout=10)
+
+    async def send_bytes(self, connection_id: str, data: bytes):
+        await self._connections[connection_id].session.send_bytes(data)
 
     async def refresh_connection(self, connection_id: str):
         self._connections[connection_id].timeout_task.cancel()
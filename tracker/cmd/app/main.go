@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/s1lur/distorage/tracker/config"
+	"github.com/s1lur/distorage/tracker/internal/app"
+	"log"
+)
+
+func main() {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("Config error: %s", err)
+	}
+	app.Run(cfg)
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ilyakaznacheev/cleanenv"
+	"os"
+)
+
+type (
+	Config struct {
+		Port string `toml:"port" env-default:"8000"`
+
+		// через сколько секунд без ping и анонсов узел считается отключившимся
+		ConnectionTimeout int `toml:"connection_timeout" env-default:"60"`
+		// сколько секунд анонс может быть старше времени трекера (разница часов)
+		MaxClockSkew int `toml:"max_clock_skew" env-default:"300"`
+
+		// файл, в котором сохраняется список узлов; пустой - список хранится только в памяти
+		StateFile string `toml:"state_file"`
+		// сколько секунд после перезапуска отдаются сохраненные узлы, пока они не переподключились
+		RestoreTTL int `toml:"restore_ttl" env-default:"120"`
+
+		// сертификат для https/wss; если не задан, трекер работает по http/ws
+		TLSCertFile string `toml:"tls_cert_file"`
+		TLSKeyFile  string `toml:"tls_key_file"`
+	}
+)
+
+// NewConfig читает конфиг из файла, переданного флагом -config (или единственным аргументом).
+// Без конфига используются значения по умолчанию
+func NewConfig() (*Config, error) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	configPath := flags.String("config", "", "path to config file")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case *configPath == "" && flags.NArg() == 1:
+		*configPath = flags.Arg(0)
+	case flags.NArg() > 1 || (*configPath != "" && flags.NArg() > 0):
+		return nil, errors.New("too many arguments")
+	}
+
+	cfg := &Config{}
+	var err error
+	if *configPath != "" {
+		err = cleanenv.ReadConfig(*configPath, cfg)
+	} else {
+		err = cleanenv.ReadEnv(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+	if cfg.ConnectionTimeout <= 0 {
+		return nil, errors.New("config error: connection_timeout must be positive")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, errors.New("config error: both tls_cert_file and tls_key_file must be set")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/s1lur/distorage/tracker/config"
+	"github.com/s1lur/distorage/tracker/internal/controller/ws"
+	"github.com/s1lur/distorage/tracker/internal/usecase"
+	"github.com/s1lur/distorage/tracker/pkg/wsserver"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// saveInterval - как часто измененный список узлов сохраняется в файл состояния
+const saveInterval = 10 * time.Second
+
+func Run(cfg *config.Config) {
+	cryptoUseCase := usecase.NewCryptoUC()
+	registryUseCase := usecase.NewRegistryUC(
+		cfg.StateFile,
+		time.Duration(cfg.RestoreTTL)*time.Second,
+		time.Duration(cfg.MaxClockSkew)*time.Second,
+	)
+	if err := registryUseCase.Load(); err != nil {
+		log.Fatal("Error loading tracker state: ", err)
+	}
+
+	serverOptions := []wsserver.Option{wsserver.Port(cfg.Port)}
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatal("Error loading TLS certificate: ", err)
+		}
+		serverOptions = append(serverOptions, wsserver.TLS(cert))
+	}
+
+	router := ws.RegisterRoutes(cryptoUseCase, registryUseCase, time.Duration(cfg.ConnectionTimeout)*time.Second)
+	wsServer := wsserver.New(router, serverOptions...)
+
+	stopSaver := make(chan struct{})
+	saverDone := make(chan struct{})
+	go runSaver(registryUseCase, stopSaver, saverDone)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	var err error
+	select {
+	case s := <-interrupt:
+		log.Printf("app - Run - signal: %s", s.String())
+	case err = <-wsServer.Notify():
+		log.Fatalf("app - Run - httpServer.Notify: %s", err)
+	}
+
+	err = wsServer.Shutdown()
+	if err != nil {
+		log.Printf("app - Run - httpServer.Shutdown: %s", err)
+	}
+	close(stopSaver)
+	<-saverDone
+
+	err = <-wsServer.Notify()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("app - run - wsServer.Notify: %s", err)
+	}
+}
+
+// runSaver периодически сохраняет список узлов и сохраняет его еще раз при остановке
+func runSaver(r usecase.Registry, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if err := r.Save(); err != nil {
+				log.Printf("app - runSaver - save: %s", err)
+			}
+			return
+		case <-ticker.C:
+			if err := r.Save(); err != nil {
+				log.Printf("app - runSaver - save: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/s1lur/distorage/tracker/internal/entity"
+	"github.com/s1lur/distorage/tracker/internal/usecase"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// handshakeTimeout - сколько ждать ответа на challenge и первого анонса
+	handshakeTimeout = 10 * time.Second
+	// maxMessageSize - ограничение на размер сообщения от узла
+	maxMessageSize = 64 << 10
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// Routes хранит юзкейсы, нужные ручкам трекера
+type Routes struct {
+	cryptoUC          usecase.Crypto
+	registryUC        usecase.Registry
+	connectionTimeout time.Duration
+}
+
+// RegisterRoutes инициализирует ручки трекера:
+// /connect - websocket, через который узлы регистрируются и присылают heartbeat,
+// /nodes - список доступных узлов для клиентов, /events - поток изменений этого списка
+func RegisterRoutes(c usecase.Crypto, r usecase.Registry, connectionTimeout time.Duration) *mux.Router {
+	routes := Routes{
+		cryptoUC:          c,
+		registryUC:        r,
+		connectionTimeout: connectionTimeout,
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/connect", routes.Connect).Methods("GET")
+	router.HandleFunc("/nodes", routes.Nodes).Methods("GET")
+	router.HandleFunc("/events", routes.Events).Methods("GET")
+	return router
+}
+
+// Nodes отдает доступные узлы в виде {"адрес": {"host": ..., "port": ..., ...}}.
+// ETag - версия списка, на запрос с тем же If-None-Match отвечает 304 без тела
+func (routes *Routes) Nodes(w http.ResponseWriter, r *http.Request) {
+	etag := fmt.Sprintf("\"%s\"", routes.registryUC.Version())
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(routes.registryUC.Nodes())
+}
+
+// Events отправляет подписчику snapshot списка узлов и затем его изменения (см. entity.Event).
+// Сообщения от клиента не ожидаются, чтение нужно только для обнаружения закрытия
+func (routes *Routes) Events(w http.ResponseWriter, r *http.Request) {
+	connection, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("ws - Events - upgrade: %v\n", err)
+		return
+	}
+	defer connection.Close()
+	connection.SetReadLimit(maxMessageSize)
+	events, unsubscribe := routes.registryUC.Subscribe()
+	defer unsubscribe()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := connection.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(routes.connectionTimeout / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err := connection.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				_ = connection.WriteMessage(
+					websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber is too slow"),
+				)
+				return
+			}
+			if err := connection.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// register проводит регистрацию узла: отправляет challenge, проверяет подпись ответа,
+// подтверждает регистрацию (0xc8) и принимает первый подписанный анонс
+func (routes *Routes) register(connection *websocket.Conn) (*entity.Announcement, error) {
+	_ = connection.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	challenge, err := routes.cryptoUC.GenerateChallenge()
+	if err != nil {
+		return nil, err
+	}
+	if err := connection.WriteMessage(websocket.BinaryMessage, challenge); err != nil {
+		return nil, err
+	}
+	mt, response, err := connection.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if mt != websocket.BinaryMessage {
+		return nil, fmt.Errorf("wrong message type received: %d", mt)
+	}
+	addr, err := routes.cryptoUC.VerifyChallenge(challenge, response)
+	if err != nil {
+		_ = connection.WriteMessage(websocket.BinaryMessage, []byte{0x01, 0x91})
+		return nil, err
+	}
+	if err := connection.WriteMessage(websocket.BinaryMessage, []byte{0xc8}); err != nil {
+		return nil, err
+	}
+	mt, message, err := connection.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	announcement, err := routes.parseAnnouncement(mt, message)
+	if err != nil {
+		return nil, err
+	}
+	if announcement.Addr != addr {
+		return nil, fmt.Errorf("announcement signed by %s, challenge passed by %s", announcement.Addr, addr)
+	}
+	return announcement, nil
+}
+
+func (routes *Routes) parseAnnouncement(mt int, message []byte) (*entity.Announcement, error) {
+	if mt != websocket.TextMessage {
+		return nil, fmt.Errorf("wrong message type received: %d", mt)
+	}
+	signed := &entity.SignedAnnouncement{}
+	if err := json.Unmarshal(message, signed); err != nil {
+		return nil, err
+	}
+	return routes.cryptoUC.VerifyAnnouncement(signed)
+}
+
+// Connect держит подключение узла: после регистрации узел остается в списке, пока присылает
+// ping или анонсы хотя бы раз в connectionTimeout. Каждый анонс заменяет предыдущий
+func (routes *Routes) Connect(w http.ResponseWriter, r *http.Request) {
+	connection, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("ws - Connect - upgrade: %v\n", err)
+		return
+	}
+	defer connection.Close()
+	connection.SetReadLimit(maxMessageSize)
+
+	announcement, err := routes.register(connection)
+	if err != nil {
+		log.Printf("ws - Connect - register: %v\n", err)
+		return
+	}
+	ipAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ipAddr = r.RemoteAddr
+	}
+	sessionBytes := make([]byte, 16)
+	_, _ = rand.Read(sessionBytes)
+	session := hex.EncodeToString(sessionBytes)
+	if err := routes.registryUC.Register(session, ipAddr, announcement); err != nil {
+		log.Printf("ws - Connect - register %s: %v\n", announcement.Addr, err)
+		return
+	}
+	addr := announcement.Addr
+	log.Printf("ws - Connect - node %s registered from %s\n", addr, ipAddr)
+	defer func() {
+		routes.registryUC.Remove(session, addr)
+		log.Printf("ws - Connect - node %s disconnected\n", addr)
+	}()
+
+	_ = connection.SetReadDeadline(time.Now().Add(routes.connectionTimeout))
+	connection.SetPingHandler(func(data string) error {
+		_ = connection.SetReadDeadline(time.Now().Add(routes.connectionTimeout))
+		err := connection.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+	for {
+		// ошибка чтения означает разрыв или таймаут подключения
+		mt, message, err := connection.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = connection.SetReadDeadline(time.Now().Add(routes.connectionTimeout))
+		announcement, err := routes.parseAnnouncement(mt, message)
+		if err != nil {
+			log.Printf("ws - Connect - announcement of %s: %v\n", addr, err)
+			continue
+		}
+		if announcement.Addr != addr {
+			log.Printf("ws - Connect - node %s sent announcement of %s\n", addr, announcement.Addr)
+			continue
+		}
+		if err := routes.registryUC.Update(session, announcement); err != nil {
+			log.Printf("ws - Connect - update %s: %v\n", addr, err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package ws
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/s1lur/distorage/tracker/internal/entity"
+	"github.com/s1lur/distorage/tracker/internal/usecase"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientNode повторяет entity.Node клиента (cli/internal/entity/node.go), который разбирает /nodes
+type clientNode struct {
+	Addr            string `json:"addr"`
+	Host            string `json:"host"`
+	Port            string `json:"port"`
+	Scheme          string `json:"scheme"`
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+	Version         string `json:"version"`
+	ProtocolVersion int    `json:"protocol_version"`
+	Region          string `json:"region,omitempty"`
+	Zone            string `json:"zone,omitempty"`
+	HostID          string `json:"host_id,omitempty"`
+	TotalCapacity   uint64 `json:"total_capacity"`
+	FreeCapacity    uint64 `json:"free_capacity"`
+	Uptime          int64  `json:"uptime"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+// testDaemon подписывает challenge и анонсы так же, как демон (daemon/internal/usecase/crypto.go)
+type testDaemon struct {
+	key  *ecdsa.PrivateKey
+	addr string
+}
+
+func newTestDaemon(t *testing.T) *testDaemon {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := hex.EncodeToString(usecase.NewCryptoUC().GetAddress(pubKeyBytes))
+	return &testDaemon{key: key, addr: addr}
+}
+
+func (d *testDaemon) sign(t *testing.T, prefix string, message []byte) ([]byte, []byte) {
+	t.Helper()
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&d.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := append([]byte(prefix), message...)
+	sig, err := ecdsa.SignASN1(rand.Reader, d.key, usecase.NewCryptoUC().Hash(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKeyBytes, sig
+}
+
+func (d *testDaemon) signChallenge(t *testing.T, challenge []byte) []byte {
+	pubKeyBytes, sig := d.sign(t, usecase.CHALLENGE_PREFIX, challenge)
+	response := append([]byte{byte(len(pubKeyBytes))}, pubKeyBytes...)
+	return append(response, sig...)
+}
+
+func (d *testDaemon) announce(t *testing.T, announcement entity.Announcement) *entity.SignedAnnouncement {
+	announcement.Addr = d.addr
+	announcementBytes, err := json.Marshal(announcement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKeyBytes, sig := d.sign(t, usecase.ANNOUNCEMENT_PREFIX, announcementBytes)
+	return &entity.SignedAnnouncement{
+		Announcement: announcementBytes,
+		PubKey:       hex.EncodeToString(pubKeyBytes),
+		Signature:    hex.EncodeToString(sig),
+	}
+}
+
+// handshake проходит регистрацию, как trackerHandshake демона (daemon/internal/app/tracker.go)
+func (d *testDaemon) handshake(t *testing.T, conn *websocket.Conn, announcement entity.Announcement) error {
+	t.Helper()
+	mt, challenge, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if mt != websocket.BinaryMessage {
+		t.Fatalf("wrong message type received: %d", mt)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, d.signChallenge(t, challenge)); err != nil {
+		return err
+	}
+	mt, message, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if mt != websocket.BinaryMessage || !bytes.Equal(message, []byte{0xc8}) {
+		t.Fatalf("tracker rejected registration: %x", message)
+	}
+	return conn.WriteJSON(d.announce(t, announcement))
+}
+
+func newTestTracker(t *testing.T) *httptest.Server {
+	t.Helper()
+	registry := usecase.NewRegistryUC("", 0, time.Minute)
+	server := httptest.NewServer(RegisterRoutes(usecase.NewCryptoUC(), registry, 30*time.Second))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dialTracker(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/connect", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func getNodes(t *testing.T, server *httptest.Server, etag string) (*http.Response, map[string]clientNode) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/nodes", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	nodes := make(map[string]clientNode)
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&nodes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, nodes
+}
+
+// waitNode ждет, пока узел появится в /nodes с анонсом не старше timestamp
+func waitNode(t *testing.T, server *httptest.Server, addr string, timestamp int64) (*http.Response, clientNode) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, nodes := getNodes(t, server, "")
+		if node, ok := nodes[addr]; ok && node.Timestamp >= timestamp {
+			return resp, node
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("node %s didn't appear in /nodes", addr)
+	return nil, clientNode{}
+}
+
+func TestHandshakeAndNodes(t *testing.T) {
+	server := newTestTracker(t)
+	daemon := newTestDaemon(t)
+	conn := dialTracker(t, server)
+
+	now := time.Now().Unix()
+	announcement := entity.Announcement{
+		Port:            "53591",
+		Version:         "dev",
+		ProtocolVersion: 1,
+		Zone:            "z1",
+		HostID:          "host",
+		TotalCapacity:   1000,
+		FreeCapacity:    600,
+		Uptime:          5,
+		Timestamp:       now,
+	}
+	if err := daemon.handshake(t, conn, announcement); err != nil {
+		t.Fatal(err)
+	}
+	resp, node := waitNode(t, server, daemon.addr, now)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("content type %q", contentType)
+	}
+
+	// пустые host и scheme заменяются адресом подключения и ws, как ожидает клиент
+	expected := clientNode{
+		Addr:            daemon.addr,
+		Host:            "127.0.0.1",
+		Port:            "53591",
+		Scheme:          "ws",
+		Version:         "dev",
+		ProtocolVersion: 1,
+		Zone:            "z1",
+		HostID:          "host",
+		TotalCapacity:   1000,
+		FreeCapacity:    600,
+		Uptime:          5,
+		Timestamp:       now,
+	}
+	if node != expected {
+		t.Fatalf("node = %+v, want %+v", node, expected)
+	}
+}
+
+func TestHeartbeatKeepsETag(t *testing.T) {
+	server := newTestTracker(t)
+	daemon := newTestDaemon(t)
+	conn := dialTracker(t, server)
+
+	now := time.Now().Unix()
+	announcement := entity.Announcement{Port: "53591", Timestamp: now - 2, FreeCapacity: 600}
+	if err := daemon.handshake(t, conn, announcement); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := waitNode(t, server, daemon.addr, now-2)
+	etag := resp.Header.Get("ETag")
+
+	// heartbeat меняет только емкость и timestamp, клиент получает 304
+	announcement.Timestamp = now - 1
+	announcement.FreeCapacity = 500
+	if err := conn.WriteJSON(daemon.announce(t, announcement)); err != nil {
+		t.Fatal(err)
+	}
+	resp, node := waitNode(t, server, daemon.addr, now-1)
+	if node.FreeCapacity != 500 {
+		t.Errorf("free capacity %d, want 500", node.FreeCapacity)
+	}
+	if resp.Header.Get("ETag") != etag {
+		t.Errorf("etag changed on heartbeat: %s -> %s", etag, resp.Header.Get("ETag"))
+	}
+	if resp, _ := getNodes(t, server, etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("status %d, want 304", resp.StatusCode)
+	}
+
+	// смена порта меняет то, как к узлу подключаться
+	announcement.Timestamp = now
+	announcement.Port = "53592"
+	if err := conn.WriteJSON(daemon.announce(t, announcement)); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = waitNode(t, server, daemon.addr, now)
+	if resp.Header.Get("ETag") == etag {
+		t.Errorf("etag didn't change with the node's port")
+	}
+}
+
+func TestHandshakeRejectsWrongSignature(t *testing.T) {
+	server := newTestTracker(t)
+	daemon := newTestDaemon(t)
+	conn := dialTracker(t, server)
+
+	_, challenge, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// подпись другого challenge'а
+	challenge[0] ^= 0xff
+	if err := conn.WriteMessage(websocket.BinaryMessage, daemon.signChallenge(t, challenge)); err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(message, []byte{0x01, 0x91}) {
+		t.Fatalf("message %x, want 0191", message)
+	}
+	if _, nodes := getNodes(t, server, ""); len(nodes) != 0 {
+		t.Fatalf("nodes = %v, want none", nodes)
+	}
+}
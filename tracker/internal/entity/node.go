@@ -0,0 +1,52 @@
+package entity
+
+// Announcement - информация об узле, которую он сообщает трекеру при подключении и на каждом heartbeat.
+// Совпадает с анонсом демона, пустой Host заменяется адресом, с которого пришло подключение
+type Announcement struct {
+	Addr            string `json:"addr"`
+	Host            string `json:"host"`
+	Port            string `json:"port"`
+	Scheme          string `json:"scheme"`
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+	Version         string `json:"version"`
+	ProtocolVersion int    `json:"protocol_version"`
+	Region          string `json:"region,omitempty"`
+	Zone            string `json:"zone,omitempty"`
+	HostID          string `json:"host_id,omitempty"`
+	TotalCapacity   uint64 `json:"total_capacity"`
+	FreeCapacity    uint64 `json:"free_capacity"`
+	Uptime          int64  `json:"uptime"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+// SignedAnnouncement - анонс вместе с подписью ключа узла, Announcement - подписанные байты JSON
+type SignedAnnouncement struct {
+	Announcement []byte `json:"announcement"`
+	PubKey       string `json:"pub_key"`
+	Signature    string `json:"signature"`
+}
+
+// Node - зарегистрированный узел. Session - идентификатор подключения, через которое узел
+// зарегистрирован, пустой у узлов, восстановленных из файла состояния
+type Node struct {
+	Announcement Announcement `json:"announcement"`
+	IPAddr       string       `json:"ip_addr"`
+	Session      string       `json:"-"`
+	LastSeen     int64        `json:"last_seen"`
+}
+
+// Event - изменение списка узлов, рассылаемое подписчикам /events.
+// Первым событием подписчик получает snapshot со всем списком, затем join, update и leave
+type Event struct {
+	Type  string                  `json:"type"`
+	Addr  string                  `json:"addr,omitempty"`
+	Node  *Announcement           `json:"node,omitempty"`
+	Nodes map[string]Announcement `json:"nodes,omitempty"`
+}
+
+const (
+	EventSnapshot = "snapshot"
+	EventJoin     = "join"
+	EventUpdate   = "update"
+	EventLeave    = "leave"
+)
@@ -0,0 +1,112 @@
+package usecase
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/s1lur/distorage/tracker/internal/entity"
+	"github.com/wealdtech/go-merkletree/keccak256"
+)
+
+// CHALLENGE_PREFIX добавляется в начало подписываемого узлом challenge'а
+const CHALLENGE_PREFIX = "distorage-tracker-challenge"
+
+// CHALLENGE_SIZE - размер случайного challenge'а в байтах
+const CHALLENGE_SIZE = 32
+
+// ANNOUNCEMENT_PREFIX добавляется в начало подписываемого анонса узла
+const ANNOUNCEMENT_PREFIX = "distorage-announcement"
+
+// CryptoUC проверяет подписи узлов
+type CryptoUC struct{}
+
+// NewCryptoUC создает экземпляр CryptoUC
+func NewCryptoUC() *CryptoUC {
+	return &CryptoUC{}
+}
+
+// GenerateChallenge возвращает случайный challenge для регистрации узла
+func (c *CryptoUC) GenerateChallenge() ([]byte, error) {
+	challenge := make([]byte, CHALLENGE_SIZE)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// VerifyChallenge проверяет ответ узла на challenge и возвращает адрес, которым узел владеет.
+//
+// Ответ имеет вид [1 байт длина ключа][публичный ключ в формате PKIX][подпись],
+// где подпись ставится на keccak256 хэш от CHALLENGE_PREFIX и challenge'а
+func (c *CryptoUC) VerifyChallenge(challenge []byte, response []byte) (string, error) {
+	if len(response) < 1 || len(response) < 1+int(response[0]) {
+		return "", errors.New("response too short")
+	}
+	pubKeyBytes := response[1 : 1+response[0]]
+	sig := response[1+response[0]:]
+	msg := make([]byte, 0, len(CHALLENGE_PREFIX)+len(challenge))
+	msg = append(msg, CHALLENGE_PREFIX...)
+	msg = append(msg, challenge...)
+	if err := c.verifySignature(pubKeyBytes, c.Hash(msg), sig); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(c.GetAddress(pubKeyBytes)), nil
+}
+
+// VerifyAnnouncement проверяет подпись анонса и то, что анонсированный адрес принадлежит ключу подписи.
+// Подпись ставится на keccak256 хэш от ANNOUNCEMENT_PREFIX и анонса
+func (c *CryptoUC) VerifyAnnouncement(signed *entity.SignedAnnouncement) (*entity.Announcement, error) {
+	pubKeyBytes, err := hex.DecodeString(signed.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 0, len(ANNOUNCEMENT_PREFIX)+len(signed.Announcement))
+	msg = append(msg, ANNOUNCEMENT_PREFIX...)
+	msg = append(msg, signed.Announcement...)
+	if err := c.verifySignature(pubKeyBytes, c.Hash(msg), sig); err != nil {
+		return nil, err
+	}
+	announcement := &entity.Announcement{}
+	if err := json.Unmarshal(signed.Announcement, announcement); err != nil {
+		return nil, err
+	}
+	if announcement.Addr != hex.EncodeToString(c.GetAddress(pubKeyBytes)) {
+		return nil, errors.New("announcement address mismatch")
+	}
+	return announcement, nil
+}
+
+// GetAddress вычисляет адрес узла из его публичного ключа
+func (c *CryptoUC) GetAddress(pubKeyBytes []byte) []byte {
+	return c.Hash(pubKeyBytes)[12:]
+}
+
+// Hash вычисляет keccak256 хэш от переданных данных
+func (c *CryptoUC) Hash(contents []byte) []byte {
+	keccak := keccak256.New()
+	return keccak.Hash(contents)
+}
+
+func (c *CryptoUC) verifySignature(pubKeyBytes []byte, hash []byte, sig []byte) error {
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		return err
+	}
+	switch v := pubKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(v, hash, sig) {
+			return errors.New("signature check failed")
+		}
+	default:
+		return fmt.Errorf("recived wrong key type: %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/s1lur/distorage/tracker/internal/entity"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer - сколько событий может накопиться у подписчика; медленный подписчик отключается
+const subscriberBuffer = 256
+
+// RegistryUC хранит список подключенных узлов.
+//
+// Узел регистрируется на время подключения; если узел переподключился раньше, чем старое
+// подключение закрылось по таймауту, запись переходит к новому подключению.
+// Если задан statePath, список сохраняется в файл и после перезапуска трекера сохраненные узлы
+// отдаются клиентам еще restoreTTL, чтобы список не был пустым, пока узлы переподключаются
+type RegistryUC struct {
+	statePath  string
+	restoreTTL time.Duration
+	maxSkew    time.Duration
+
+	mu          sync.RWMutex
+	nodes       map[string]*entity.Node
+	restoredAt  time.Time
+	dirty       bool
+	epoch       string
+	version     uint64
+	subscribers map[chan entity.Event]bool
+}
+
+// NewRegistryUC создает экземпляр RegistryUC, statePath может быть пустым
+func NewRegistryUC(statePath string, restoreTTL time.Duration, maxSkew time.Duration) *RegistryUC {
+	epoch := make([]byte, 4)
+	_, _ = rand.Read(epoch)
+	return &RegistryUC{
+		statePath:   statePath,
+		restoreTTL:  restoreTTL,
+		maxSkew:     maxSkew,
+		nodes:       make(map[string]*entity.Node),
+		epoch:       hex.EncodeToString(epoch),
+		subscribers: make(map[chan entity.Event]bool),
+	}
+}
+
+// checkTimestamp отклоняет анонсы из будущего, они могли бы перебить все последующие анонсы узла
+func (r *RegistryUC) checkTimestamp(announcement *entity.Announcement) error {
+	if time.Unix(announcement.Timestamp, 0).After(time.Now().Add(r.maxSkew)) {
+		return errors.New("announcement timestamp is in the future")
+	}
+	return nil
+}
+
+// Register записывает узел, прошедший challenge, за подключением session
+func (r *RegistryUC) Register(session string, ipAddr string, announcement *entity.Announcement) error {
+	if err := r.checkTimestamp(announcement); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if known, exists := r.nodes[announcement.Addr]; exists && known.Announcement.Timestamp > announcement.Timestamp {
+		return errors.New("announcement is older than the registered one")
+	}
+	node := &entity.Node{
+		Announcement: *announcement,
+		IPAddr:       ipAddr,
+		Session:      session,
+		LastSeen:     time.Now().Unix(),
+	}
+	r.nodes[announcement.Addr] = node
+	r.changed(entity.EventJoin, node)
+	return nil
+}
+
+// Update заменяет анонс узла, зарегистрированного за подключением session.
+// Повторно присланные старые анонсы не принимаются
+func (r *RegistryUC) Update(session string, announcement *entity.Announcement) error {
+	if err := r.checkTimestamp(announcement); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	node, exists := r.nodes[announcement.Addr]
+	if !exists || node.Session != session {
+		return fmt.Errorf("node %s is not registered on this connection", announcement.Addr)
+	}
+	if announcement.Timestamp < node.Announcement.Timestamp {
+		return errors.New("announcement is older than the registered one")
+	}
+	// heartbeat меняет только timestamp и емкость; версия списка и событие update нужны,
+	// только если изменилось то, как к узлу подключаться
+	moved := !sameEndpoint(node.Announcement, *announcement)
+	node.Announcement = *announcement
+	node.LastSeen = time.Now().Unix()
+	r.dirty = true
+	if moved {
+		r.changed(entity.EventUpdate, node)
+	}
+	return nil
+}
+
+// sameEndpoint сравнивает адрес и сертификат, по которым клиенты подключаются к узлу
+func sameEndpoint(a entity.Announcement, b entity.Announcement) bool {
+	return a.Host == b.Host && a.Port == b.Port && a.Scheme == b.Scheme && a.CertFingerprint == b.CertFingerprint
+}
+
+// Remove удаляет узел, если он все еще зарегистрирован за подключением session
+func (r *RegistryUC) Remove(session string, addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if node, exists := r.nodes[addr]; exists && node.Session == session {
+		delete(r.nodes, addr)
+		r.changed(entity.EventLeave, node)
+	}
+}
+
+// changed отмечает изменение списка и рассылает событие подписчикам, вызывается под r.mu
+func (r *RegistryUC) changed(eventType string, node *entity.Node) {
+	r.dirty = true
+	r.version += 1
+	event := entity.Event{Type: eventType, Addr: node.Announcement.Addr}
+	if eventType != entity.EventLeave {
+		announcement := r.public(node)
+		event.Node = &announcement
+	}
+	for subscriber := range r.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// подписчик не успевает читать события, пусть переподпишется и получит snapshot
+			delete(r.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// public возвращает анонс узла в том виде, в котором его видят клиенты
+func (r *RegistryUC) public(node *entity.Node) entity.Announcement {
+	announcement := node.Announcement
+	if announcement.Host == "" {
+		announcement.Host = node.IPAddr
+	}
+	if announcement.Scheme == "" {
+		announcement.Scheme = "ws"
+	}
+	return announcement
+}
+
+// Version возвращает версию списка узлов, меняющуюся при подключении и отключении узлов, смене их адреса
+// и при перезапуске трекера
+func (r *RegistryUC) Version() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	restored := ""
+	if time.Since(r.restoredAt) < r.restoreTTL {
+		restored = "r"
+	}
+	return fmt.Sprintf("%s-%d%s", r.epoch, r.version, restored)
+}
+
+// Subscribe возвращает канал событий об изменении списка, первое событие - snapshot.
+// Вторым значением возвращается функция отписки
+func (r *RegistryUC) Subscribe() (<-chan entity.Event, func()) {
+	events := make(chan entity.Event, subscriberBuffer)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events <- entity.Event{Type: entity.EventSnapshot, Nodes: r.nodesLocked()}
+	r.subscribers[events] = true
+	return events, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.subscribers[events] {
+			delete(r.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// Nodes возвращает анонсы доступных узлов по адресам; пустой Host заменяется IP подключения
+func (r *RegistryUC) Nodes() map[string]entity.Announcement {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.nodesLocked()
+}
+
+func (r *RegistryUC) nodesLocked() map[string]entity.Announcement {
+	restoredValid := time.Since(r.restoredAt) < r.restoreTTL
+	nodes := make(map[string]entity.Announcement, len(r.nodes))
+	for addr, node := range r.nodes {
+		if node.Session == "" && !restoredValid {
+			continue
+		}
+		nodes[addr] = r.public(node)
+	}
+	return nodes
+}
+
+// Load восстанавливает список узлов из файла состояния, отсутствие файла ошибкой не считается
+func (r *RegistryUC) Load() error {
+	if r.statePath == "" {
+		return nil
+	}
+	file, err := os.Open(r.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	nodes := make(map[string]*entity.Node)
+	if err := json.NewDecoder(file).Decode(&nodes); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for addr, node := range nodes {
+		if _, exists := r.nodes[addr]; !exists {
+			node.Session = ""
+			r.nodes[addr] = node
+		}
+	}
+	r.restoredAt = time.Now()
+	return nil
+}
+
+// Save записывает список узлов в файл состояния, если он изменился.
+// Файл заменяется атомарно через временный файл
+func (r *RegistryUC) Save() error {
+	if r.statePath == "" {
+		return nil
+	}
+	r.mu.Lock()
+	// восстановленные узлы, которые так и не переподключились, больше не сохраняются
+	if time.Since(r.restoredAt) >= r.restoreTTL {
+		for addr, node := range r.nodes {
+			if node.Session == "" {
+				delete(r.nodes, addr)
+				r.dirty = true
+			}
+		}
+	}
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	nodes := make(map[string]entity.Node, len(r.nodes))
+	for addr, node := range r.nodes {
+		nodes[addr] = *node
+	}
+	r.dirty = false
+	r.mu.Unlock()
+
+	if err := r.writeState(nodes); err != nil {
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (r *RegistryUC) writeState(nodes map[string]entity.Node) error {
+	tmp, err := os.CreateTemp(filepath.Dir(r.statePath), filepath.Base(r.statePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(nodes); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.statePath)
+}
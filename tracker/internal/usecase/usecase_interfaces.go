@@ -0,0 +1,24 @@
+package usecase
+
+import (
+	"github.com/s1lur/distorage/tracker/internal/entity"
+)
+
+type Crypto interface {
+	GenerateChallenge() ([]byte, error)
+	VerifyChallenge(challenge []byte, response []byte) (string, error)
+	VerifyAnnouncement(signed *entity.SignedAnnouncement) (*entity.Announcement, error)
+	GetAddress(pubKeyBytes []byte) []byte
+	Hash(contents []byte) []byte
+}
+
+type Registry interface {
+	Register(session string, ipAddr string, announcement *entity.Announcement) error
+	Update(session string, announcement *entity.Announcement) error
+	Remove(session string, addr string)
+	Nodes() map[string]entity.Announcement
+	Version() string
+	Subscribe() (<-chan entity.Event, func())
+	Load() error
+	Save() error
+}
@@ -0,0 +1,24 @@
+package wsserver
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+type Option func(*Server)
+
+func Port(port string) Option {
+	return func(s *Server) {
+		s.server.Addr = net.JoinHostPort("0.0.0.0", port)
+	}
+}
+
+// TLS включает TLS (wss://) с переданным сертификатом
+func TLS(cert tls.Certificate) Option {
+	return func(s *Server) {
+		s.server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+}
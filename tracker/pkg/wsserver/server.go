@@ -0,0 +1,71 @@
+package wsserver
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	_defaultAddr           = ":80"
+	_defaultShutdownTimout = 3 * time.Second
+)
+
+type Server struct {
+	server          *http.Server
+	notify          chan error
+	shutdownTimeout time.Duration
+}
+
+func New(handler http.Handler, opts ...Option) *Server {
+	httpServer := &http.Server{
+		Handler: handler,
+		Addr:    _defaultAddr,
+	}
+
+	s := &Server{
+		server:          httpServer,
+		notify:          make(chan error, 1),
+		shutdownTimeout: _defaultShutdownTimout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.start()
+
+	return s
+}
+
+// start открывает порт синхронно, чтобы после New сервер уже принимал подключения,
+// а обслуживание запросов запускает в отдельной горутине
+func (s *Server) start() {
+	log.Printf("Starting server on port %s", s.server.Addr)
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		s.notify <- err
+		close(s.notify)
+		return
+	}
+	if s.server.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.server.TLSConfig)
+	}
+	go func() {
+		s.notify <- s.server.Serve(listener)
+		close(s.notify)
+	}()
+}
+
+func (s *Server) Notify() <-chan error {
+	return s.notify
+}
+
+func (s *Server) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	return s.server.Shutdown(ctx)
+}